@@ -0,0 +1,87 @@
+// Copyright © 2017 Syndesis Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/syndesisio/pure-bot/pkg/config"
+	"github.com/syndesisio/pure-bot/pkg/webhook"
+)
+
+// configCmd groups the commands dealing with the config file
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Config file utilities",
+	Long:  `Config file utilities.`,
+}
+
+// configValidateCmd represents the config validate command
+var configValidateCmd = &cobra.Command{
+	Use:   "validate [file]",
+	Short: "Validates a config file",
+	Long: `Validates a config file, by default the one given with --config.
+
+Besides invalid YAML and WIP patterns, unknown keys and handler names, board
+columns without an ID and board events which are mapped twice or never
+emitted are reported. The exit code is non-zero if any problem is found.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		file := v.ConfigFileUsed()
+		if len(args) > 0 {
+			file = args[0]
+		}
+		if file == "" {
+			fmt.Fprintln(os.Stderr, "No config file given")
+			os.Exit(2)
+		}
+
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		problems, err := config.Lint(data, config.LintOptions{
+			BoardEvents: webhook.BoardEvents(),
+			Handlers:    webhook.HandlerNames(),
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+			os.Exit(1)
+		}
+		for _, problem := range problems {
+			if problem.Line > 0 {
+				fmt.Printf("%s:%d: %s\n", file, problem.Line, problem)
+			} else {
+				fmt.Printf("%s: %s\n", file, problem)
+			}
+		}
+		if len(problems) > 0 {
+			fmt.Printf("%d problem(s) found\n", len(problems))
+			os.Exit(1)
+		}
+		fmt.Printf("%s is valid\n", file)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configValidateCmd)
+}
@@ -0,0 +1,58 @@
+// Copyright © 2017 Syndesis Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/syndesisio/pure-bot/pkg/webhook"
+)
+
+// reconcileCmd represents the reconcile command
+var reconcileCmd = &cobra.Command{
+	Use:   "reconcile [owner/name]...",
+	Short: "Re-evaluates all open pull requests",
+	Long: `Re-evaluates all open pull requests.
+
+Walks every open pull request of the repositories the GitHub App is installed
+on, or only of the given repositories, and updates the WIP and review statuses
+and merges approved pull requests as if an event had arrived. Use it after the
+bot was down or webhooks were lost.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if cmd.Flags().Changed("concurrency") {
+			botConfig.Reconcile.Concurrency, _ = cmd.Flags().GetInt("concurrency")
+		}
+		if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+			botConfig.ForceDryRun()
+		}
+		if err := botConfig.Validate(); err != nil {
+			logger.Fatal("Invalid config, see 'pure-bot config validate'", zap.Error(err))
+		}
+
+		if err := webhook.Reconcile(botConfig, args, logger.Named("reconcile")); err != nil {
+			logger.Fatal("reconciliation finished with errors", zap.String("error", fmt.Sprintf("%+v", err)))
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(reconcileCmd)
+
+	reconcileCmd.Flags().Bool("dry-run", false, "Only log the changes handlers would make")
+	reconcileCmd.Flags().Int("concurrency", 0, "Number of pull requests evaluated at once (default is reconcile.concurrency)")
+}
@@ -0,0 +1,95 @@
+// Copyright © 2017 Syndesis Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	gohttp "net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/syndesisio/pure-bot/pkg/config"
+	"github.com/syndesisio/pure-bot/pkg/http"
+	"github.com/syndesisio/pure-bot/pkg/webhook"
+)
+
+var recordDir string
+
+// recordCmd represents the record command
+var recordCmd = &cobra.Command{
+	Use:   "record",
+	Short: "Records incoming webhooks without handling them",
+	Long: `Records incoming webhooks without handling them.
+
+Every delivery is written with its headers to a JSON file in the recording
+directory. Use 'pure-bot replay' to feed the recordings to the handlers.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		// The flags are shared with the run command and thus can't be bound
+		// to the same viper keys
+		if cmd.Flags().Changed("webhook-secret") {
+			botConfig.Webhook.Secret, _ = cmd.Flags().GetString("webhook-secret")
+		}
+		if cmd.Flags().Changed("bind-address") {
+			botConfig.HTTP.Address, _ = cmd.Flags().GetString("bind-address")
+		}
+		if cmd.Flags().Changed("bind-port") {
+			botConfig.HTTP.Port, _ = cmd.Flags().GetInt("bind-port")
+		}
+
+		recordHandler, err := webhook.NewRecordingHandler(config.NewStore(botConfig), recordDir, nil, logger.Named("record"))
+		if err != nil {
+			logger.Fatal("failed to create recording handler", zap.Error(err))
+		}
+
+		mux := gohttp.NewServeMux()
+		mux.HandleFunc("/", recordHandler)
+
+		srv := http.New(botConfig.HTTP, mux)
+
+		c := make(chan os.Signal, 2)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := srv.Start(); err != nil {
+				if errors.Cause(err) != gohttp.ErrServerClosed {
+					logger.Fatal("web server failed", zap.Error(err))
+				}
+			}
+		}()
+		go func() {
+			<-c
+			if err := srv.Stop(); err != nil {
+				logger.Fatal("failed to stop web server", zap.Error(err))
+			}
+		}()
+		wg.Wait()
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(recordCmd)
+
+	recordCmd.Flags().StringVar(&recordDir, "dir", "recordings", "Directory to write recorded webhooks to")
+	recordCmd.Flags().String("webhook-secret", "", "Secret to validate incoming webhooks")
+	recordCmd.Flags().String("bind-address", "", "Address to bind to")
+	recordCmd.Flags().Int("bind-port", 8080, "Port to bind to")
+}
@@ -0,0 +1,90 @@
+// Copyright © 2017 Syndesis Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/syndesisio/pure-bot/pkg/queue"
+	"github.com/syndesisio/pure-bot/pkg/webhook"
+)
+
+var replayEventType string
+
+// replayCmd represents the replay command
+var replayCmd = &cobra.Command{
+	Use:   "replay <file|dir>...",
+	Short: "Replays recorded webhooks through the handlers",
+	Long: `Replays recorded webhooks through the handlers.
+
+Accepts recordings written by 'pure-bot record' as well as plain payloads as
+shown on the GitHub App's "Advanced" page. For plain payloads the event type
+is taken from --event or from the file name (e.g. testdata/status.json).
+Point --github-api-url to a local fake of the GitHub API to avoid touching
+real repositories.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// The flags are shared with the run command and thus can't be bound
+		// to the same viper keys
+		if cmd.Flags().Changed("github-api-url") {
+			botConfig.GitHubApp.BaseURL, _ = cmd.Flags().GetString("github-api-url")
+		}
+		if cmd.Flags().Changed("github-app-id") {
+			botConfig.GitHubApp.AppID, _ = cmd.Flags().GetInt64("github-app-id")
+		}
+		if cmd.Flags().Changed("github-app-private-key") {
+			botConfig.GitHubApp.PrivateKeyFile, _ = cmd.Flags().GetString("github-app-private-key")
+		}
+		if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+			botConfig.ForceDryRun()
+		}
+
+		replayLogger := logger.Named("replay")
+
+		failed := 0
+		for _, path := range args {
+			recordings, err := webhook.LoadRecordings(path, replayEventType)
+			if err != nil {
+				logger.Fatal("failed to load recordings", zap.String("path", path), zap.Error(err))
+			}
+
+			for _, recording := range recordings {
+				l := replayLogger.With(zap.String("file", recording.File), zap.String("messageType", recording.EventType()))
+				l.Info("Replaying webhook")
+				if err := webhook.Dispatch(&queue.Event{Type: recording.EventType(), Payload: recording.Payload}, botConfig, l); err != nil {
+					l.Error("replay failed", zap.String("error", fmt.Sprintf("%+v", err)))
+					failed++
+				}
+			}
+		}
+
+		if failed > 0 {
+			logger.Fatal("replay finished with errors", zap.Int("failed", failed))
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(replayCmd)
+
+	replayCmd.Flags().StringVar(&replayEventType, "event", "", "Event type of plain payloads (default is the file name)")
+	replayCmd.Flags().Bool("dry-run", false, "Only log the changes handlers would make")
+	replayCmd.Flags().String("github-api-url", "", "GitHub API to send requests to (default https://api.github.com)")
+	replayCmd.Flags().Int64("github-app-id", 0, "GitHub App ID")
+	replayCmd.Flags().String("github-app-private-key", "", "GitHub app private key file")
+}
@@ -16,7 +16,11 @@ package cmd
 
 import (
 	"flag"
+	"path/filepath"
+	"reflect"
+	"sync"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
@@ -112,6 +116,96 @@ func initConfig() {
 	if err := v.Unmarshal(&botConfig); err != nil {
 		logger.Fatal("Failed to unmarshal config file", zap.Error(err))
 	}
-
 	logger.Debug("Using config", zap.Reflect("config", botConfig))
 }
+
+// reloadMu serializes reloads triggered by file changes and signals, which
+// both read the config file with the same viper instance.
+var reloadMu sync.Mutex
+
+// watchConfig reloads the config file into store whenever it changes. Viper's
+// own watcher reads the file outside of reloadMu, so the file is watched
+// here. Its directory is watched, as editors and Kubernetes replace the file
+// or the symlink to it instead of writing to it.
+func watchConfig(store *config.Store) {
+	file := v.ConfigFileUsed()
+	if file == "" {
+		return
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		logger.Error("Failed to watch config file", zap.Error(err))
+		return
+	}
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		logger.Error("Failed to watch config file", zap.Error(err))
+		watcher.Close()
+		return
+	}
+
+	target, _ := filepath.EvalSymlinks(file)
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case e, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				current, _ := filepath.EvalSymlinks(file)
+				written := filepath.Clean(e.Name) == filepath.Clean(file) && e.Op&(fsnotify.Write|fsnotify.Create) != 0
+				if !written && (current == "" || current == target) {
+					continue
+				}
+				target = current
+				logger.Info("Config file changed, reloading", zap.String("file", e.Name))
+				reloadConfig(store)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.Error("Failed to watch config file", zap.Error(err))
+			}
+		}
+	}()
+}
+
+// reloadConfig reads the config file again and makes it the active config of
+// store if it is valid. Otherwise the current config stays active. Events
+// already being handled keep using the config they started with.
+func reloadConfig(store *config.Store) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	if err := v.ReadInConfig(); err != nil {
+		logger.Error("Failed to read config file, keeping current config", zap.Error(err))
+		return
+	}
+	newConfig := config.NewWithDefaults()
+	if err := v.Unmarshal(&newConfig); err != nil {
+		logger.Error("Failed to unmarshal config file, keeping current config", zap.Error(err))
+		return
+	}
+	if err := newConfig.Validate(); err != nil {
+		logger.Error("Invalid config, keeping current config", zap.Error(err))
+		return
+	}
+
+	current := store.Get()
+	for section, changed := range map[string]bool{
+		"http":               !reflect.DeepEqual(current.HTTP, newConfig.HTTP),
+		"queue":              !reflect.DeepEqual(current.Queue, newConfig.Queue),
+		"dedup":              !reflect.DeepEqual(current.Dedup, newConfig.Dedup),
+		"webhook.recordDir":  current.Webhook.RecordDir != newConfig.Webhook.RecordDir,
+		"health.checkZenhub": current.Health.CheckZenhub != newConfig.Health.CheckZenhub,
+	} {
+		if changed {
+			logger.Warn("Config change only takes effect after a restart", zap.String("section", section))
+		}
+	}
+
+	store.Set(newConfig)
+	logger.Info("Reloaded config", zap.String("file", v.ConfigFileUsed()))
+	logEnabledHandlers(newConfig)
+	logger.Debug("Using config", zap.Reflect("config", newConfig))
+}
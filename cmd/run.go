@@ -15,17 +15,27 @@
 package cmd
 
 import (
+	"crypto/rsa"
+	"io/ioutil"
 	gohttp "net/http"
 	"os"
 	"os/signal"
+	"sort"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 
+	"github.com/syndesisio/pure-bot/pkg/config"
+	"github.com/syndesisio/pure-bot/pkg/dedup"
+	"github.com/syndesisio/pure-bot/pkg/github/apps"
+	"github.com/syndesisio/pure-bot/pkg/health"
 	"github.com/syndesisio/pure-bot/pkg/http"
+	"github.com/syndesisio/pure-bot/pkg/metrics"
+	"github.com/syndesisio/pure-bot/pkg/queue"
 	"github.com/syndesisio/pure-bot/pkg/webhook"
 )
 
@@ -35,11 +45,65 @@ var runCmd = &cobra.Command{
 	Short: "Runs pure-bot",
 	Long:  `Runs pure-bot.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		githubHandler, err := webhook.NewGithubHTTPHandler(botConfig.Webhook, botConfig, logger.Named("github"))
+		if err := botConfig.Validate(); err != nil {
+			logger.Fatal("Invalid config, see 'pure-bot config validate'", zap.Error(err))
+		}
+		configStore := config.NewStore(botConfig)
+		logEnabledHandlers(botConfig)
+		watchConfig(configStore)
+
+		// Events resumed from the queue must see the persisted state
+		if err := webhook.LoadMergeFreezes(botConfig.Admin.FreezeFile); err != nil {
+			logger.Fatal("failed to load merge freezes", zap.Error(err))
+		}
+		if err := webhook.LoadReviewerAssignments(botConfig.Assignment.StateFile); err != nil {
+			logger.Fatal("failed to load reviewer assignments", zap.Error(err))
+		}
+
+		eventQueue, err := queue.New(botConfig.Queue, logger.Named("queue"))
+		if err != nil {
+			logger.Fatal("failed to create event queue", zap.Error(err))
+		}
+		githubLogger := logger.Named("github")
+		err = eventQueue.Start(botConfig.Queue.Workers, func(event *queue.Event) error {
+			return webhook.Dispatch(event, configStore.Get(), githubLogger.With(zap.String("delivery", event.DeliveryID)))
+		})
+		if err != nil {
+			logger.Fatal("failed to start event queue", zap.Error(err))
+		}
+
+		stopFreezes := make(chan struct{})
+		go webhook.RunMergeFreezes(configStore, time.Minute, stopFreezes, logger.Named("freeze"))
+		stopMergeQueues := make(chan struct{})
+		go webhook.RunMergeQueueTimeouts(configStore, time.Minute, stopMergeQueues, logger.Named("merge-queue"))
+
+		stopReconcile := make(chan struct{})
+		if interval := botConfig.Reconcile.Interval; interval > 0 {
+			go webhook.RunReconciliation(configStore, interval, stopReconcile, logger.Named("reconcile"))
+		}
+		stopReminders := make(chan struct{})
+		if interval := botConfig.Reminders.Interval; interval > 0 {
+			go webhook.RunReviewReminders(configStore, interval, stopReminders, logger.Named("reminders"))
+		}
+
+		deliveries, err := dedup.New(botConfig.Dedup)
+		if err != nil {
+			logger.Fatal("failed to create delivery cache", zap.Error(err))
+		}
+		defer deliveries.Close()
+
+		githubHandler, err := webhook.NewGithubHTTPHandler(configStore, eventQueue, deliveries, githubLogger)
 		if err != nil {
 			logger.Fatal("failed to create webhook handler", zap.Error(err))
 		}
 
+		if botConfig.Webhook.RecordDir != "" {
+			githubHandler, err = webhook.NewRecordingHandler(configStore, botConfig.Webhook.RecordDir, githubHandler, logger.Named("record"))
+			if err != nil {
+				logger.Fatal("failed to create recording handler", zap.Error(err))
+			}
+		}
+
 		zenhubHandler, err := webhook.NewZenhubHTTPHandler(botConfig.Webhook, botConfig, logger.Named("zenhub"))
 		if err != nil {
 			logger.Fatal("failed to create webhook handler", zap.Error(err))
@@ -49,10 +113,24 @@ var runCmd = &cobra.Command{
 		mux := gohttp.NewServeMux()
 		mux.HandleFunc("/", githubHandler)
 		mux.HandleFunc("/zenhub", zenhubHandler)
+		mux.Handle("/metrics", metrics.Handler())
+		mux.Handle("/merge-queue", webhook.MergeQueueHandler())
+		mux.Handle("/admin/merge-freeze", webhook.MergeFreezeHandler(configStore, logger.Named("admin")))
+		mux.Handle("/healthz", health.LivenessHandler())
+		mux.Handle("/readyz", health.ReadinessHandler(readinessChecks(configStore)...))
 
 		// server
 		srv := http.New(botConfig.HTTP, mux)
 
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				logger.Info("Received SIGHUP, reloading config")
+				reloadConfig(configStore)
+			}
+		}()
+
 		c := make(chan os.Signal, 2)
 		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 		var wg sync.WaitGroup
@@ -72,14 +150,86 @@ var runCmd = &cobra.Command{
 			}
 		}()
 		wg.Wait()
+		close(stopFreezes)
+		close(stopMergeQueues)
+		close(stopReconcile)
+		close(stopReminders)
+		eventQueue.Stop()
 	},
 }
 
+// logEnabledHandlers logs the handlers switched on for the defaults and for
+// each entry of repos.
+func logEnabledHandlers(cfg config.Config) {
+	logger.Info("Enabled handlers", zap.String("repos", config.DefaultsLayer), zap.Strings("handlers", webhook.EnabledHandlers(cfg.DefaultRepo)))
+	keys := make([]string, 0, len(cfg.Repos))
+	for key := range cfg.Repos {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		logger.Info("Enabled handlers", zap.String("repos", key), zap.Strings("handlers", webhook.EnabledHandlers(cfg.RepoEntry(key))))
+	}
+}
+
+// readinessChecks verifies that the bot is able to authenticate as the
+// GitHub App and, if enabled, to access the configured ZenHub boards. The
+// checks always use the active configuration.
+func readinessChecks(store *config.Store) []health.Check {
+	checks := []health.Check{
+		{Name: "config", Check: func() error {
+			cfg := store.Get()
+			if cfg.GitHubApp.AppID == 0 {
+				return errors.New("no GitHub App ID configured")
+			}
+			if cfg.GitHubApp.PrivateKeyFile == "" {
+				return errors.New("no GitHub App private key configured")
+			}
+			return nil
+		}},
+		{Name: "privateKey", Check: func() error {
+			_, err := readPrivateKey(store.Get().GitHubApp)
+			return err
+		}},
+		{Name: "jwt", Check: func() error {
+			cfg := store.Get()
+			key, err := readPrivateKey(cfg.GitHubApp)
+			if err != nil {
+				return err
+			}
+			_, err = apps.NewJWT(cfg.GitHubApp.AppID, key)
+			return err
+		}},
+	}
+	// Switching the ZenHub check on or off requires a restart
+	if cfg := store.Get(); cfg.Health.CheckZenhub {
+		checks = append(checks, health.Check{
+			Name: "zenhub",
+			Check: health.Cached(cfg.Health.ZenhubInterval, func() error {
+				return webhook.CheckZenhubTokens(store.Get())
+			}),
+		})
+	}
+	return checks
+}
+
+func readPrivateKey(cfg config.GitHubAppConfig) (*rsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(cfg.PrivateKeyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read private key file")
+	}
+	return apps.ParsePrivateKey(data)
+}
+
 func init() {
 	RootCmd.AddCommand(runCmd)
 
 	runCmd.Flags().String("webhook-secret", "", "Secret to validate incoming webhooks")
 	v.BindPFlag("webhook.secret", runCmd.Flags().Lookup("webhook-secret"))
+	runCmd.Flags().Bool("dry-run", false, "Only log the changes handlers would make, for all repositories")
+	v.BindPFlag("defaults.dryRun", runCmd.Flags().Lookup("dry-run"))
+	runCmd.Flags().String("record-dir", "", "Directory to record incoming webhooks to")
+	v.BindPFlag("webhook.recordDir", runCmd.Flags().Lookup("record-dir"))
 	runCmd.Flags().String("bind-address", "", "Address to bind to")
 	v.BindPFlag("http.address", runCmd.Flags().Lookup("bind-address"))
 	runCmd.Flags().Int("bind-port", 8080, "Port to bind to")
@@ -92,4 +242,8 @@ func init() {
 	v.BindPFlag("github.appId", runCmd.Flags().Lookup("github-app-id"))
 	runCmd.Flags().String("github-app-private-key", "", "GitHub app private key file")
 	v.BindPFlag("github.privateKey", runCmd.Flags().Lookup("github-app-private-key"))
+	runCmd.Flags().String("queue-dir", botConfig.Queue.Dir, "Directory to persist queued webhook events in")
+	v.BindPFlag("queue.dir", runCmd.Flags().Lookup("queue-dir"))
+	runCmd.Flags().Int("workers", botConfig.Queue.Workers, "Number of workers processing queued webhook events")
+	v.BindPFlag("queue.workers", runCmd.Flags().Lookup("workers"))
 }
@@ -0,0 +1,116 @@
+// Copyright © 2017 Syndesis Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package codeowners parses GitHub CODEOWNERS files and looks up the owners
+// of a path.
+package codeowners
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Files are the locations GitHub looks for a CODEOWNERS file at, in order.
+var Files = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+// Rule assigns owners to the paths matching a pattern. Owners are "@user",
+// "@org/team" or email addresses. A rule without owners makes the matching
+// paths unowned.
+type Rule struct {
+	Pattern string
+	Owners  []string
+	Line    int
+	re      *regexp.Regexp
+}
+
+// File is a parsed CODEOWNERS file.
+type File struct {
+	Rules []Rule
+}
+
+// Parse parses a CODEOWNERS file. Patterns follow the gitignore rules GitHub
+// supports; negations and character ranges are not supported by GitHub and
+// match literally.
+func Parse(data []byte) *File {
+	f := &File{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if comment := strings.Index(line, " #"); comment >= 0 {
+			line = line[:comment]
+		}
+
+		fields := strings.Fields(line)
+		pattern := strings.Replace(fields[0], `\#`, "#", -1)
+		f.Rules = append(f.Rules, Rule{
+			Pattern: pattern,
+			Owners:  fields[1:],
+			Line:    i + 1,
+			re:      compile(pattern),
+		})
+	}
+	return f
+}
+
+// Owners returns the owners of the last rule matching path, which is
+// relative to the repository's root.
+func (f *File) Owners(path string) []string {
+	path = strings.TrimPrefix(path, "/")
+	for i := len(f.Rules) - 1; i >= 0; i-- {
+		if f.Rules[i].re.MatchString(path) {
+			return f.Rules[i].Owners
+		}
+	}
+	return nil
+}
+
+// compile translates a pattern into a regular expression matching the paths
+// it applies to, which includes everything below a matching directory.
+func compile(pattern string) *regexp.Regexp {
+	dirOnly := strings.HasSuffix(pattern, "/")
+	pattern = strings.TrimSuffix(pattern, "/")
+	// Patterns containing a slash other than at the end are relative to the
+	// root, all others match at any depth
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	expr := "^"
+	if !anchored {
+		expr += "(?:.*/)?"
+	}
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			expr += "(?:.*/)?"
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			expr += ".*"
+			i++
+		case pattern[i] == '*':
+			expr += "[^/]*"
+		case pattern[i] == '?':
+			expr += "[^/]"
+		default:
+			expr += regexp.QuoteMeta(pattern[i : i+1])
+		}
+	}
+	if dirOnly {
+		expr += "/.*$"
+	} else {
+		expr += "(?:/.*)?$"
+	}
+	return regexp.MustCompile(expr)
+}
@@ -0,0 +1,41 @@
+package codeowners
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOwners(t *testing.T) {
+	f := Parse([]byte(`# Default owners
+*       @syndesisio/core
+
+*.js    @alice # frontend
+/docs/  docs@example.com
+build/  @bob
+apps/**/config.yml @carol
+/vendor/
+\#notes @dave
+`))
+
+	tests := []struct {
+		path   string
+		owners []string
+	}{
+		{"README.md", []string{"@syndesisio/core"}},
+		{"ui/src/app.js", []string{"@alice"}},
+		{"docs/index.md", []string{"docs@example.com"}},
+		{"app/docs/index.md", []string{"@syndesisio/core"}},
+		{"build/Makefile", []string{"@bob"}},
+		{"tools/build/Makefile", []string{"@bob"}},
+		{"build", []string{"@syndesisio/core"}},
+		{"apps/config.yml", []string{"@carol"}},
+		{"apps/server/prod/config.yml", []string{"@carol"}},
+		{"vendor/lib.go", []string{}},
+		{"#notes", []string{"@dave"}},
+	}
+	for _, test := range tests {
+		if owners := f.Owners(test.path); !reflect.DeepEqual(owners, test.owners) {
+			t.Errorf("%s: expected %v, got %v", test.path, test.owners, owners)
+		}
+	}
+}
@@ -14,23 +14,52 @@
 
 package config
 
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
 func NewWithDefaults() Config {
 	return Config{
-		HTTPConfig{
+		HTTP: HTTPConfig{
 			Address: "",
 			Port:    8080,
 		},
-		WebhookConfig{},
-		GitHubAppConfig{},
-		RepoConfig{
+		Webhook:   WebhookConfig{},
+		GitHubApp: GitHubAppConfig{},
+		Queue: QueueConfig{
+			Dir:         filepath.Join(os.TempDir(), "pure-bot", "queue"),
+			Workers:     4,
+			MaxAttempts: 5,
+			RetryDelay:  10 * time.Second,
+		},
+		Dedup: DedupConfig{
+			Size: 10000,
+		},
+		Health: HealthConfig{
+			ZenhubInterval: 5 * time.Minute,
+		},
+		Reconcile: ReconcileConfig{
+			Concurrency:      4,
+			RateLimitReserve: 500,
+		},
+		Reminders: RemindersConfig{
+			Interval: time.Hour,
+		},
+		DefaultRepo: RepoConfig{
 			Labels: LabelConfig{
 				Approved: "approved",
 			},
+			AutoMerge: AutoMergeConfig{
+				QueueTimeout: Duration(2 * time.Hour),
+			},
 			Board: Board{
 				"<token>", "<repo>", []Column{},
 			},
 		},
-		nil,
+		Repos: nil,
 	}
 }
 
@@ -38,6 +67,13 @@ type Config struct {
 	HTTP        HTTPConfig            `mapstructure:"http"`
 	Webhook     WebhookConfig         `mapstructure:"webhook"`
 	GitHubApp   GitHubAppConfig       `mapstructure:"github"`
+	Queue       QueueConfig           `mapstructure:"queue"`
+	Dedup       DedupConfig           `mapstructure:"dedup"`
+	Health      HealthConfig          `mapstructure:"health"`
+	Admin       AdminConfig           `mapstructure:"admin"`
+	Reconcile   ReconcileConfig       `mapstructure:"reconcile"`
+	Assignment  AssignmentConfig      `mapstructure:"assignment"`
+	Reminders   RemindersConfig       `mapstructure:"reminders"`
 	DefaultRepo RepoConfig            `mapstructure:"defaults"`
 	Repos       map[string]RepoConfig `mapstructure:"repos"`
 }
@@ -51,18 +87,347 @@ type HTTPConfig struct {
 
 type WebhookConfig struct {
 	Secret string `mapstructure:"secret"`
+	// RecordDir, if set, is the directory every incoming webhook is
+	// recorded to for later replay
+	RecordDir string `mapstructure:"recordDir"`
 }
 
 type GitHubAppConfig struct {
 	AppID          int64  `mapstructure:"appId"`
 	PrivateKeyFile string `mapstructure:"privateKey"`
+	// BaseURL of the GitHub API, defaults to https://api.github.com
+	BaseURL string `mapstructure:"baseUrl"`
+}
+
+// QueueConfig configures the on-disk queue incoming webhook events are
+// written to before they are handed over to the handlers.
+type QueueConfig struct {
+	Dir         string        `mapstructure:"dir"`
+	Workers     int           `mapstructure:"workers"`
+	MaxAttempts int           `mapstructure:"maxAttempts"`
+	RetryDelay  time.Duration `mapstructure:"retryDelay"`
+}
+
+// DedupConfig configures how many webhook delivery IDs are remembered to
+// detect redelivered events. If File is set the IDs are persisted there.
+type DedupConfig struct {
+	Size int    `mapstructure:"size"`
+	File string `mapstructure:"file"`
 }
 
+// HealthConfig configures the optional checks of the readiness endpoint.
+// The ZenHub tokens of all configured boards are verified at most once per
+// ZenhubInterval, as ZenHub rate limits its API.
+type HealthConfig struct {
+	CheckZenhub    bool          `mapstructure:"checkZenhub"`
+	ZenhubInterval time.Duration `mapstructure:"zenhubInterval"`
+}
+
+// AdminConfig configures the admin endpoints, which are switched off
+// without a Token. Ad-hoc merge freezes, the pull requests held back by merge
+// freezes and the installations of repositories are persisted to FreezeFile
+// if it is set.
+type AdminConfig struct {
+	Token      string `mapstructure:"token"`
+	FreezeFile string `mapstructure:"freezeFile"`
+}
+
+// ReconcileConfig configures the reconciliation of all open pull requests,
+// which runs every Interval if it is set. At most Concurrency pull requests
+// are evaluated at once, and requests pause whenever fewer than
+// RateLimitReserve requests of an installation's rate limit are left.
+type ReconcileConfig struct {
+	Interval         time.Duration `mapstructure:"interval"`
+	Concurrency      int           `mapstructure:"concurrency"`
+	RateLimitReserve int           `mapstructure:"rateLimitReserve"`
+}
+
+// AssignmentConfig configures where the last review request of every
+// candidate reviewer is persisted. Without StateFile it is only kept in
+// memory.
+type AssignmentConfig struct {
+	StateFile string `mapstructure:"stateFile"`
+}
+
+// RemindersConfig configures how often the review requests of all open pull
+// requests are checked for reminders. 0 switches the reminders off.
+type RemindersConfig struct {
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// Bool returns a pointer to v, for the optional flags of RepoConfig.
+func Bool(v bool) *bool {
+	return &v
+}
+
+// Int returns a pointer to v, for the optional numbers of RepoConfig.
+func Int(v int) *int {
+	return &v
+}
+
+// Duration returns a pointer to v, for the optional durations of
+// RepoConfig.
+func Duration(v time.Duration) *time.Duration {
+	return &v
+}
+
+// RepoConfig is the configuration of a repository. Its flags and numbers are
+// pointers, so that a more specific entry of Repos or a repository's config
+// file can set them to false or 0 again. Use the Is and Get methods to read
+// them.
 type RepoConfig struct {
-	Disabled    bool        `mapstructure:"disabled"`
+	// Disabled switches all handlers off
+	Disabled *bool `mapstructure:"disabled"`
+	// DryRun only logs the changes handlers would make to GitHub and ZenHub
+	DryRun      *bool       `mapstructure:"dryRun"`
 	Labels      LabelConfig `mapstructure:"labels"`
 	WipPatterns []string    `mapstructure:"wipPatterns"`
 	Board       Board       `mapstructure:"board"`
+	// Handlers switches individual handlers on or off by name
+	Handlers  map[string]bool `mapstructure:"handlers"`
+	AutoMerge AutoMergeConfig `mapstructure:"autoMerge"`
+	// MergeFreeze lists the windows in which nothing is merged automatically
+	MergeFreeze []MergeFreezeWindow `mapstructure:"mergeFreeze"`
+	// ReviewerAssignment requests reviewers for new pull requests
+	ReviewerAssignment ReviewerAssignmentConfig `mapstructure:"reviewerAssignment"`
+	// ReviewReminders pings reviewers who didn't respond to review requests
+	ReviewReminders ReviewRemindersConfig `mapstructure:"reviewReminders"`
+	// DismissReviews exempts changes from the dismissReview handler
+	DismissReviews DismissReviewsConfig `mapstructure:"dismissReviews"`
+	// CISummary configures the comment listing the failed checks
+	CISummary CISummaryConfig `mapstructure:"ciSummary"`
+}
+
+// IsDisabled tells whether all handlers are switched off.
+func (r RepoConfig) IsDisabled() bool {
+	return r.Disabled != nil && *r.Disabled
+}
+
+// IsDryRun tells whether changes are only logged.
+func (r RepoConfig) IsDryRun() bool {
+	return r.DryRun != nil && *r.DryRun
+}
+
+// HandlerEnabled tells whether the named handler is switched on, falling
+// back to enabledByDefault if the config doesn't mention it. Names are
+// matched case insensitively, as viper lower cases all keys.
+func (r RepoConfig) HandlerEnabled(name string, enabledByDefault bool) bool {
+	for key, enabled := range r.Handlers {
+		if strings.EqualFold(key, name) {
+			return enabled
+		}
+	}
+	return enabledByDefault
+}
+
+// Merge methods of the GitHub API
+const (
+	MergeMethodMerge  = "merge"
+	MergeMethodSquash = "squash"
+	MergeMethodRebase = "rebase"
+)
+
+// AutoMergeConfig configures how approved pull requests are merged.
+type AutoMergeConfig struct {
+	// Method is the preferred merge method: merge, squash or rebase. If the
+	// repository doesn't allow it, the first allowed one of these is used.
+	// Empty uses the repository's default.
+	Method string `mapstructure:"method"`
+	// CommitTitle and CommitBody are Go templates for the merge commit's
+	// message. Empty templates leave the message to GitHub.
+	CommitTitle string `mapstructure:"commitTitle"`
+	CommitBody  string `mapstructure:"commitBody"`
+	// StripTemplate removes the repository's pull request template
+	// boilerplate from the body of squash commits
+	StripTemplate *bool `mapstructure:"stripTemplate"`
+	// CoAuthors adds a Co-authored-by trailer for every commit author other
+	// than the pull request's author
+	CoAuthors *bool `mapstructure:"coAuthors"`
+	// RequiredApprovals is the number of reviewers whose latest review has
+	// to approve the pull request. A reviewer requesting changes vetoes.
+	RequiredApprovals *int `mapstructure:"requiredApprovals"`
+	// CodeOwners requires an approval by an owner of every changed path, as
+	// listed in the CODEOWNERS file of the base branch
+	CodeOwners *bool `mapstructure:"codeOwners"`
+	// BlockingLabels keep approved pull requests from being merged
+	BlockingLabels []string `mapstructure:"blockingLabels"`
+	// Queue merges approved pull requests one at a time per base branch,
+	// updating each from its base before it is merged
+	Queue *bool `mapstructure:"queue"`
+	// QueueTimeout removes the first pull request of a merge queue if it
+	// isn't merged within this time, e.g. because its checks hang. Defaults
+	// to 2h, zero waits forever.
+	QueueTimeout *time.Duration `mapstructure:"queueTimeout"`
+	// PostMerge configures what happens after a pull request was merged
+	PostMerge PostMergeConfig `mapstructure:"postMerge"`
+}
+
+func (a AutoMergeConfig) GetStripTemplate() bool {
+	return a.StripTemplate != nil && *a.StripTemplate
+}
+
+func (a AutoMergeConfig) GetCoAuthors() bool {
+	return a.CoAuthors != nil && *a.CoAuthors
+}
+
+func (a AutoMergeConfig) GetCodeOwners() bool {
+	return a.CodeOwners != nil && *a.CodeOwners
+}
+
+func (a AutoMergeConfig) GetQueue() bool {
+	return a.Queue != nil && *a.Queue
+}
+
+func (a AutoMergeConfig) GetRequiredApprovals() int {
+	if a.RequiredApprovals == nil {
+		return 0
+	}
+	return *a.RequiredApprovals
+}
+
+func (a AutoMergeConfig) GetQueueTimeout() time.Duration {
+	if a.QueueTimeout == nil {
+		return 0
+	}
+	return *a.QueueTimeout
+}
+
+// Strategies selecting reviewers among the candidates
+const (
+	ReviewerStrategyRoundRobin = "roundRobin"
+	ReviewerStrategyLoad       = "load"
+)
+
+// ReviewerAssignmentConfig configures which reviewers are requested when a
+// pull request is opened or leaves draft. Without Count nobody is requested.
+type ReviewerAssignmentConfig struct {
+	// Count is the number of reviewers requested, including the ones
+	// requested already
+	Count *int `mapstructure:"count"`
+	// Pool lists the candidates, users by login and teams as "org/team"
+	Pool []string `mapstructure:"pool"`
+	// CodeOwners makes the owners of the changed files candidates, who are
+	// preferred over the pool
+	CodeOwners *bool `mapstructure:"codeOwners"`
+	// Strategy is roundRobin (the default), which requests the candidates
+	// who were requested the longest time ago, or load, which requests the
+	// candidates with the fewest open review requests in the repository
+	Strategy string `mapstructure:"strategy"`
+	// OutOfOffice lists users who are never requested
+	OutOfOffice []string `mapstructure:"outOfOffice"`
+}
+
+func (r ReviewerAssignmentConfig) GetCodeOwners() bool {
+	return r.CodeOwners != nil && *r.CodeOwners
+}
+
+func (r ReviewerAssignmentConfig) GetCount() int {
+	if r.Count == nil {
+		return 0
+	}
+	return *r.Count
+}
+
+// ReviewRemindersConfig configures reminders for review requests nobody
+// responded to. Only time on business days, Monday to Friday in the time zone
+// of the reviewer, counts towards the thresholds. Without After nobody is
+// reminded.
+type ReviewRemindersConfig struct {
+	// After is the time a review request is outstanding before the reviewer
+	// is reminded
+	After *time.Duration `mapstructure:"after"`
+	// EscalateAfter is the time a review request is outstanding before
+	// reviews are requested from EscalateTo as well
+	EscalateAfter *time.Duration `mapstructure:"escalateAfter"`
+	// EscalateTo lists the fallback reviewers, users by login and teams as
+	// "org/team"
+	EscalateTo []string `mapstructure:"escalateTo"`
+	// TimeZone of reviewers without an entry in TimeZones, e.g.
+	// "Europe/Berlin". Defaults to UTC.
+	TimeZone string `mapstructure:"timeZone"`
+	// TimeZones maps logins to their time zone
+	TimeZones map[string]string `mapstructure:"timeZones"`
+}
+
+func (r ReviewRemindersConfig) GetAfter() time.Duration {
+	if r.After == nil {
+		return 0
+	}
+	return *r.After
+}
+
+func (r ReviewRemindersConfig) GetEscalateAfter() time.Duration {
+	if r.EscalateAfter == nil {
+		return 0
+	}
+	return *r.EscalateAfter
+}
+
+// Location returns the time zone of the reviewer, falling back to UTC for
+// invalid time zones.
+func (r ReviewRemindersConfig) Location(login string) *time.Location {
+	name := r.TimeZone
+	for user, zone := range r.TimeZones {
+		if strings.EqualFold(user, login) {
+			name = zone
+		}
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// DismissReviewsConfig configures which pushes don't dismiss the approvals of
+// a pull request when the dismissReview handler is switched on.
+type DismissReviewsConfig struct {
+	// ExemptPaths are patterns of files whose changes keep approvals, e.g.
+	// "*.md" for all Markdown files or "docs/" for a directory
+	ExemptPaths []string `mapstructure:"exemptPaths"`
+	// ExemptAuthors are the logins of commit authors, e.g. bots, whose
+	// commits keep approvals
+	ExemptAuthors []string `mapstructure:"exemptAuthors"`
+}
+
+// CISummaryConfig configures the comment summarizing the failed statuses
+// and check runs of a pull request's head.
+type CISummaryConfig struct {
+	// IgnoredPrefixes are prefixes of status contexts and check run names
+	// which are left out, e.g. of services which comment themselves
+	IgnoredPrefixes []string `mapstructure:"ignoredPrefixes"`
+	// DeleteWhenGreen deletes the comment once all checks passed instead of
+	// collapsing it
+	DeleteWhenGreen *bool `mapstructure:"deleteWhenGreen"`
+}
+
+func (c CISummaryConfig) GetDeleteWhenGreen() bool {
+	return c.DeleteWhenGreen != nil && *c.DeleteWhenGreen
+}
+
+// PostMergeConfig configures the actions run after a pull request was
+// merged automatically.
+type PostMergeConfig struct {
+	// DeleteBranch deletes the head branch if it belongs to the same
+	// repository, is not protected and no open pull request is based on it
+	DeleteBranch *bool `mapstructure:"deleteBranch"`
+	// RemoveLabels removes the approved and review requested labels
+	RemoveLabels *bool `mapstructure:"removeLabels"`
+	// SetMilestone sets the current milestone on the pull request and the
+	// issues it closes, unless they have one already
+	SetMilestone *bool `mapstructure:"setMilestone"`
+}
+
+func (p PostMergeConfig) GetDeleteBranch() bool {
+	return p.DeleteBranch != nil && *p.DeleteBranch
+}
+
+func (p PostMergeConfig) GetRemoveLabels() bool {
+	return p.RemoveLabels != nil && *p.RemoveLabels
+}
+
+func (p PostMergeConfig) GetSetMilestone() bool {
+	return p.SetMilestone != nil && *p.SetMilestone
 }
 
 type LabelConfig struct {
@@ -0,0 +1,220 @@
+// Copyright © 2017 Syndesis Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// MaxFreezeDuration is the longest a recurring merge freeze may last.
+const MaxFreezeDuration = 7 * 24 * time.Hour
+
+// Layouts accepted for the From and To of a merge freeze window
+var freezeTimeLayouts = []string{time.RFC3339, "2006-01-02 15:04", "2006-01-02"}
+
+// MergeFreezeWindow is a period in which approved pull requests are not
+// merged. It either recurs, starting whenever Cron matches and lasting
+// Duration, or is the absolute range from From (inclusive) to To
+// (exclusive).
+type MergeFreezeWindow struct {
+	// Name is shown in the merge freeze status, e.g. "Release"
+	Name string `mapstructure:"name"`
+	// Cron is a cron expression with the fields minute, hour, day of month,
+	// month and day of week, e.g. "0 18 * * 5" for Fridays at 18:00
+	Cron     string        `mapstructure:"cron"`
+	Duration time.Duration `mapstructure:"duration"`
+	From     string        `mapstructure:"from"`
+	To       string        `mapstructure:"to"`
+	// TimeZone of Cron, From and To, e.g. "Europe/Berlin". Defaults to UTC.
+	TimeZone string `mapstructure:"timeZone"`
+}
+
+// ActiveAt tells whether the window includes t and if so, when it ends.
+func (w MergeFreezeWindow) ActiveAt(t time.Time) (bool, time.Time, error) {
+	loc, err := time.LoadLocation(w.TimeZone)
+	if err != nil {
+		return false, time.Time{}, errors.Wrapf(err, "invalid time zone %s", w.TimeZone)
+	}
+
+	if w.Cron == "" {
+		from, to, err := w.parseRange(loc)
+		if err != nil {
+			return false, time.Time{}, err
+		}
+		return !t.Before(from) && t.Before(to), to, nil
+	}
+
+	schedule, err := parseCron(w.Cron)
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	// The latest start of a window which may still include t ends last
+	for start := t.Truncate(time.Minute); start.After(t.Add(-w.Duration)); start = start.Add(-time.Minute) {
+		if schedule.matches(start.In(loc)) {
+			return true, start.Add(w.Duration), nil
+		}
+	}
+	return false, time.Time{}, nil
+}
+
+func (w MergeFreezeWindow) validate() error {
+	if _, err := time.LoadLocation(w.TimeZone); err != nil {
+		return errors.Wrapf(err, "invalid time zone %s", w.TimeZone)
+	}
+	if w.Cron == "" {
+		if w.Duration != 0 {
+			return errors.New("duration requires cron")
+		}
+		loc, _ := time.LoadLocation(w.TimeZone)
+		_, _, err := w.parseRange(loc)
+		return err
+	}
+
+	if w.From != "" || w.To != "" {
+		return errors.New("either cron or from and to may be set")
+	}
+	if w.Duration <= 0 || w.Duration > MaxFreezeDuration {
+		return errors.Errorf("duration must be positive and at most %s", MaxFreezeDuration)
+	}
+	_, err := parseCron(w.Cron)
+	return err
+}
+
+func (w MergeFreezeWindow) parseRange(loc *time.Location) (time.Time, time.Time, error) {
+	from, err := parseFreezeTime(w.From, loc)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.Wrap(err, "invalid from")
+	}
+	to, err := parseFreezeTime(w.To, loc)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.Wrap(err, "invalid to")
+	}
+	if !to.After(from) {
+		return time.Time{}, time.Time{}, errors.New("to must be after from")
+	}
+	return from, to, nil
+}
+
+func parseFreezeTime(value string, loc *time.Location) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, errors.New("missing time")
+	}
+	for _, layout := range freezeTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.Errorf("%q is not one of the formats %s", value, strings.Join(freezeTimeLayouts, ", "))
+}
+
+// cronSchedule holds the allowed values of each cron field as bit sets.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// Like cron, a day matches either restricted day field if both are
+	// restricted
+	domRestricted, dowRestricted bool
+}
+
+func parseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, errors.Errorf("cron expression %q must have 5 fields", expr)
+	}
+
+	s := &cronSchedule{
+		domRestricted: fields[2] != "*",
+		dowRestricted: fields[4] != "*",
+	}
+	for _, f := range []struct {
+		name     string
+		value    string
+		min, max int
+		set      *uint64
+	}{
+		{"minute", fields[0], 0, 59, &s.minute},
+		{"hour", fields[1], 0, 23, &s.hour},
+		{"day of month", fields[2], 1, 31, &s.dom},
+		{"month", fields[3], 1, 12, &s.month},
+		{"day of week", fields[4], 0, 7, &s.dow},
+	} {
+		set, err := parseCronField(f.value, f.min, f.max)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s in cron expression %q", f.name, expr)
+		}
+		*f.set = set
+	}
+	// Both 0 and 7 are Sunday
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+// parseCronField parses a comma separated list of "*", single values and
+// ranges, each optionally with a step, e.g. "*/15" or "1-5,0".
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, errors.Errorf("invalid step %q", part[i+1:])
+			}
+			part = part[:i]
+		}
+
+		from, to := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if from, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, errors.Errorf("invalid value %q", bounds[0])
+			}
+			to = from
+			if len(bounds) == 2 {
+				if to, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, errors.Errorf("invalid value %q", bounds[1])
+				}
+			}
+		}
+		if from < min || to > max || from > to {
+			return 0, errors.Errorf("%q is out of the range %d-%d", part, min, max)
+		}
+		for v := from; v <= to; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+func (s *cronSchedule) matches(t time.Time) bool {
+	has := func(set uint64, v int) bool {
+		return set&(1<<uint(v)) != 0
+	}
+
+	if !has(s.minute, t.Minute()) || !has(s.hour, t.Hour()) || !has(s.month, int(t.Month())) {
+		return false
+	}
+	dom, dow := has(s.dom, t.Day()), has(s.dow, int(t.Weekday()))
+	if s.domRestricted && s.dowRestricted {
+		return dom || dow
+	}
+	return dom && dow
+}
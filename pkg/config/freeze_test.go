@@ -0,0 +1,62 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMergeFreezeWindowActiveAt(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no time zone database")
+	}
+	weekend := MergeFreezeWindow{Cron: "0 18 * * 5", Duration: 62 * time.Hour, TimeZone: "Europe/Berlin"}
+	release := MergeFreezeWindow{From: "2018-12-20", To: "2018-12-24 12:00", TimeZone: "Europe/Berlin"}
+
+	tests := []struct {
+		name   string
+		window MergeFreezeWindow
+		at     time.Time
+		active bool
+		end    time.Time
+	}{
+		{"before weekend", weekend, time.Date(2018, 11, 9, 17, 59, 0, 0, berlin), false, time.Time{}},
+		{"weekend starts", weekend, time.Date(2018, 11, 9, 18, 0, 0, 0, berlin), true, time.Date(2018, 11, 12, 8, 0, 0, 0, berlin)},
+		{"weekend", weekend, time.Date(2018, 11, 11, 12, 30, 0, 0, time.UTC), true, time.Date(2018, 11, 12, 8, 0, 0, 0, berlin)},
+		{"weekend ended", weekend, time.Date(2018, 11, 12, 8, 0, 0, 0, berlin), false, time.Time{}},
+		{"release", release, time.Date(2018, 12, 20, 0, 0, 0, 0, berlin), true, time.Date(2018, 12, 24, 12, 0, 0, 0, berlin)},
+		{"after release", release, time.Date(2018, 12, 24, 12, 0, 0, 0, berlin), false, time.Date(2018, 12, 24, 12, 0, 0, 0, berlin)},
+	}
+	for _, test := range tests {
+		active, end, err := test.window.ActiveAt(test.at)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if active != test.active || !end.Equal(test.end) {
+			t.Errorf("%s: expected active=%v until %s, got active=%v until %s", test.name, test.active, test.end, active, end)
+		}
+	}
+}
+
+func TestMergeFreezeWindowValidate(t *testing.T) {
+	tests := []struct {
+		window MergeFreezeWindow
+		valid  bool
+	}{
+		{MergeFreezeWindow{Cron: "*/15 9-17 1,15 * 1-5", Duration: time.Hour}, true},
+		{MergeFreezeWindow{Cron: "0 18 * * 7", Duration: time.Hour}, true},
+		{MergeFreezeWindow{Cron: "0 18 * *", Duration: time.Hour}, false},
+		{MergeFreezeWindow{Cron: "60 18 * * *", Duration: time.Hour}, false},
+		{MergeFreezeWindow{Cron: "0 18 * * *"}, false},
+		{MergeFreezeWindow{Cron: "0 18 * * *", Duration: 8 * 24 * time.Hour}, false},
+		{MergeFreezeWindow{Cron: "0 18 * * *", Duration: time.Hour, From: "2018-12-20"}, false},
+		{MergeFreezeWindow{From: "2018-12-20", To: "2018-12-19"}, false},
+		{MergeFreezeWindow{From: "2018-12-20T00:00:00Z", To: "2018-12-21"}, true},
+		{MergeFreezeWindow{From: "2018-12-20", To: "2018-12-21", TimeZone: "Nowhere/Nothing"}, false},
+	}
+	for _, test := range tests {
+		if err := test.window.validate(); (err == nil) != test.valid {
+			t.Errorf("%+v: expected valid=%v, got %v", test.window, test.valid, err)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+// Copyright © 2017 Syndesis Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+)
+
+// Problem is a single finding of Lint, located by the dotted path of the
+// offending key, e.g. "repos.syndesis.board.columns[1].id".
+type Problem struct {
+	Key     string
+	Line    int
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Key == "" {
+		return p.Message
+	}
+	return p.Key + ": " + p.Message
+}
+
+// LintOptions tells Lint about the handlers compiled into the bot.
+type LintOptions struct {
+	// BoardEvents are the events the board update reacts to
+	BoardEvents []string
+	// Handlers are the names of all handlers
+	Handlers []string
+}
+
+// Lint checks a config file more strictly than loading it does: besides the
+// checks of Validate it reports unknown keys, unknown handler names, board
+// columns without an ID, events mapped to more than one column and events
+// which are not board events. An error is only returned if the file can't be
+// parsed at all.
+func Lint(data []byte, opts LintOptions) ([]Problem, error) {
+	var raw interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, errors.Wrap(err, "invalid YAML")
+	}
+
+	cfg := NewWithDefaults()
+	cfg.Repos = nil
+	v := viper.New()
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+		return nil, errors.Wrap(err, "invalid YAML")
+	}
+
+	var problems []Problem
+	if err := v.Unmarshal(&cfg); err != nil {
+		problems = append(problems, Problem{Message: err.Error()})
+	}
+
+	problems = append(problems, unknownKeys(raw, reflect.TypeOf(Config{}), "")...)
+	problems = append(problems, cfg.problems()...)
+
+	events := make(map[string]bool, len(opts.BoardEvents))
+	for _, event := range opts.BoardEvents {
+		events[event] = true
+	}
+	problems = append(problems, cfg.DefaultRepo.lintProblems(DefaultsLayer, events, opts.Handlers)...)
+	for _, key := range cfg.repoKeys() {
+		problems = append(problems, cfg.Repos[key].lintProblems("repos."+key, events, opts.Handlers)...)
+	}
+
+	lines := newLineIndex(data)
+	for i := range problems {
+		problems[i].Line = lines.lineOf(problems[i].Key)
+	}
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Line < problems[j].Line
+	})
+	return problems, nil
+}
+
+// unknownKeys reports all keys of raw which have no matching field in t.
+// Keys are matched case insensitively, as viper does.
+func unknownKeys(raw interface{}, t reflect.Type, path string) []Problem {
+	join := func(key string) string {
+		if path == "" {
+			return key
+		}
+		return path + "." + key
+	}
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	var problems []Problem
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := raw.(map[interface{}]interface{})
+		if !ok {
+			return nil
+		}
+		for _, k := range sortedKeys(m) {
+			field, found := fieldByTag(t, k)
+			if !found {
+				problems = append(problems, Problem{Key: join(k), Message: "unknown key"})
+				continue
+			}
+			problems = append(problems, unknownKeys(m[k], field.Type, join(k))...)
+		}
+	case reflect.Map:
+		m, ok := raw.(map[interface{}]interface{})
+		if !ok {
+			return nil
+		}
+		for _, k := range sortedKeys(m) {
+			problems = append(problems, unknownKeys(m[k], t.Elem(), join(k))...)
+		}
+	case reflect.Slice:
+		s, ok := raw.([]interface{})
+		if !ok {
+			return nil
+		}
+		for i, item := range s {
+			problems = append(problems, unknownKeys(item, t.Elem(), path+"["+strconv.Itoa(i)+"]")...)
+		}
+	}
+	return problems
+}
+
+func fieldByTag(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if strings.EqualFold(field.Tag.Get("mapstructure"), key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func sortedKeys(m map[interface{}]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, fmt.Sprint(k))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (r RepoConfig) lintProblems(path string, boardEvents map[string]bool, handlerNames []string) []Problem {
+	problems := r.Board.problems(path+".board", boardEvents)
+
+	keys := make([]string, 0, len(r.Handlers))
+	for key := range r.Handlers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		known := false
+		for _, name := range handlerNames {
+			known = known || strings.EqualFold(key, name)
+		}
+		if !known {
+			problems = append(problems, Problem{Key: path + ".handlers." + key, Message: "unknown handler"})
+		}
+	}
+	return problems
+}
+
+func (b Board) problems(path string, boardEvents map[string]bool) []Problem {
+	var problems []Problem
+	mapped := make(map[string]string)
+	for i, col := range b.Columns {
+		colPath := path + ".columns[" + strconv.Itoa(i) + "]"
+		if strings.TrimSpace(col.Id) == "" {
+			problems = append(problems, Problem{Key: colPath + ".id", Message: "column " + strconv.Quote(col.Name) + " has no ID"})
+		}
+		for j, event := range col.Events {
+			eventPath := colPath + ".events[" + strconv.Itoa(j) + "]"
+			if !boardEvents[event] {
+				problems = append(problems, Problem{Key: eventPath, Message: "no handler emits event " + strconv.Quote(event)})
+			}
+			if other, ok := mapped[event]; ok {
+				problems = append(problems, Problem{Key: eventPath, Message: "event " + strconv.Quote(event) + " is already mapped to column " + strconv.Quote(other)})
+				continue
+			}
+			mapped[event] = col.Name
+		}
+	}
+	return problems
+}
+
+// lineIndex locates keys in a YAML document. It understands the block style
+// used by config files, which is enough to point users to the right line.
+type lineIndex []lineEntry
+
+type lineEntry struct {
+	line   int
+	indent int
+	item   bool   // a list item ("- ")
+	key    string // the mapping key, if any
+}
+
+func newLineIndex(data []byte) lineIndex {
+	var index lineIndex
+	for i, line := range strings.Split(string(data), "\n") {
+		content := strings.TrimLeft(line, " ")
+		indent := len(line) - len(content)
+		if content == "" || strings.HasPrefix(content, "#") {
+			continue
+		}
+		for strings.HasPrefix(content, "- ") || content == "-" {
+			index = append(index, lineEntry{line: i + 1, indent: indent, item: true})
+			trimmed := strings.TrimLeft(strings.TrimPrefix(content, "-"), " ")
+			indent += len(content) - len(trimmed)
+			content = trimmed
+		}
+		if colon := strings.Index(content, ":"); colon > 0 {
+			key := strings.Trim(strings.TrimSpace(content[:colon]), `"'`)
+			index = append(index, lineEntry{line: i + 1, indent: indent, key: key})
+		}
+	}
+	return index
+}
+
+// lineOf returns the line of the key path, or of its closest parent found.
+// It returns 0 if not even the top level key is found.
+func (index lineIndex) lineOf(path string) int {
+	line := 0
+	from, to, parentIndent := 0, len(index), -1
+	for _, segment := range splitPath(path) {
+		found := -1
+		childIndent := -1
+		items := 0
+		for i := from; i < to; i++ {
+			e := index[i]
+			if childIndent < 0 {
+				childIndent = e.indent
+			}
+			if e.indent != childIndent {
+				continue
+			}
+			if n, isIndex := listIndex(segment); isIndex {
+				if e.item {
+					if items == n {
+						found = i
+						break
+					}
+					items++
+				}
+			} else if !e.item && strings.EqualFold(e.key, segment) {
+				found = i
+				break
+			}
+		}
+		if found < 0 {
+			return line
+		}
+
+		parent := index[found]
+		line, parentIndent = parent.line, parent.indent
+		from, to = found+1, len(index)
+		for i := from; i < len(index); i++ {
+			// The items of a list may be indented as much as its key
+			sameIndentItem := index[i].item && !parent.item && index[i].indent == parentIndent
+			if index[i].indent <= parentIndent && !sameIndentItem {
+				to = i
+				break
+			}
+		}
+	}
+	return line
+}
+
+// splitPath splits "a.b[1].c" into "a", "b", "[1]" and "c". Repo keys may
+// contain dots themselves, which is ignored as they are rare.
+func splitPath(path string) []string {
+	var segments []string
+	for _, part := range strings.Split(path, ".") {
+		for {
+			open := strings.Index(part, "[")
+			if open < 0 {
+				break
+			}
+			if open > 0 {
+				segments = append(segments, part[:open])
+			}
+			end := strings.Index(part, "]")
+			if end < open {
+				break
+			}
+			segments = append(segments, part[open:end+1])
+			part = part[end+1:]
+		}
+		if part != "" {
+			segments = append(segments, part)
+		}
+	}
+	return segments
+}
+
+func listIndex(segment string) (int, bool) {
+	if !strings.HasPrefix(segment, "[") || !strings.HasSuffix(segment, "]") {
+		return 0, false
+	}
+	n, err := strconv.Atoi(segment[1 : len(segment)-1])
+	return n, err == nil
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"testing"
+)
+
+const lintTestConfig = `github:
+  appId: 1
+defaults:
+  labels:
+    aproved: "approved"
+  wipPatterns:
+  - "wip"
+  - "(unclosed"
+repos:
+  syndesis:
+    board:
+      columns:
+        - name: "Inbox"
+          id: ""
+          events:
+            - "issues_opened"
+        - name: "Backlog"
+          id: "123"
+          events:
+            - "issues_opened"
+            - "issues_exploded"
+  syndesisio/*:
+    handlers:
+      dismissReview: true
+      dismisReview: true
+pure-bot-sandbox:
+  disabled: true
+`
+
+func TestLint(t *testing.T) {
+	problems, err := Lint([]byte(lintTestConfig), LintOptions{
+		BoardEvents: []string{"issues_opened"},
+		Handlers:    []string{"dismissReview"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Problem{
+		{Key: "defaults.labels.aproved", Line: 5},
+		{Key: "defaults.wipPatterns[1]", Line: 8},
+		{Key: "repos.syndesis.board.columns[0].id", Line: 14},
+		{Key: "repos.syndesis.board.columns[1].events[0]", Line: 20},
+		{Key: "repos.syndesis.board.columns[1].events[1]", Line: 21},
+		{Key: "repos.syndesisio/*.handlers.dismisreview", Line: 25},
+		{Key: "pure-bot-sandbox", Line: 26},
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %d: %v", len(expected), len(problems), problems)
+	}
+	for i, p := range problems {
+		if p.Key != expected[i].Key || p.Line != expected[i].Line {
+			t.Errorf("expected %s at line %d, got %s at line %d (%s)", expected[i].Key, expected[i].Line, p.Key, p.Line, p.Message)
+		}
+	}
+}
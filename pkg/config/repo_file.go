@@ -0,0 +1,68 @@
+// Copyright © 2017 Syndesis Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"go.uber.org/multierr"
+)
+
+// RepoFile is the path of the optional configuration file inside a
+// repository. It has the same structure as an entry of `repos`.
+const RepoFile = ".github/pure-bot.yml"
+
+// centralOnlyKeys may not be set in a repository's own configuration file,
+// as they control access to external services or switch the bot off.
+var centralOnlyKeys = []string{
+	"disabled",
+	"dryRun",
+	"board.zenhub_token",
+	"board.github_repo",
+}
+
+// ParseRepoFile parses and validates the contents of a repository's own
+// configuration file.
+func ParseRepoFile(data []byte) (RepoConfig, error) {
+	var ret RepoConfig
+
+	rv := viper.New()
+	rv.SetConfigType("yaml")
+	if err := rv.ReadConfig(bytes.NewReader(data)); err != nil {
+		return ret, errors.Wrap(err, "invalid YAML")
+	}
+
+	var err error
+	for _, key := range centralOnlyKeys {
+		if rv.IsSet(key) {
+			err = multierr.Append(err, errors.Errorf("%s may only be set in the bot's central configuration", key))
+		}
+	}
+	if decodeErr := rv.UnmarshalExact(&ret); decodeErr != nil {
+		err = multierr.Append(err, decodeErr)
+	}
+	for _, p := range ret.problems("") {
+		err = multierr.Append(err, errors.New(p.String()))
+	}
+	return ret, err
+}
+
+// MergeRepoFile overrides c with all fields set in the repository's own
+// configuration file.
+func (c *RepoConfig) MergeRepoFile(file RepoConfig) {
+	c.merge(file)
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseRepoFile(t *testing.T) {
+	repoConfig, err := ParseRepoFile([]byte(`
+wipPatterns:
+- "draft"
+labels:
+  approved: "lgtm"
+board:
+  columns:
+  - name: "Done"
+    id: "123"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repoConfig.Labels.Approved != "lgtm" || len(repoConfig.WipPatterns) != 1 || len(repoConfig.Board.Columns) != 1 {
+		t.Errorf("unexpected config %+v", repoConfig)
+	}
+
+	merged := NewWithDefaults().DefaultRepo
+	merged.MergeRepoFile(repoConfig)
+	if merged.Labels.Approved != "lgtm" || merged.Board.ZenhubToken != "<token>" {
+		t.Errorf("unexpected merged config %+v", merged)
+	}
+}
+
+func TestParseRepoFileRejectsInvalidContent(t *testing.T) {
+	_, err := ParseRepoFile([]byte(`
+dryRun: true
+board:
+  zenhub_token: "secret"
+wipPatterns:
+- "("
+lables:
+  approved: "typo"
+`))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, expected := range []string{"dryRun", "board.zenhub_token", "wipPatterns", "lables"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error to mention %s, got: %v", expected, err)
+		}
+	}
+}
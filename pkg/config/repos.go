@@ -0,0 +1,157 @@
+// Copyright © 2017 Syndesis Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/imdario/mergo"
+)
+
+// DefaultsLayer names the defaults in the list of layers returned by
+// RepoConfigFor.
+const DefaultsLayer = "defaults"
+
+// Precedence of the keys in Repos, lowest first. Keys are matched case
+// insensitively, as GitHub treats owner and repository names.
+const (
+	nameGlobKey  = iota // "*/docs-*", "docs-*" or any other key with a glob in the owner
+	bareNameKey         // "syndesis", the name in any owner
+	ownerGlobKey        // "syndesisio/*"
+	fullNameKey         // "syndesisio/syndesis"
+)
+
+// RepoConfigFor returns the configuration of the repository owner/name, which
+// is the defaults overridden by every matching entry of Repos in order of
+// precedence: exact full name, owner glob, bare repository name, name glob.
+// The second return value lists the merged layers, lowest precedence first.
+func (c Config) RepoConfigFor(owner, name string) (RepoConfig, []string) {
+	ret := RepoConfig{}
+	ret.merge(c.DefaultRepo)
+
+	layers := []string{DefaultsLayer}
+	for _, key := range c.matchingRepoKeys(owner, name) {
+		ret.merge(c.Repos[key])
+		layers = append(layers, key)
+	}
+	return ret, layers
+}
+
+// RepoEntry returns the defaults overridden by the single entry key of
+// Repos, e.g. to report on a configured entry independent of a repository.
+func (c Config) RepoEntry(key string) RepoConfig {
+	ret := RepoConfig{}
+	ret.merge(c.DefaultRepo)
+	ret.merge(c.Repos[key])
+	return ret
+}
+
+// ForceDryRun switches on the dry run of the defaults and of every entry of
+// Repos, which could switch it off again otherwise.
+func (c *Config) ForceDryRun() {
+	c.DefaultRepo.DryRun = Bool(true)
+	for key, repo := range c.Repos {
+		repo.DryRun = Bool(true)
+		c.Repos[key] = repo
+	}
+}
+
+// merge overrides r with all fields set in layer. Unlike mergo alone, lists
+// set to an empty list clear the list of r, and flags and numbers set to
+// false or 0 override the ones of r, as they are pointers.
+func (r *RepoConfig) merge(layer RepoConfig) {
+	mergo.Merge(r, layer, mergo.WithOverride, mergo.WithTransformers(setSlices{}))
+}
+
+// setSlices makes mergo override non-empty lists with every list which is
+// set, even if it is empty. Lists which aren't set are nil.
+type setSlices struct{}
+
+func (setSlices) Transformer(typ reflect.Type) func(dst, src reflect.Value) error {
+	if typ.Kind() != reflect.Slice {
+		return nil
+	}
+	return func(dst, src reflect.Value) error {
+		if dst.CanSet() && !src.IsNil() {
+			dst.Set(src)
+		}
+		return nil
+	}
+}
+
+func (c Config) matchingRepoKeys(owner, name string) []string {
+	type match struct {
+		key  string
+		rank int
+	}
+
+	owner = strings.ToLower(owner)
+	name = strings.ToLower(name)
+
+	var matches []match
+	for key := range c.Repos {
+		if rank, ok := matchRepoKey(strings.ToLower(key), owner, name); ok {
+			matches = append(matches, match{key, rank})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].rank != matches[j].rank {
+			return matches[i].rank < matches[j].rank
+		}
+		return matches[i].key < matches[j].key
+	})
+
+	keys := make([]string, len(matches))
+	for i, m := range matches {
+		keys[i] = m.key
+	}
+	return keys
+}
+
+func matchRepoKey(key, owner, name string) (int, bool) {
+	keyOwner, keyName := "*", key
+	if i := strings.Index(key, "/"); i >= 0 {
+		keyOwner, keyName = key[:i], key[i+1:]
+	}
+
+	if !isGlob(key) {
+		if keyOwner == "*" {
+			return bareNameKey, keyName == name
+		}
+		return fullNameKey, keyOwner == owner && keyName == name
+	}
+
+	if !globMatch(keyOwner, owner) || !globMatch(keyName, name) {
+		return 0, false
+	}
+	if isGlob(keyOwner) {
+		return nameGlobKey, true
+	}
+	return ownerGlobKey, true
+}
+
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// globMatch reports whether s matches the shell pattern. Invalid patterns
+// never match.
+func globMatch(pattern, s string) bool {
+	matched, err := path.Match(pattern, s)
+	return err == nil && matched
+}
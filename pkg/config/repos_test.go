@@ -0,0 +1,121 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRepoConfigPrecedence(t *testing.T) {
+	cfg := NewWithDefaults()
+	cfg.Repos = map[string]RepoConfig{
+		"syndesisio/syndesis": {Labels: LabelConfig{Approved: "full"}},
+		"syndesis":            {Labels: LabelConfig{Approved: "bare", ReviewRequested: "bare"}},
+		"syndesisio/*":        {Labels: LabelConfig{Approved: "owner", ReviewRequested: "owner"}, WipPatterns: []string{"owner"}},
+		"*/syndesis*":         {Labels: LabelConfig{Approved: "name", ReviewRequested: "name"}, WipPatterns: []string{"name"}, DryRun: Bool(true)},
+		"other/*":             {Disabled: Bool(true)},
+	}
+
+	repoConfig, layers := cfg.RepoConfigFor("SyndesisIO", "Syndesis")
+	expectedLayers := []string{DefaultsLayer, "*/syndesis*", "syndesis", "syndesisio/*", "syndesisio/syndesis"}
+	if !reflect.DeepEqual(layers, expectedLayers) {
+		t.Errorf("expected layers %v, got %v", expectedLayers, layers)
+	}
+	if repoConfig.Labels.Approved != "full" {
+		t.Errorf("expected exact full name to win, got %q", repoConfig.Labels.Approved)
+	}
+	if repoConfig.Labels.ReviewRequested != "owner" {
+		t.Errorf("expected owner glob to override bare name, got %q", repoConfig.Labels.ReviewRequested)
+	}
+	if !reflect.DeepEqual(repoConfig.WipPatterns, []string{"owner"}) {
+		t.Errorf("expected owner glob to override name glob, got %v", repoConfig.WipPatterns)
+	}
+	if !repoConfig.IsDryRun() || repoConfig.IsDisabled() {
+		t.Errorf("unexpected flags: dryRun=%v disabled=%v", repoConfig.IsDryRun(), repoConfig.IsDisabled())
+	}
+
+	repoConfig, layers = cfg.RepoConfigFor("syndesisio", "pure-bot")
+	if !reflect.DeepEqual(layers, []string{DefaultsLayer, "syndesisio/*"}) {
+		t.Errorf("unexpected layers for syndesisio/pure-bot: %v", layers)
+	}
+
+	repoConfig, layers = cfg.RepoConfigFor("someone", "else")
+	if !reflect.DeepEqual(layers, []string{DefaultsLayer}) || repoConfig.Labels.Approved != "approved" {
+		t.Errorf("expected only defaults, got %v with %+v", layers, repoConfig)
+	}
+}
+
+func TestRepoConfigOverrides(t *testing.T) {
+	cfg := NewWithDefaults()
+	cfg.DefaultRepo.WipPatterns = []string{"wip"}
+	cfg.Repos = map[string]RepoConfig{
+		"syndesisio/*":        {Disabled: Bool(true), DryRun: Bool(true), Labels: LabelConfig{NewIssues: []string{"triage"}}},
+		"syndesisio/syndesis": {Disabled: Bool(false), DryRun: Bool(false), WipPatterns: []string{}},
+	}
+
+	repoConfig, _ := cfg.RepoConfigFor("syndesisio", "syndesis")
+	if repoConfig.IsDisabled() || repoConfig.IsDryRun() {
+		t.Errorf("expected full name to switch off flags of owner glob: dryRun=%v disabled=%v", repoConfig.IsDryRun(), repoConfig.IsDisabled())
+	}
+	if len(repoConfig.WipPatterns) != 0 {
+		t.Errorf("expected empty list to clear defaults, got %v", repoConfig.WipPatterns)
+	}
+	if !reflect.DeepEqual(repoConfig.Labels.NewIssues, []string{"triage"}) {
+		t.Errorf("expected list which isn't set to be kept, got %v", repoConfig.Labels.NewIssues)
+	}
+
+	repoConfig, _ = cfg.RepoConfigFor("syndesisio", "pure-bot")
+	if !repoConfig.IsDisabled() || !repoConfig.IsDryRun() || !reflect.DeepEqual(repoConfig.WipPatterns, []string{"wip"}) {
+		t.Errorf("unexpected config of owner glob: %+v", repoConfig)
+	}
+
+	cfg.ForceDryRun()
+	if repoConfig, _ = cfg.RepoConfigFor("syndesisio", "syndesis"); !repoConfig.IsDryRun() {
+		t.Error("expected forced dry run to override entries")
+	}
+}
+
+func TestRepoConfigUnsetsNestedFields(t *testing.T) {
+	cfg := NewWithDefaults()
+	cfg.DefaultRepo.Handlers = map[string]bool{"wip": true}
+	cfg.DefaultRepo.AutoMerge = AutoMergeConfig{
+		Queue:             Bool(true),
+		QueueTimeout:      Duration(time.Hour),
+		RequiredApprovals: Int(2),
+		PostMerge:         PostMergeConfig{DeleteBranch: Bool(true), SetMilestone: Bool(true)},
+	}
+	cfg.DefaultRepo.ReviewerAssignment.Count = Int(2)
+	cfg.DefaultRepo.CISummary.DeleteWhenGreen = Bool(true)
+	cfg.Repos = map[string]RepoConfig{
+		"syndesisio/syndesis": {
+			Handlers: map[string]bool{"wip": false},
+			AutoMerge: AutoMergeConfig{
+				Queue:             Bool(false),
+				RequiredApprovals: Int(0),
+				PostMerge:         PostMergeConfig{DeleteBranch: Bool(false)},
+			},
+			ReviewerAssignment: ReviewerAssignmentConfig{Count: Int(0)},
+		},
+	}
+
+	repoConfig, _ := cfg.RepoConfigFor("syndesisio", "syndesis")
+	autoMerge := repoConfig.AutoMerge
+	if autoMerge.GetQueue() || autoMerge.GetRequiredApprovals() != 0 || autoMerge.PostMerge.GetDeleteBranch() || repoConfig.ReviewerAssignment.GetCount() != 0 {
+		t.Errorf("expected entry to unset nested fields, got %+v", repoConfig)
+	}
+	if repoConfig.HandlerEnabled("wip", true) {
+		t.Error("expected entry to switch off handler")
+	}
+	if autoMerge.GetQueueTimeout() != time.Hour || !autoMerge.PostMerge.GetSetMilestone() || !repoConfig.CISummary.GetDeleteWhenGreen() {
+		t.Errorf("expected fields which aren't set to be kept, got %+v", repoConfig)
+	}
+
+	file, err := ParseRepoFile([]byte("autoMerge:\n  queueTimeout: 0s\n  postMerge:\n    setMilestone: false\nciSummary:\n  deleteWhenGreen: false\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repoConfig.MergeRepoFile(file)
+	if repoConfig.AutoMerge.GetQueueTimeout() != 0 || repoConfig.AutoMerge.PostMerge.GetSetMilestone() || repoConfig.CISummary.GetDeleteWhenGreen() {
+		t.Errorf("expected repo file to unset nested fields, got %+v", repoConfig)
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright © 2017 Syndesis Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"sync/atomic"
+)
+
+// Store holds the active configuration. A reloaded configuration is swapped
+// in atomically, while everyone who loaded the previous one keeps using that
+// snapshot.
+type Store struct {
+	value atomic.Value
+}
+
+func NewStore(cfg Config) *Store {
+	s := &Store{}
+	s.Set(cfg)
+	return s
+}
+
+// Get returns the active configuration.
+func (s *Store) Get() Config {
+	return s.value.Load().(Config)
+}
+
+// Set makes cfg the active configuration.
+func (s *Store) Set(cfg Config) {
+	s.value.Store(cfg)
+}
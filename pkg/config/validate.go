@@ -0,0 +1,185 @@
+// Copyright © 2017 Syndesis Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+)
+
+// Validate checks the parts of the configuration which would otherwise only
+// fail while handling events.
+func (c Config) Validate() error {
+	var err error
+	for _, p := range c.problems() {
+		err = multierr.Append(err, errors.New(p.String()))
+	}
+	return err
+}
+
+func (c Config) problems() []Problem {
+	problems := c.DefaultRepo.problems(DefaultsLayer)
+	if c.Reconcile.Interval < 0 {
+		problems = append(problems, Problem{Key: "reconcile.interval", Message: "must not be negative"})
+	}
+	if c.Reconcile.Concurrency < 1 {
+		problems = append(problems, Problem{Key: "reconcile.concurrency", Message: "must be at least 1"})
+	}
+	if c.Reconcile.RateLimitReserve < 0 {
+		problems = append(problems, Problem{Key: "reconcile.rateLimitReserve", Message: "must not be negative"})
+	}
+	if c.Reminders.Interval < 0 {
+		problems = append(problems, Problem{Key: "reminders.interval", Message: "must not be negative"})
+	}
+	for _, key := range c.repoKeys() {
+		if _, err := path.Match(key, ""); err != nil {
+			problems = append(problems, Problem{Key: "repos." + key, Message: "invalid pattern"})
+		}
+		problems = append(problems, c.Repos[key].problems("repos."+key)...)
+	}
+	return problems
+}
+
+func (c Config) repoKeys() []string {
+	keys := make([]string, 0, len(c.Repos))
+	for key := range c.Repos {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// problems checks a repo config, prefixing the reported keys with the given
+// path unless it is empty.
+func (r RepoConfig) problems(prefix string) []Problem {
+	key := func(name string) string {
+		if prefix == "" {
+			return name
+		}
+		return prefix + "." + name
+	}
+
+	var problems []Problem
+	for i, pattern := range r.WipPatterns {
+		// Compiled the same way the WIP check uses them
+		if _, err := regexp.Compile(`(?i)\b(?:` + pattern + `)\b`); err != nil {
+			problems = append(problems, Problem{Key: key("wipPatterns[" + strconv.Itoa(i) + "]"), Message: err.Error()})
+		}
+	}
+
+	if r.ReviewerAssignment.GetCount() < 0 {
+		problems = append(problems, Problem{Key: key("reviewerAssignment.count"), Message: "must not be negative"})
+	}
+	switch r.ReviewerAssignment.Strategy {
+	case "", ReviewerStrategyRoundRobin, ReviewerStrategyLoad:
+	default:
+		problems = append(problems, Problem{Key: key("reviewerAssignment.strategy"), Message: "must be roundRobin or load"})
+	}
+	for i, candidate := range r.ReviewerAssignment.Pool {
+		if !validReviewer(candidate) {
+			problems = append(problems, Problem{Key: key("reviewerAssignment.pool[" + strconv.Itoa(i) + "]"), Message: "must be a login or org/team"})
+		}
+	}
+
+	reminders := r.ReviewReminders
+	if reminders.GetAfter() < 0 {
+		problems = append(problems, Problem{Key: key("reviewReminders.after"), Message: "must not be negative"})
+	}
+	if reminders.GetEscalateAfter() != 0 && reminders.GetEscalateAfter() < reminders.GetAfter() {
+		problems = append(problems, Problem{Key: key("reviewReminders.escalateAfter"), Message: "must not be shorter than after"})
+	}
+	if reminders.GetEscalateAfter() > 0 && len(reminders.EscalateTo) == 0 {
+		problems = append(problems, Problem{Key: key("reviewReminders.escalateTo"), Message: "must not be empty with escalateAfter"})
+	}
+	for i, candidate := range reminders.EscalateTo {
+		if !validReviewer(candidate) {
+			problems = append(problems, Problem{Key: key("reviewReminders.escalateTo[" + strconv.Itoa(i) + "]"), Message: "must be a login or org/team"})
+		}
+	}
+	if _, err := time.LoadLocation(reminders.TimeZone); err != nil {
+		problems = append(problems, Problem{Key: key("reviewReminders.timeZone"), Message: err.Error()})
+	}
+	for _, login := range sortedStringKeys(reminders.TimeZones) {
+		if _, err := time.LoadLocation(reminders.TimeZones[login]); err != nil {
+			problems = append(problems, Problem{Key: key("reviewReminders.timeZones." + login), Message: err.Error()})
+		}
+	}
+
+	for i, pattern := range r.DismissReviews.ExemptPaths {
+		if _, err := path.Match(strings.TrimSuffix(pattern, "/"), ""); err != nil || pattern == "" {
+			problems = append(problems, Problem{Key: key("dismissReviews.exemptPaths[" + strconv.Itoa(i) + "]"), Message: "invalid pattern"})
+		}
+	}
+
+	switch r.AutoMerge.Method {
+	case "", MergeMethodMerge, MergeMethodSquash, MergeMethodRebase:
+	default:
+		problems = append(problems, Problem{Key: key("autoMerge.method"), Message: "must be one of merge, squash or rebase"})
+	}
+	if r.AutoMerge.GetRequiredApprovals() < 0 {
+		problems = append(problems, Problem{Key: key("autoMerge.requiredApprovals"), Message: "must not be negative"})
+	}
+	if r.AutoMerge.GetQueueTimeout() < 0 {
+		problems = append(problems, Problem{Key: key("autoMerge.queueTimeout"), Message: "must not be negative"})
+	}
+	for _, t := range []struct{ name, text string }{
+		{"commitTitle", r.AutoMerge.CommitTitle},
+		{"commitBody", r.AutoMerge.CommitBody},
+	} {
+		if _, err := template.New(t.name).Funcs(TemplateFuncs).Parse(t.text); err != nil {
+			problems = append(problems, Problem{Key: key("autoMerge." + t.name), Message: err.Error()})
+		}
+	}
+	for i, w := range r.MergeFreeze {
+		if err := w.validate(); err != nil {
+			problems = append(problems, Problem{Key: key("mergeFreeze[" + strconv.Itoa(i) + "]"), Message: err.Error()})
+		}
+	}
+	return problems
+}
+
+// validReviewer tells whether a reviewer is given as login or as "org/team".
+func validReviewer(reviewer string) bool {
+	parts := strings.Split(reviewer, "/")
+	for _, part := range parts {
+		if part == "" {
+			return false
+		}
+	}
+	return len(parts) <= 2
+}
+
+func sortedStringKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// TemplateFuncs are the functions available in the templates of a repo
+// config in addition to the builtin ones.
+var TemplateFuncs = template.FuncMap{
+	"join": strings.Join,
+}
@@ -0,0 +1,27 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateRejectsInvalidPatterns(t *testing.T) {
+	cfg := NewWithDefaults()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("defaults should be valid: %v", err)
+	}
+
+	cfg.DefaultRepo.WipPatterns = []string{"wip", "(unclosed"}
+	cfg.Repos = map[string]RepoConfig{
+		"syndesisio/[docs": {},
+	}
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, expected := range []string{"defaults.wipPatterns", "repos.syndesisio/[docs"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error to mention %s, got: %v", expected, err)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright © 2017 Syndesis Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dedup remembers recently seen webhook delivery IDs so that
+// redelivered events are not handled twice.
+package dedup
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/syndesisio/pure-bot/pkg/config"
+)
+
+// Cache is a bounded set of delivery IDs. When the set is full the oldest ID
+// is evicted. If a file is configured every ID is appended to it, so that
+// the set survives a restart.
+type Cache struct {
+	size int
+	file string
+
+	mu      sync.Mutex
+	order   *list.List
+	ids     map[string]*list.Element
+	out     *os.File
+	written int
+}
+
+func New(cfg config.DedupConfig) (*Cache, error) {
+	size := cfg.Size
+	if size < 1 {
+		size = 1
+	}
+	c := &Cache{
+		size:  size,
+		file:  cfg.File,
+		order: list.New(),
+		ids:   make(map[string]*list.Element),
+	}
+
+	if c.file == "" {
+		return c, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.file), 0700); err != nil {
+		return nil, errors.Wrapf(err, "failed to create directory for %s", c.file)
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	if err := c.compact(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Seen records the ID and reports whether it has been recorded before.
+func (c *Cache) Seen(id string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.ids[id]; ok {
+		c.order.MoveToBack(el)
+		return true, nil
+	}
+	c.add(id)
+
+	if c.out == nil {
+		return false, nil
+	}
+	if _, err := fmt.Fprintln(c.out, id); err != nil {
+		return false, errors.Wrapf(err, "failed to persist delivery ID to %s", c.file)
+	}
+	c.written++
+	if c.written > 2*c.size {
+		return false, c.compact()
+	}
+	return false, nil
+}
+
+// Forget removes the ID again, e.g. when the delivery could not be accepted
+// and GitHub is expected to send it again.
+func (c *Cache) Forget(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.ids[id]
+	if !ok {
+		return nil
+	}
+	c.order.Remove(el)
+	delete(c.ids, id)
+	if c.out == nil {
+		return nil
+	}
+	return c.compact()
+}
+
+// Close releases the file backing the cache.
+func (c *Cache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.out == nil {
+		return nil
+	}
+	err := c.out.Close()
+	c.out = nil
+	return err
+}
+
+// add must be called with mu held.
+func (c *Cache) add(id string) {
+	c.ids[id] = c.order.PushBack(id)
+	for c.order.Len() > c.size {
+		oldest := c.order.Front()
+		c.order.Remove(oldest)
+		delete(c.ids, oldest.Value.(string))
+	}
+}
+
+func (c *Cache) load() error {
+	f, err := os.Open(c.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s", c.file)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		id := strings.TrimSpace(scanner.Text())
+		if id == "" {
+			continue
+		}
+		if el, ok := c.ids[id]; ok {
+			c.order.MoveToBack(el)
+			continue
+		}
+		c.add(id)
+	}
+	return errors.Wrapf(scanner.Err(), "failed to read %s", c.file)
+}
+
+// compact rewrites the file with the IDs currently held, so that it does not
+// grow without bounds. Must be called with mu held or before the cache is
+// shared.
+func (c *Cache) compact() error {
+	if c.out != nil {
+		c.out.Close()
+		c.out = nil
+	}
+
+	var b bytes.Buffer
+	for el := c.order.Front(); el != nil; el = el.Next() {
+		b.WriteString(el.Value.(string))
+		b.WriteString("\n")
+	}
+	tmp := c.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, b.Bytes(), 0600); err != nil {
+		return errors.Wrapf(err, "failed to write %s", tmp)
+	}
+	if err := os.Rename(tmp, c.file); err != nil {
+		return errors.Wrapf(err, "failed to replace %s", c.file)
+	}
+
+	out, err := os.OpenFile(c.file, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s", c.file)
+	}
+	c.out = out
+	c.written = c.order.Len()
+	return nil
+}
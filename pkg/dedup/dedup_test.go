@@ -0,0 +1,60 @@
+package dedup
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/syndesisio/pure-bot/pkg/config"
+)
+
+func TestSeenEvictsOldestAndPersists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := config.DedupConfig{Size: 2, File: filepath.Join(dir, "deliveries")}
+
+	c, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		if seen, err := c.Seen(id); err != nil || seen {
+			t.Fatalf("%s: expected unseen, got seen=%v err=%v", id, seen, err)
+		}
+	}
+	if seen, _ := c.Seen("c"); !seen {
+		t.Error("expected c to be seen")
+	}
+	c.Close()
+
+	c, err = New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if seen, _ := c.Seen("b"); !seen {
+		t.Error("expected b to survive a restart")
+	}
+	// "a" was evicted when "c" was added
+	if seen, _ := c.Seen("a"); seen {
+		t.Error("expected a to be evicted")
+	}
+}
+
+func TestForget(t *testing.T) {
+	c, err := New(config.DedupConfig{Size: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Seen("a")
+	if err := c.Forget("a"); err != nil {
+		t.Fatal(err)
+	}
+	if seen, _ := c.Seen("a"); seen {
+		t.Error("expected forgotten ID to be unseen")
+	}
+}
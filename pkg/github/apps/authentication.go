@@ -83,13 +83,40 @@ func NewTransport(tr http.RoundTripper, appID, installationID int64, privateKey
 		mu:             &sync.Mutex{},
 	}
 	var err error
-	t.key, err = jwt.ParseRSAPrivateKeyFromPEM(privateKey)
+	t.key, err = ParsePrivateKey(privateKey)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not parse private key")
+		return nil, err
 	}
 	return t, nil
 }
 
+// ParsePrivateKey parses a GitHub App's PEM encoded private key.
+func ParsePrivateKey(privateKey []byte) (*rsa.PrivateKey, error) {
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(privateKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse private key")
+	}
+	return key, nil
+}
+
+// NewJWT returns a signed JSON Web Token authenticating as the GitHub App
+// itself, valid for a minute.
+func NewJWT(appID int64, key *rsa.PrivateKey) (string, error) {
+	// TODO these claims could probably be reused between installations before expiry
+	claims := &jwt.StandardClaims{
+		IssuedAt:  time.Now().Unix(),
+		ExpiresAt: time.Now().Add(time.Minute).Unix(),
+		Issuer:    strconv.FormatInt(appID, 10),
+	}
+	bearer := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+
+	ss, err := bearer.SignedString(key)
+	if err != nil {
+		return "", errors.Wrap(err, "could not sign jwt")
+	}
+	return ss, nil
+}
+
 // RoundTrip implements http.RoundTripper interface.
 func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 	t.mu.Lock()
@@ -108,17 +135,9 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 }
 
 func (t *Transport) refreshToken() error {
-	// TODO these claims could probably be reused between installations before expiry
-	claims := &jwt.StandardClaims{
-		IssuedAt:  time.Now().Unix(),
-		ExpiresAt: time.Now().Add(time.Minute).Unix(),
-		Issuer:    strconv.FormatInt(t.appID, 10),
-	}
-	bearer := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-
-	ss, err := bearer.SignedString(t.key)
+	ss, err := NewJWT(t.appID, t.key)
 	if err != nil {
-		return errors.Wrap(err, "could not sign jwt")
+		return err
 	}
 
 	req, err := http.NewRequest("POST", fmt.Sprintf("%s/installations/%d/access_tokens", t.BaseURL, t.installationID), nil)
@@ -146,3 +165,31 @@ func (t *Transport) refreshToken() error {
 
 	return nil
 }
+
+// AppTransport authenticates requests as the GitHub App itself instead of
+// one of its installations, as required e.g. to list the installations.
+type AppTransport struct {
+	tr    http.RoundTripper // tr is the underlying roundtripper being wrapped
+	key   *rsa.PrivateKey   // key is the GitHub Apps's private key
+	appID int64             // appID is the GitHub App's ID
+}
+
+var _ http.RoundTripper = &AppTransport{}
+
+func NewAppTransport(tr http.RoundTripper, appID int64, privateKey []byte) (*AppTransport, error) {
+	key, err := ParsePrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return &AppTransport{tr: tr, key: key, appID: appID}, nil
+}
+
+func (t *AppTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ss, err := NewJWT(t.appID, t.key)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+ss)
+	return t.tr.RoundTrip(req)
+}
@@ -16,6 +16,8 @@ package apps
 
 import (
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/google/go-github/github"
 	"github.com/pkg/errors"
@@ -24,11 +26,53 @@ import (
 // Shared transport to reuse TCP connections.
 var tr = &http.Transport{}
 
-func Client(appID, installationID int64, privateKey []byte) (*github.Client, error) {
+// Middleware wraps the transport used for API requests made by a client,
+// e.g. to observe or intercept them. The requests fetching installation
+// tokens are not passed through middleware.
+type Middleware func(http.RoundTripper) http.RoundTripper
+
+// Client returns a GitHub client authenticated as the given installation.
+// An empty baseURL selects the public GitHub API, otherwise all requests
+// (including the ones fetching installation tokens) go to baseURL, e.g. a
+// GitHub Enterprise instance or a local fake of the API.
+func Client(appID, installationID int64, privateKey []byte, baseURL string, middleware ...Middleware) (*github.Client, error) {
 	itr, err := NewTransport(tr, appID, installationID, privateKey)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create transport from private key file")
 	}
 
-	return github.NewClient(&http.Client{Transport: itr}), nil
+	client, err := newClient(itr, baseURL, middleware)
+	if err != nil {
+		return nil, err
+	}
+	if baseURL != "" {
+		itr.BaseURL = strings.TrimSuffix(baseURL, "/")
+	}
+	return client, nil
+}
+
+// AppClient returns a GitHub client authenticated as the GitHub App itself,
+// which can only access the app and its installations.
+func AppClient(appID int64, privateKey []byte, baseURL string, middleware ...Middleware) (*github.Client, error) {
+	atr, err := NewAppTransport(tr, appID, privateKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create transport from private key file")
+	}
+	return newClient(atr, baseURL, middleware)
+}
+
+func newClient(rt http.RoundTripper, baseURL string, middleware []Middleware) (*github.Client, error) {
+	for _, m := range middleware {
+		rt = m(rt)
+	}
+
+	client := github.NewClient(&http.Client{Transport: rt})
+	if baseURL != "" {
+		u, err := url.Parse(strings.TrimSuffix(baseURL, "/") + "/")
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid GitHub API URL %s", baseURL)
+		}
+		client.BaseURL = u
+	}
+	return client, nil
 }
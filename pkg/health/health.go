@@ -0,0 +1,98 @@
+// Copyright © 2017 Syndesis Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package health serves the liveness and readiness endpoints.
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	statusOK     = "ok"
+	statusFailed = "failed"
+)
+
+// Check is a single named readiness check.
+type Check struct {
+	Name  string
+	Check func() error
+}
+
+// Cached returns a check which only calls fn again once ttl has passed since
+// its last call, e.g. to keep frequent probes from hitting a rate limited API.
+func Cached(ttl time.Duration, fn func() error) func() error {
+	var (
+		mu      sync.Mutex
+		checked time.Time
+		last    error
+	)
+	return func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		if checked.IsZero() || time.Since(checked) >= ttl {
+			last = fn()
+			checked = time.Now()
+		}
+		return last
+	}
+}
+
+type checkResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type report struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks,omitempty"`
+}
+
+// LivenessHandler reports that the process is up and serving requests.
+func LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, http.StatusOK, report{Status: statusOK})
+	})
+}
+
+// ReadinessHandler runs all checks on every request and reports the result
+// of each of them. The status code is 503 if any check failed.
+func ReadinessHandler(checks ...Check) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rep := report{
+			Status: statusOK,
+			Checks: make(map[string]checkResult),
+		}
+		code := http.StatusOK
+		for _, c := range checks {
+			if err := c.Check(); err != nil {
+				rep.Checks[c.Name] = checkResult{Status: statusFailed, Error: err.Error()}
+				rep.Status = statusFailed
+				code = http.StatusServiceUnavailable
+				continue
+			}
+			rep.Checks[c.Name] = checkResult{Status: statusOK}
+		}
+		writeReport(w, code, rep)
+	})
+}
+
+func writeReport(w http.ResponseWriter, code int, rep report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(rep)
+}
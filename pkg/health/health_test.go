@@ -0,0 +1,50 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadinessReportsEachCheck(t *testing.T) {
+	handler := ReadinessHandler(
+		Check{Name: "good", Check: func() error { return nil }},
+		Check{Name: "bad", Check: func() error { return errors.New("broken") }},
+	)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503, got %d", rec.Code)
+	}
+	var rep report
+	if err := json.Unmarshal(rec.Body.Bytes(), &rep); err != nil {
+		t.Fatal(err)
+	}
+	if rep.Status != statusFailed {
+		t.Errorf("expected overall status %q, got %q", statusFailed, rep.Status)
+	}
+	if rep.Checks["good"].Status != statusOK {
+		t.Errorf("expected good check to be ok, got %+v", rep.Checks["good"])
+	}
+	if rep.Checks["bad"].Status != statusFailed || rep.Checks["bad"].Error != "broken" {
+		t.Errorf("expected bad check to fail, got %+v", rep.Checks["bad"])
+	}
+}
+
+func TestCachedCheck(t *testing.T) {
+	calls := 0
+	check := Cached(time.Hour, func() error {
+		calls++
+		return nil
+	})
+	check()
+	check()
+	if calls != 1 {
+		t.Errorf("expected one call within the interval, got %d", calls)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright © 2017 Syndesis Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics keeps track of the bot's metrics with the Prometheus client
+// and serves them, together with the Go runtime and process metrics.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// registry holds the bot's metrics only, so that they don't clash with the
+// ones of libraries registering with the default registry.
+var registry = prometheus.NewRegistry()
+
+func init() {
+	registry.MustRegister(prometheus.NewGoCollector(), prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+}
+
+// Handler serves all registered metrics.
+func Handler() http.Handler {
+	return handlerFor(registry)
+}
+
+func handlerFor(g prometheus.Gatherer) http.Handler {
+	return promhttp.HandlerFor(g, promhttp.HandlerOpts{})
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	vec *prometheus.CounterVec
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return newCounterVec(registry, name, help, labels...)
+}
+
+func newCounterVec(r prometheus.Registerer, name, help string, labels ...string) *CounterVec {
+	vec := prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, labels)
+	r.MustRegister(vec)
+	return &CounterVec{vec}
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.vec.WithLabelValues(labelValues...).Inc()
+}
+
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+	c.vec.WithLabelValues(labelValues...).Add(value)
+}
+
+// GaugeVec is a gauge partitioned by labels.
+type GaugeVec struct {
+	vec *prometheus.GaugeVec
+}
+
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return newGaugeVec(registry, name, help, labels...)
+}
+
+func newGaugeVec(r prometheus.Registerer, name, help string, labels ...string) *GaugeVec {
+	vec := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, labels)
+	r.MustRegister(vec)
+	return &GaugeVec{vec}
+}
+
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.vec.WithLabelValues(labelValues...).Set(value)
+}
+
+// DefaultBuckets are the upper bounds of histogram buckets, in seconds,
+// suited for the duration of handlers and API calls.
+var DefaultBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	vec *prometheus.HistogramVec
+}
+
+// NewHistogramVec creates a histogram with the given bucket upper bounds,
+// which must be sorted in increasing order.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return newHistogramVec(registry, name, help, buckets, labels...)
+}
+
+func newHistogramVec(r prometheus.Registerer, name, help string, buckets []float64, labels ...string) *HistogramVec {
+	vec := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: name, Help: help, Buckets: buckets}, labels)
+	r.MustRegister(vec)
+	return &HistogramVec{vec}
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.vec.WithLabelValues(labelValues...).Observe(value)
+}
@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+func scrape(t *testing.T, handler http.Handler) map[string]*dto.MetricFamily {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return families
+}
+
+func TestExposition(t *testing.T) {
+	// A registry of its own, as the test metrics can't be registered twice
+	// with the package's one when the test is repeated
+	r := prometheus.NewRegistry()
+	counter := newCounterVec(r, "test_events_total", "Events.", "type")
+	counter.Inc("status")
+	counter.Add(2, `quote"d`)
+
+	gauge := newGaugeVec(r, "test_remaining", "Remaining.")
+	gauge.Set(42)
+
+	histogram := newHistogramVec(r, "test_duration_seconds", "Duration.", []float64{0.1, 1}, "handler")
+	histogram.Observe(0.05, "wip")
+	histogram.Observe(0.5, "wip")
+	histogram.Observe(5, "wip")
+
+	families := scrape(t, handlerFor(r))
+
+	metric := func(name, labelValue string) *dto.Metric {
+		for _, m := range families[name].GetMetric() {
+			if len(m.Label) == 0 && labelValue == "" || len(m.Label) > 0 && m.Label[0].GetValue() == labelValue {
+				return m
+			}
+		}
+		t.Fatalf("missing metric %s{%q}", name, labelValue)
+		return nil
+	}
+	if v := metric("test_events_total", "status").Counter.GetValue(); v != 1 {
+		t.Errorf("expected counter 1, got %v", v)
+	}
+	if v := metric("test_events_total", `quote"d`).Counter.GetValue(); v != 2 {
+		t.Errorf("expected counter 2, got %v", v)
+	}
+	if v := metric("test_remaining", "").Gauge.GetValue(); v != 42 {
+		t.Errorf("expected gauge 42, got %v", v)
+	}
+	h := metric("test_duration_seconds", "wip").Histogram
+	var counts []uint64
+	for _, b := range h.Bucket {
+		counts = append(counts, b.GetCumulativeCount())
+	}
+	if h.GetSampleCount() != 3 || h.GetSampleSum() != 5.55 || !reflect.DeepEqual(counts, []uint64{1, 2, 3}) {
+		t.Errorf("unexpected histogram %v", h)
+	}
+}
+
+func TestHandlerExposesRuntimeMetrics(t *testing.T) {
+	if _, ok := scrape(t, Handler())["go_goroutines"]; !ok {
+		t.Error("missing Go runtime metrics")
+	}
+}
@@ -0,0 +1,307 @@
+// Copyright © 2017 Syndesis Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/syndesisio/pure-bot/pkg/config"
+)
+
+const (
+	eventFileSuffix = ".json"
+	failedDir       = "failed"
+)
+
+// Event is a single webhook delivery as it is stored on disk.
+type Event struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	DeliveryID string          `json:"deliveryId"`
+	Payload    json.RawMessage `json:"payload"`
+	Received   time.Time       `json:"received"`
+	Attempts   int             `json:"attempts"`
+	// Handled lists the handlers which already succeeded, so that a retry
+	// only calls the ones which failed.
+	Handled []string `json:"handled,omitempty"`
+}
+
+// ProcessFunc handles a single event. A returned error schedules a retry.
+type ProcessFunc func(event *Event) error
+
+// Queue is a durable FIFO of webhook events. Every event is written to its
+// own file in the queue directory before Push returns, and is only removed
+// once it has been processed successfully or has run out of attempts, in
+// which case it is moved to the "failed" sub directory. Events still on disk
+// when the bot starts are picked up again by Start.
+type Queue struct {
+	dir         string
+	maxAttempts int
+	retryDelay  time.Duration
+	logger      *zap.Logger
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []string
+	queued  map[string]bool
+	stopped bool
+	seq     uint64
+	wg      sync.WaitGroup
+}
+
+func New(cfg config.QueueConfig, logger *zap.Logger) (*Queue, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("no queue directory configured")
+	}
+	if err := os.MkdirAll(filepath.Join(cfg.Dir, failedDir), 0700); err != nil {
+		return nil, errors.Wrapf(err, "failed to create queue directory %s", cfg.Dir)
+	}
+
+	q := &Queue{
+		dir:         cfg.Dir,
+		maxAttempts: cfg.MaxAttempts,
+		retryDelay:  cfg.RetryDelay,
+		logger:      logger,
+		queued:      make(map[string]bool),
+	}
+	if q.maxAttempts < 1 {
+		q.maxAttempts = 1
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q, nil
+}
+
+// Push persists the event and schedules it for processing.
+func (q *Queue) Push(event *Event) error {
+	q.mu.Lock()
+	q.seq++
+	seq := q.seq
+	q.mu.Unlock()
+
+	if event.Received.IsZero() {
+		event.Received = time.Now()
+	}
+	// File names sort in arrival order, which is the order events are
+	// replayed in after a restart.
+	event.ID = fmt.Sprintf("%019d-%06d", event.Received.UnixNano(), seq%1000000)
+	if err := q.write(event); err != nil {
+		return err
+	}
+
+	q.schedule(event.ID)
+	return nil
+}
+
+// Start loads all events left over from a previous run and starts the given
+// number of workers calling fn for each event.
+func (q *Queue) Start(workers int, fn ProcessFunc) error {
+	files, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read queue directory %s", q.dir)
+	}
+
+	var ids []string
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), eventFileSuffix) {
+			continue
+		}
+		ids = append(ids, strings.TrimSuffix(f.Name(), eventFileSuffix))
+	}
+	sort.Strings(ids)
+	if len(ids) > 0 {
+		q.logger.Info("Resuming queued events", zap.Int("count", len(ids)))
+	}
+
+	q.mu.Lock()
+	for _, id := range ids {
+		q.enqueue(id)
+	}
+	q.mu.Unlock()
+
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.work(fn)
+	}
+	return nil
+}
+
+// Stop lets the workers finish the events they are currently processing and
+// waits for them to return. Events not yet processed stay on disk.
+func (q *Queue) Stop() {
+	q.mu.Lock()
+	q.stopped = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+	q.wg.Wait()
+}
+
+// Len returns the number of events waiting to be processed.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+func (q *Queue) schedule(id string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.stopped {
+		return
+	}
+	q.enqueue(id)
+}
+
+// enqueue must be called with mu held.
+func (q *Queue) enqueue(id string) {
+	if q.queued[id] {
+		return
+	}
+	q.queued[id] = true
+	q.pending = append(q.pending, id)
+	q.cond.Signal()
+}
+
+func (q *Queue) next() (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.pending) == 0 && !q.stopped {
+		q.cond.Wait()
+	}
+	if q.stopped {
+		return "", false
+	}
+	id := q.pending[0]
+	q.pending = q.pending[1:]
+	delete(q.queued, id)
+	return id, true
+}
+
+func (q *Queue) work(fn ProcessFunc) {
+	defer q.wg.Done()
+	for {
+		id, ok := q.next()
+		if !ok {
+			return
+		}
+		q.process(id, fn)
+	}
+}
+
+func (q *Queue) process(id string, fn ProcessFunc) {
+	logger := q.logger.With(zap.String("event", id))
+
+	event, err := q.read(id)
+	if err != nil {
+		logger.Error("failed to read queued event, moving it aside", zap.Error(err))
+		q.fail(id, logger)
+		return
+	}
+	logger = logger.With(zap.String("type", event.Type), zap.String("delivery", event.DeliveryID))
+
+	err = fn(event)
+	if err == nil {
+		if err := os.Remove(q.path(id)); err != nil {
+			logger.Error("failed to remove processed event", zap.Error(err))
+		}
+		return
+	}
+
+	event.Attempts++
+	if event.Attempts >= q.maxAttempts {
+		logger.Error("giving up on event", zap.Int("attempts", event.Attempts), zap.String("error", fmt.Sprintf("%+v", err)))
+		if err := q.write(event); err != nil {
+			logger.Error("failed to update event", zap.Error(err))
+		}
+		q.fail(id, logger)
+		return
+	}
+
+	if err := q.write(event); err != nil {
+		logger.Error("failed to update event", zap.Error(err))
+	}
+	delay := q.retryDelay * time.Duration(1<<uint(event.Attempts-1))
+	logger.Warn("event failed, retrying", zap.Int("attempts", event.Attempts), zap.Duration("delay", delay), zap.String("error", fmt.Sprintf("%+v", err)))
+	time.AfterFunc(delay, func() {
+		q.schedule(id)
+	})
+}
+
+func (q *Queue) fail(id string, logger *zap.Logger) {
+	if err := os.Rename(q.path(id), filepath.Join(q.dir, failedDir, id+eventFileSuffix)); err != nil {
+		logger.Error("failed to move event to failed directory", zap.Error(err))
+	}
+}
+
+func (q *Queue) path(id string) string {
+	return filepath.Join(q.dir, id+eventFileSuffix)
+}
+
+func (q *Queue) read(id string) (*Event, error) {
+	data, err := ioutil.ReadFile(q.path(id))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read event file")
+	}
+	var event Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, errors.Wrap(err, "failed to decode event file")
+	}
+	return &event, nil
+}
+
+// write stores the event via a temporary file so that a crash never leaves a
+// partially written event behind.
+func (q *Queue) write(event *Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode event")
+	}
+	tmp, err := ioutil.TempFile(q.dir, "."+event.ID)
+	if err != nil {
+		return errors.Wrap(err, "failed to create event file")
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "failed to write event file")
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "failed to sync event file")
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "failed to close event file")
+	}
+	if err := os.Rename(tmp.Name(), q.path(event.ID)); err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "failed to store event file")
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package queue
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/syndesisio/pure-bot/pkg/config"
+)
+
+func newTestQueue(t *testing.T, dir string) *Queue {
+	q, err := New(config.QueueConfig{Dir: dir, MaxAttempts: 2, RetryDelay: time.Millisecond}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func TestEventsSurviveRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Events pushed without workers running stay on disk
+	q := newTestQueue(t, dir)
+	for _, delivery := range []string{"a", "b", "c"} {
+		if err := q.Push(&Event{Type: "status", DeliveryID: delivery, Payload: []byte(`{}`)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	q.Stop()
+
+	q = newTestQueue(t, dir)
+	var (
+		mu        sync.Mutex
+		processed []string
+		done      = make(chan struct{})
+	)
+	err = q.Start(1, func(event *Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		processed = append(processed, event.DeliveryID)
+		if len(processed) == 3 {
+			close(done)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for events")
+	}
+	q.Stop()
+
+	if processed[0] != "a" || processed[1] != "b" || processed[2] != "c" {
+		t.Errorf("events processed out of order: %v", processed)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 0 {
+		t.Errorf("processed events not removed: %v", files)
+	}
+}
+
+func TestFailedEventsAreMovedAside(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	q := newTestQueue(t, dir)
+	attempts := make(chan int, 10)
+	err = q.Start(1, func(event *Event) error {
+		attempts <- event.Attempts
+		return errors.New("handler failed")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Push(&Event{Type: "status", Payload: []byte(`{}`)}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case n := <-attempts:
+			if n != i {
+				t.Errorf("expected attempt %d, got %d", i, n)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for retry")
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		files, _ := filepath.Glob(filepath.Join(dir, failedDir, "*.json"))
+		if len(files) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("event was not moved to the failed directory")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	q.Stop()
+}
@@ -58,7 +58,7 @@ func (h *addReviewUiComment) HandleEvent(eventObject interface{}, gh *github.Cli
 	}
 
 	circleCiUrl := fmt.Sprintf("https://%s-%d-gh.circle-artifacts.com/0/home/circleci/src/app/ui-react/doc/index.html", circleCiBuildId[1], event.Repo.GetID())
-	existingComments, _, err := gh.Issues.ListComments(context.Background(), owner, repo, prNumber, &github.IssueListCommentsOptions{
+	existingComments, err := listAllComments(gh, owner, repo, prNumber, github.IssueListCommentsOptions{
 		Sort:      "updated",
 		Direction: "desc",
 	})
@@ -0,0 +1,210 @@
+// Copyright © 2017 Syndesis Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/syndesisio/pure-bot/pkg/codeowners"
+	"github.com/syndesisio/pure-bot/pkg/config"
+)
+
+const (
+	changesRequestedReviewState = "changes_requested"
+	commentedReviewState        = "commented"
+)
+
+// latestReviewStates returns the state of every reviewer's latest review,
+// lower cased, and the reviewers in the order of their first review. Plain
+// comments don't change a reviewer's state.
+func latestReviewStates(gh *github.Client, owner, repository string, number int) ([]string, map[string]string, error) {
+	reviews, err := listAllReviews(gh, owner, repository, number)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to list reviews of pull request %d", number)
+	}
+
+	var reviewers []string
+	states := make(map[string]string)
+	for _, review := range reviews {
+		login := review.GetUser().GetLogin()
+		state := strings.ToLower(review.GetState())
+		if state == commentedReviewState {
+			continue
+		}
+		if _, seen := states[login]; !seen {
+			reviewers = append(reviewers, login)
+		}
+		states[login] = state
+	}
+	return reviewers, states, nil
+}
+
+// listApprovers returns the users whose latest review approves the pull
+// request.
+func listApprovers(gh *github.Client, owner, repository string, number int) ([]string, error) {
+	reviewers, states, err := latestReviewStates(gh, owner, repository, number)
+	if err != nil {
+		return nil, err
+	}
+
+	var approvers []string
+	for _, login := range reviewers {
+		if states[login] == approvedReviewState {
+			approvers = append(approvers, login)
+		}
+	}
+	return approvers, nil
+}
+
+// checkApprovals verifies the reviews required by the autoMerge config. It
+// returns why the pull request may not be merged yet, or an empty string.
+func checkApprovals(pr *github.PullRequest, owner, repository string, gh *github.Client, cfg config.AutoMergeConfig, logger *zap.Logger) (string, error) {
+	if cfg.GetRequiredApprovals() <= 0 && !cfg.GetCodeOwners() {
+		return "", nil
+	}
+
+	reviewers, states, err := latestReviewStates(gh, owner, repository, pr.GetNumber())
+	if err != nil {
+		return "", err
+	}
+	var approvers []string
+	for _, login := range reviewers {
+		switch states[login] {
+		case changesRequestedReviewState:
+			return login + " requested changes", nil
+		case approvedReviewState:
+			approvers = append(approvers, login)
+		}
+	}
+	if len(approvers) < cfg.GetRequiredApprovals() {
+		return fmt.Sprintf("%d of %d required approvals", len(approvers), cfg.GetRequiredApprovals()), nil
+	}
+
+	if !cfg.GetCodeOwners() {
+		return "", nil
+	}
+	return checkCodeOwners(pr, owner, repository, gh, approvers, logger)
+}
+
+// checkCodeOwners verifies that every changed path with owners is approved
+// by one of them. Pull requests are not restricted if the base branch has no
+// CODEOWNERS file.
+func checkCodeOwners(pr *github.PullRequest, owner, repository string, gh *github.Client, approvers []string, logger *zap.Logger) (string, error) {
+	owners, err := loadCodeOwners(gh, owner, repository, pr.Base.GetRef())
+	if err != nil || owners == nil {
+		return "", err
+	}
+
+	files, err := listAllFiles(gh, owner, repository, pr.GetNumber())
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to list files of pull request %s", pr.GetHTMLURL())
+	}
+
+	teams := make(map[string]bool)
+	for _, file := range files {
+		pathOwners := owners.Owners(file.GetFilename())
+		if len(pathOwners) == 0 {
+			continue
+		}
+		approved, err := approvedByOwner(gh, pathOwners, approvers, teams)
+		if err != nil {
+			return "", err
+		}
+		if !approved {
+			logger.Debug("Missing code owner approval", zap.String("path", file.GetFilename()), zap.Strings("owners", pathOwners))
+			return "no approval by a code owner of " + file.GetFilename(), nil
+		}
+	}
+	return "", nil
+}
+
+func loadCodeOwners(gh *github.Client, owner, repository, ref string) (*codeowners.File, error) {
+	for _, path := range codeowners.Files {
+		content, _, _, err := gh.Repositories.GetContents(context.Background(), owner, repository, path, &github.RepositoryContentGetOptions{Ref: ref})
+		if err != nil {
+			if errResp, ok := err.(*github.ErrorResponse); ok && errResp.Response.StatusCode == http.StatusNotFound {
+				continue
+			}
+			return nil, errors.Wrapf(err, "failed to get %s", path)
+		}
+		data, err := content.GetContent()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode %s", path)
+		}
+		return codeowners.Parse([]byte(data)), nil
+	}
+	return nil, nil
+}
+
+// approvedByOwner tells whether one of the approvers is one of the owners,
+// directly or as a member of an owning team. Team memberships are cached in
+// teams. Owners given by email address can't be matched to approvers.
+func approvedByOwner(gh *github.Client, owners, approvers []string, teams map[string]bool) (bool, error) {
+	for _, owner := range owners {
+		if !strings.HasPrefix(owner, "@") {
+			continue
+		}
+		name := owner[1:]
+		slash := strings.Index(name, "/")
+		for _, approver := range approvers {
+			if slash < 0 {
+				if strings.EqualFold(name, approver) {
+					return true, nil
+				}
+				continue
+			}
+
+			key := strings.ToLower(name + ":" + approver)
+			member, cached := teams[key]
+			if !cached {
+				var err error
+				if member, err = isTeamMember(gh, name[:slash], name[slash+1:], approver); err != nil {
+					return false, err
+				}
+				teams[key] = member
+			}
+			if member {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// isTeamMember looks the team up by its slug, which the Teams API of the
+// GitHub client doesn't support.
+func isTeamMember(gh *github.Client, org, slug, login string) (bool, error) {
+	u := fmt.Sprintf("orgs/%s/teams/%s/memberships/%s", org, slug, login)
+	req, err := gh.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to create team membership request")
+	}
+
+	membership := new(github.Membership)
+	if _, err := gh.Do(context.Background(), req, membership); err != nil {
+		if errResp, ok := err.(*github.ErrorResponse); ok && errResp.Response.StatusCode == http.StatusNotFound {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "failed to get membership of %s in @%s/%s", login, org, slug)
+	}
+	return membership.GetState() == "active", nil
+}
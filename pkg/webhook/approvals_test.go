@@ -0,0 +1,85 @@
+package webhook
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-github/github"
+	"go.uber.org/zap"
+
+	"github.com/syndesisio/pure-bot/pkg/config"
+)
+
+func TestCheckApprovals(t *testing.T) {
+	reviews := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/o/r/pulls/1/reviews":
+			w.Write([]byte(reviews))
+		case "/repos/o/r/contents/.github/CODEOWNERS":
+			content := base64.StdEncoding.EncodeToString([]byte("*  @alice\n/ui/  @o/frontend\n"))
+			w.Write([]byte(`{"type": "file", "encoding": "base64", "content": "` + content + `"}`))
+		case "/repos/o/r/pulls/1/files":
+			w.Write([]byte(`[{"filename": "README.md"}, {"filename": "ui/app.js"}]`))
+		case "/orgs/o/teams/frontend/memberships/carol":
+			w.Write([]byte(`{"state": "active"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	pr := &github.PullRequest{Number: github.Int(1), Base: &github.PullRequestBranch{Ref: github.String("main")}}
+
+	tests := []struct {
+		name    string
+		reviews string
+		config  config.AutoMergeConfig
+		reason  string
+	}{
+		{
+			name:    "not configured",
+			reviews: `[]`,
+		},
+		{
+			name:    "too few approvals",
+			reviews: `[{"user": {"login": "alice"}, "state": "APPROVED"}, {"user": {"login": "bob"}, "state": "COMMENTED"}]`,
+			config:  config.AutoMergeConfig{RequiredApprovals: config.Int(2)},
+			reason:  "1 of 2 required approvals",
+		},
+		{
+			name:    "later changes requested",
+			reviews: `[{"user": {"login": "alice"}, "state": "APPROVED"}, {"user": {"login": "bob"}, "state": "APPROVED"}, {"user": {"login": "bob"}, "state": "CHANGES_REQUESTED"}]`,
+			config:  config.AutoMergeConfig{RequiredApprovals: config.Int(1)},
+			reason:  "bob requested changes",
+		},
+		{
+			name:    "missing team owner",
+			reviews: `[{"user": {"login": "alice"}, "state": "APPROVED"}, {"user": {"login": "bob"}, "state": "APPROVED"}]`,
+			config:  config.AutoMergeConfig{CodeOwners: config.Bool(true)},
+			reason:  "no approval by a code owner of ui/app.js",
+		},
+		{
+			name:    "all paths approved",
+			reviews: `[{"user": {"login": "alice"}, "state": "APPROVED"}, {"user": {"login": "carol"}, "state": "APPROVED"}]`,
+			config:  config.AutoMergeConfig{RequiredApprovals: config.Int(2), CodeOwners: config.Bool(true)},
+		},
+	}
+
+	for _, test := range tests {
+		reviews = test.reviews
+		reason, err := checkApprovals(pr, "o", "r", client, test.config, zap.NewNop())
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if reason != test.reason {
+			t.Errorf("%s: expected %q, got %q", test.name, test.reason, reason)
+		}
+	}
+}
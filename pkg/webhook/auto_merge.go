@@ -18,6 +18,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -29,15 +30,26 @@ import (
 )
 
 const (
-	labeledEvent                = "labeled"
-	statusEventSuccessState     = "success"
-	checkEventSuccessConclusion = "success"
+	labeledEvent              = "labeled"
+	statusEventSuccessState   = "success"
+	checkEventCompletedAction = "completed"
 )
 
+// checkPassed tells whether a check run or suite conclusion counts as passing.
+// GitHub counts neutral and skipped checks, e.g. jobs skipped by path filters
+// or conditions, as passing.
+func checkPassed(conclusion string) bool {
+	switch conclusion {
+	case "success", "neutral", "skipped":
+		return true
+	}
+	return false
+}
+
 type autoMerger struct{}
 
 func (h *autoMerger) EventTypesHandled() []string {
-	return []string{"pull_request", "status", "pull_request_review"}
+	return []string{"pull_request", "status", "pull_request_review", "check_run", "check_suite"}
 }
 
 func (h *autoMerger) HandleEvent(eventObject interface{}, gh *github.Client, config config.RepoConfig, logger *zap.Logger) error {
@@ -54,6 +66,10 @@ func (h *autoMerger) HandleEvent(eventObject interface{}, gh *github.Client, con
 		return h.handleStatusEvent(event, gh, config, logger)
 	case *github.PullRequestReviewEvent:
 		return h.handlePullRequestReviewEvent(event, gh, config, logger)
+	case *github.CheckRunEvent:
+		return h.handleCheckRunEvent(event, gh, config, logger)
+	case *github.CheckSuiteEvent:
+		return h.handleCheckSuiteEvent(event, gh, config, logger)
 	default:
 		return nil
 	}
@@ -70,6 +86,10 @@ func (h *autoMerger) handlePullRequestReviewEvent(event *github.PullRequestRevie
 
 func (h *autoMerger) handlePullRequestEvent(event *github.PullRequestEvent, gh *github.Client, config config.RepoConfig, logger *zap.Logger) error {
 
+	if config.AutoMerge.GetQueue() && leavesMergeQueue(event, config) {
+		return dequeueMerge(event.PullRequest, event.Repo.Owner.GetLogin(), event.Repo.GetName(), gh, config, logger)
+	}
+
 	if strings.ToLower(event.GetAction()) != labeledEvent {
 		logger.Debug("skipping PullRequest event as it is not a label event", zap.String("action", event.GetAction()), zap.Int("pr", event.PullRequest.GetNumber()))
 		return nil
@@ -81,29 +101,101 @@ func (h *autoMerger) handlePullRequestEvent(event *github.PullRequestEvent, gh *
 func (h *autoMerger) handleStatusEvent(event *github.StatusEvent, gh *github.Client, config config.RepoConfig, logger *zap.Logger) error {
 
 	if strings.ToLower(event.GetState()) != statusEventSuccessState {
+		if config.AutoMerge.GetQueue() && strings.ToLower(event.GetState()) != string(pendingStatus) {
+			return processMergeQueuesOf(event.Repo.Owner.GetLogin(), event.Repo.GetName(), event.GetSHA(), gh, config, logger)
+		}
 		logger.Debug("skipping status event as it dosn't report success: ", zap.String("state", event.GetState()))
 		return nil
 	}
 
-	commitSHA := event.GetSHA()
-	query := fmt.Sprintf("type:pr state:open repo:%s %s", event.Repo.GetFullName(), commitSHA)
-	searchResult, _, err := gh.Search.Issues(context.Background(), query, nil)
+	return h.mergePRsForCommit(event.Repo, event.GetSHA(), gh, config, logger)
+}
+
+func (h *autoMerger) handleCheckRunEvent(event *github.CheckRunEvent, gh *github.Client, config config.RepoConfig, logger *zap.Logger) error {
+	run := event.GetCheckRun()
+	if event.GetAction() == checkEventCompletedAction && !checkPassed(run.GetConclusion()) && config.AutoMerge.GetQueue() {
+		return processMergeQueuesOf(event.Repo.Owner.GetLogin(), event.Repo.GetName(), run.GetHeadSHA(), gh, config, logger)
+	}
+	if event.GetAction() != checkEventCompletedAction || !checkPassed(run.GetConclusion()) {
+		logger.Debug("skipping check run event as it doesn't report success", zap.String("action", event.GetAction()), zap.String("conclusion", run.GetConclusion()))
+		return nil
+	}
+
+	return h.mergePRsForCheck(event.Repo, run.GetHeadSHA(), run.PullRequests, gh, config, logger)
+}
+
+func (h *autoMerger) handleCheckSuiteEvent(event *github.CheckSuiteEvent, gh *github.Client, config config.RepoConfig, logger *zap.Logger) error {
+	suite := event.GetCheckSuite()
+	if event.GetAction() == checkEventCompletedAction && !checkPassed(suite.GetConclusion()) && config.AutoMerge.GetQueue() {
+		return processMergeQueuesOf(event.Repo.Owner.GetLogin(), event.Repo.GetName(), suite.GetHeadSHA(), gh, config, logger)
+	}
+	if event.GetAction() != checkEventCompletedAction || !checkPassed(suite.GetConclusion()) {
+		logger.Debug("skipping check suite event as it doesn't report success", zap.String("action", event.GetAction()), zap.String("conclusion", suite.GetConclusion()))
+		return nil
+	}
+
+	return h.mergePRsForCheck(event.Repo, suite.GetHeadSHA(), suite.PullRequests, gh, config, logger)
+}
+
+// mergePRsForCheck evaluates the pull requests a check ran for. GitHub leaves
+// the list empty for pull requests from forks, so these are looked up by the
+// head SHA instead.
+func (h *autoMerger) mergePRsForCheck(repo *github.Repository, commitSHA string, pullRequests []*github.PullRequest, gh *github.Client, config config.RepoConfig, logger *zap.Logger) error {
+	if len(pullRequests) == 0 {
+		return h.mergePRsForCommit(repo, commitSHA, gh, config, logger)
+	}
+
+	var multiErr error
+	for _, pullRequest := range pullRequests {
+		if id := pullRequest.GetBase().GetRepo().GetID(); id != 0 && id != repo.GetID() {
+			continue
+		}
+
+		pr, _, err := gh.PullRequests.Get(context.Background(), repo.Owner.GetLogin(), repo.GetName(), pullRequest.GetNumber())
+		if err != nil {
+			multiErr = multierr.Combine(multiErr, err)
+			continue
+		}
+		if pr.GetState() != "open" {
+			continue
+		}
+
+		issue, _, err := gh.Issues.Get(context.Background(), repo.Owner.GetLogin(), repo.GetName(), pr.GetNumber())
+		if err != nil {
+			multiErr = multierr.Combine(multiErr, err)
+			continue
+		}
+
+		err = mergePR(issue, pr, repo.Owner.GetLogin(), repo.GetName(), gh, commitSHA, config, logger)
+		if err != nil {
+			multiErr = multierr.Combine(multiErr, err)
+			continue
+		}
+	}
+
+	return multiErr
+}
+
+// mergePRsForCommit evaluates all open pull requests whose head is commitSHA.
+func (h *autoMerger) mergePRsForCommit(repo *github.Repository, commitSHA string, gh *github.Client, config config.RepoConfig, logger *zap.Logger) error {
+	query := fmt.Sprintf("type:pr state:open repo:%s %s", repo.GetFullName(), commitSHA)
+	searchResult, err := searchAllIssues(gh, query)
 	if err != nil {
 		return errors.Wrap(err, "failed to search for open issues")
 	}
 	var multiErr error
-	for _, issue := range searchResult.Issues {
+	for _, issue := range searchResult {
 		if issue.PullRequestLinks == nil {
 			continue
 		}
 
-		pr, _, err := gh.PullRequests.Get(context.Background(), event.Repo.Owner.GetLogin(), event.Repo.GetName(), issue.GetNumber())
+		pr, _, err := gh.PullRequests.Get(context.Background(), repo.Owner.GetLogin(), repo.GetName(), issue.GetNumber())
 		if err != nil {
 			multiErr = multierr.Combine(multiErr, err)
 			continue
 		}
 
-		err = mergePR(&issue, pr, event.Repo.Owner.GetLogin(), event.Repo.GetName(), gh, commitSHA, config, logger)
+		err = mergePR(&issue, pr, repo.Owner.GetLogin(), repo.GetName(), gh, commitSHA, config, logger)
 		if err != nil {
 			multiErr = multierr.Combine(multiErr, err)
 			continue
@@ -129,61 +221,164 @@ func mergePR(issue *github.Issue, pr *github.PullRequest, owner, repository stri
 
 	if commitSHA != "" && pr.Head.GetSHA() != commitSHA {
 		logger.Debug("Commit SHA is unequal PR Head SHA", zap.String("commitSHA", commitSHA), zap.String("prHeadSha", pr.Head.GetSHA()))
+		autoMergeResults.Inc("stale")
 		return nil
 	}
-	commitSHA = pr.Head.GetSHA()
 
-	statuses, _, err := gh.Repositories.GetCombinedStatus(context.Background(), owner, repository, commitSHA, nil)
+	frozen, err := checkMergeFreeze(pr, owner, repository, gh, config, logger)
+	if err != nil || frozen {
+		return err
+	}
+
+	result, reason, err := checkMergeable(pr, owner, repository, gh, config, logger)
 	if err != nil {
-		return errors.Wrapf(err, "failed to get statuses of pull request %s", issue.GetHTMLURL())
+		return err
+	}
+	if reason != "" {
+		logger.Debug("Not merging pull request", zap.Int("pr", pr.GetNumber()), zap.String("reason", reason))
+		autoMergeResults.Inc(result)
+		if config.AutoMerge.GetQueue() && result != mergeUnknown {
+			return dequeueMerge(pr, owner, repository, gh, config, logger)
+		}
+		return nil
 	}
 
-	prStatusMap := make(map[string]bool, len(statuses.Statuses))
-	for _, status := range statuses.Statuses {
-		logger.Debug("found PR status", zap.String("context", status.GetContext()), zap.String("state", status.GetState()))
-		prStatusMap[status.GetContext()] = status.GetState() == statusEventSuccessState
+	if config.AutoMerge.GetQueue() {
+		return queueMerge(pr, owner, repository, gh, config, logger)
 	}
 
-	prChecks, _, err := gh.Checks.ListCheckRunsForRef(context.Background(), owner, repository, commitSHA, nil)
+	contexts, _, err := evaluateContexts(pr, owner, repository, gh, logger)
 	if err != nil {
-		return errors.Wrapf(err, "failed to retrieve all check for pull request %s", issue.GetHTMLURL())
+		return err
+	}
+	if contexts != contextsSucceeded {
+		autoMergeResults.Inc("checks_pending")
+		return nil
+	}
+	if err := mergePullRequest(pr, owner, repository, gh, config, logger); err != nil {
+		return err
 	}
+	return afterMerge(pr, owner, repository, gh, config, logger)
+}
 
-	for _, check := range prChecks.CheckRuns {
+// mergePRByNumber evaluates a pull request for auto-merge outside of
+// event handling.
+func mergePRByNumber(number int, owner, repository string, gh *github.Client, config config.RepoConfig, logger *zap.Logger) error {
+	pr, _, err := gh.PullRequests.Get(context.Background(), owner, repository, number)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get pull request %d", number)
+	}
+	if pr.GetState() != "open" {
+		return nil
+	}
+	issue, _, err := gh.Issues.Get(context.Background(), owner, repository, number)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get pull request %d", number)
+	}
+	return mergePR(issue, pr, owner, repository, gh, "", config, logger)
+}
+
+type contextsResult int
+
+const (
+	contextsPending contextsResult = iota
+	contextsSucceeded
+	contextsFailed
+)
 
-		logger.Debug("found PR check", zap.String("name", *check.Name), zap.Any("conclusion", check.Conclusion), zap.String("ref", commitSHA))
-		prStatusMap[*check.Name] = check.Conclusion != nil && *check.Conclusion == checkEventSuccessConclusion
+// evaluateContexts checks the statuses and check runs of the pull request's
+// head. If the base branch requires contexts only these are taken into
+// account, otherwise all of them. The second return value names a failed
+// context.
+func evaluateContexts(pr *github.PullRequest, owner, repository string, gh *github.Client, logger *zap.Logger) (contextsResult, string, error) {
+	commitSHA := pr.Head.GetSHA()
 
+	statuses, err := getCombinedStatus(gh, owner, repository, commitSHA)
+	if err != nil {
+		return contextsPending, "", errors.Wrapf(err, "failed to get statuses of pull request %s", pr.GetHTMLURL())
+	}
+
+	prStatusMap := make(map[string]string, len(statuses.Statuses))
+	for _, status := range statuses.Statuses {
+		logger.Debug("found PR status", zap.String("context", status.GetContext()), zap.String("state", status.GetState()))
+		if status.GetContext() == mergeFreezeContext && status.GetState() == string(pendingStatus) {
+			// Left over from a freeze which ended while the bot wasn't running
+			if err := setMergeFreezeStatus(pr.GetNumber(), commitSHA, successStatus, "No merge freeze", owner, repository, gh); err != nil {
+				return contextsPending, "", err
+			}
+		}
+		if status.GetContext() == mergeQueueContext || status.GetContext() == autoMergeContext || status.GetContext() == mergeFreezeContext {
+			continue
+		}
+		prStatusMap[status.GetContext()] = status.GetState()
+	}
+
+	prChecks, err := listAllCheckRuns(gh, owner, repository, commitSHA)
+	if err != nil {
+		return contextsPending, "", errors.Wrapf(err, "failed to retrieve all check for pull request %s", pr.GetHTMLURL())
+	}
+
+	for _, check := range prChecks {
+		logger.Debug("found PR check", zap.String("name", check.GetName()), zap.Any("conclusion", check.Conclusion), zap.String("ref", commitSHA))
+		switch {
+		case check.Conclusion == nil:
+			prStatusMap[check.GetName()] = string(pendingStatus)
+		case checkPassed(check.GetConclusion()):
+			prStatusMap[check.GetName()] = statusEventSuccessState
+		default:
+			prStatusMap[check.GetName()] = check.GetConclusion()
+		}
 	}
 
 	requiredContexts, _, err := gh.Repositories.ListRequiredStatusChecksContexts(context.Background(), owner, repository, pr.Base.GetRef())
 	if err != nil {
 		if errResp, ok := err.(*github.ErrorResponse); !ok || errResp.Response.StatusCode != http.StatusNotFound {
-			return errors.Wrapf(err, "failed to get target branch (%s) protection for pull request %s", pr.Base.GetRef(), issue.GetHTMLURL())
+			return contextsPending, "", errors.Wrapf(err, "failed to get target branch (%s) protection for pull request %s", pr.Base.GetRef(), pr.GetHTMLURL())
 		}
 	}
 
 	if len(requiredContexts) == 0 {
-		for _, contextStatus := range prStatusMap {
-			if !contextStatus {
-				return nil
-			}
+		for requiredContext := range prStatusMap {
+			requiredContexts = append(requiredContexts, requiredContext)
 		}
-	} else {
-		for _, requiredContext := range requiredContexts {
-			if success, present := prStatusMap[requiredContext]; !present || !success {
-				logger.Debug("don't merging because status/check failed", zap.String("context", requiredContext), zap.Bool("present", present), zap.Bool("success", success))
-				return nil
+		sort.Strings(requiredContexts)
+	}
+
+	result := contextsSucceeded
+	for _, requiredContext := range requiredContexts {
+		state, present := prStatusMap[requiredContext]
+		switch {
+		case state == statusEventSuccessState:
+		case !present || state == string(pendingStatus):
+			logger.Debug("don't merging because status/check is pending", zap.String("context", requiredContext), zap.Bool("present", present))
+			if result == contextsSucceeded {
+				result = contextsPending
 			}
+		default:
+			logger.Debug("don't merging because status/check failed", zap.String("context", requiredContext), zap.String("state", state))
+			return contextsFailed, requiredContext, nil
 		}
 	}
+	return result, "", nil
+}
+
+func mergePullRequest(pr *github.PullRequest, owner, repository string, gh *github.Client, config config.RepoConfig, logger *zap.Logger) error {
+	merge, err := newMergeRequest(gh, owner, repository, pr, config.AutoMerge)
+	if err != nil {
+		return errors.Wrapf(err, "failed to prepare merge of pull request %s", pr.GetHTMLURL())
+	}
+	logger.Debug("Merging pull request", zap.Int("pr", pr.GetNumber()), zap.String("method", merge.method), zap.String("title", merge.title))
 
-	_, _, err = gh.PullRequests.Merge(context.Background(), owner, repository, issue.GetNumber(), "", &github.PullRequestOptions{
-		SHA: commitSHA,
+	_, _, err = gh.PullRequests.Merge(context.Background(), owner, repository, pr.GetNumber(), merge.body, &github.PullRequestOptions{
+		SHA:         pr.Head.GetSHA(),
+		MergeMethod: merge.method,
+		CommitTitle: merge.title,
 	})
 	if err != nil {
-		return errors.Wrapf(err, "failed to merge pull request %s", issue.GetHTMLURL())
+		autoMergeResults.Inc("failed")
+		return errors.Wrapf(err, "failed to merge pull request %s", pr.GetHTMLURL())
 	}
-	logger.Debug("Successfully merged " + owner + "/" + repository + ": " + strconv.Itoa(issue.GetNumber()))
+	autoMergeResults.Inc("merged")
+	logger.Debug("Successfully merged " + owner + "/" + repository + ": " + strconv.Itoa(pr.GetNumber()))
 	return nil
 }
@@ -0,0 +1,144 @@
+package webhook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+	"go.uber.org/zap"
+
+	"github.com/syndesisio/pure-bot/pkg/config"
+)
+
+func TestAutoMergeOnCompletedChecks(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		switch r.URL.Path {
+		case "/search/issues":
+			w.Write([]byte(`{"items": []}`))
+		default:
+			w.Write([]byte(`{"number": 1, "state": "open", "labels": []}`))
+		}
+	}))
+	defer server.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+
+	repo := &github.Repository{ID: github.Int64(7), Name: github.String("r"), FullName: github.String("o/r"), Owner: &github.User{Login: github.String("o")}}
+	repoConfig := config.RepoConfig{Labels: config.LabelConfig{Approved: "approved"}}
+	prOf := func(repoID int64) *github.PullRequest {
+		return &github.PullRequest{Number: github.Int(1), Base: &github.PullRequestBranch{Repo: &github.Repository{ID: github.Int64(repoID)}}}
+	}
+
+	tests := []struct {
+		name  string
+		event interface{}
+		paths []string
+	}{
+		{
+			name: "check run in progress",
+			event: &github.CheckRunEvent{Action: github.String("created"), Repo: repo, CheckRun: &github.CheckRun{
+				HeadSHA: github.String("abc"), PullRequests: []*github.PullRequest{prOf(7)},
+			}},
+		},
+		{
+			name: "failed check suite",
+			event: &github.CheckSuiteEvent{Action: github.String("completed"), Repo: repo, CheckSuite: &github.CheckSuite{
+				HeadSHA: github.String("abc"), Conclusion: github.String("failure"), PullRequests: []*github.PullRequest{prOf(7)},
+			}},
+		},
+		{
+			name: "check run with pull requests",
+			event: &github.CheckRunEvent{Action: github.String("completed"), Repo: repo, CheckRun: &github.CheckRun{
+				HeadSHA: github.String("abc"), Conclusion: github.String("success"), PullRequests: []*github.PullRequest{prOf(7), prOf(8)},
+			}},
+			paths: []string{"/repos/o/r/pulls/1", "/repos/o/r/issues/1"},
+		},
+		{
+			name: "neutral check run",
+			event: &github.CheckRunEvent{Action: github.String("completed"), Repo: repo, CheckRun: &github.CheckRun{
+				HeadSHA: github.String("abc"), Conclusion: github.String("neutral"), PullRequests: []*github.PullRequest{prOf(7)},
+			}},
+			paths: []string{"/repos/o/r/pulls/1", "/repos/o/r/issues/1"},
+		},
+		{
+			name: "skipped check suite",
+			event: &github.CheckSuiteEvent{Action: github.String("completed"), Repo: repo, CheckSuite: &github.CheckSuite{
+				HeadSHA: github.String("abc"), Conclusion: github.String("skipped"), PullRequests: []*github.PullRequest{prOf(7)},
+			}},
+			paths: []string{"/repos/o/r/pulls/1", "/repos/o/r/issues/1"},
+		},
+		{
+			name: "check suite without pull requests",
+			event: &github.CheckSuiteEvent{Action: github.String("completed"), Repo: repo, CheckSuite: &github.CheckSuite{
+				HeadSHA: github.String("abc"), Conclusion: github.String("success"),
+			}},
+			paths: []string{"/search/issues"},
+		},
+	}
+
+	for _, test := range tests {
+		paths = nil
+		if err := (&autoMerger{}).HandleEvent(test.event, client, repoConfig, zap.NewNop()); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !reflect.DeepEqual(paths, test.paths) {
+			t.Errorf("%s: expected requests %v, got %v", test.name, test.paths, paths)
+		}
+	}
+}
+
+func TestEvaluateContextsCheckConclusions(t *testing.T) {
+	tests := []struct {
+		conclusions []string
+		result      contextsResult
+		failed      string
+	}{
+		{[]string{"success", "neutral", "skipped"}, contextsSucceeded, ""},
+		{[]string{"success", "", "skipped"}, contextsPending, ""},
+		{[]string{"skipped", "failure"}, contextsFailed, "check1"},
+		{[]string{"neutral", "cancelled"}, contextsFailed, "check1"},
+	}
+
+	for _, test := range tests {
+		var runs []string
+		for i, conclusion := range test.conclusions {
+			run := `{"name": "check` + strconv.Itoa(i) + `", "status": "completed", "conclusion": "` + conclusion + `"}`
+			if conclusion == "" {
+				run = `{"name": "check` + strconv.Itoa(i) + `", "status": "in_progress"}`
+			}
+			runs = append(runs, run)
+		}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/repos/o/r/commits/abc/status":
+				w.Write([]byte(`{"state": "success", "statuses": [{"context": "ci", "state": "success"}]}`))
+			case "/repos/o/r/commits/abc/check-runs":
+				w.Write([]byte(`{"total_count": ` + strconv.Itoa(len(runs)) + `, "check_runs": [` + strings.Join(runs, ",") + `]}`))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{}`))
+			}
+		}))
+
+		client := github.NewClient(nil)
+		client.BaseURL, _ = url.Parse(server.URL + "/")
+		pr := &github.PullRequest{Number: github.Int(1), Head: &github.PullRequestBranch{SHA: github.String("abc")}, Base: &github.PullRequestBranch{Ref: github.String("master")}}
+
+		result, failed, err := evaluateContexts(pr, "o", "r", client, zap.NewNop())
+		server.Close()
+		if err != nil {
+			t.Fatalf("%v: %v", test.conclusions, err)
+		}
+		if result != test.result || failed != test.failed {
+			t.Errorf("%v: expected %v %q, got %v %q", test.conclusions, test.result, test.failed, result, failed)
+		}
+	}
+}
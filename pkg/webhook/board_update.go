@@ -27,67 +27,94 @@ type column struct {
 	isInbox             bool
 }
 
-var stateMapping = map[string]column{}
-
 var postProcessing = make(map[string]column)
 
 var zenHubApi = "https://api.zenhub.io"
 
 var regex = regexp.MustCompile("(?mi)(?:clos(?:e[sd]?|ing)|fix(?:e[sd]|ing))[^\\s]*\\s+(?:#|https://github.com/.+/issues/)(?P<issue>[0-9]+)")
 
-var doneColumn = &column{}
-
-var inboxColumn = &column{}
+var issueActions = []string{
+	"opened", "edited", "deleted", "transferred", "pinned", "unpinned", "closed", "reopened",
+	"assigned", "unassigned", "labeled", "unlabeled", "locked", "unlocked", "milestoned",
+}
 
-func (h *boardUpdate) HandleEvent(eventObject interface{}, gh *github.Client, config config.RepoConfig, logger *zap.Logger) error {
+var pullRequestActions = []string{
+	"opened", "edited", "closed", "reopened", "synchronize", "ready_for_review", "assigned", "unassigned",
+	"review_requested", "review_request_removed", "labeled", "unlabeled", "locked", "unlocked",
+}
 
-	if "<repo>" == config.Board.GithubRepo {
-		logger.Warn("Repo not configured, ignore event")
-		return nil
+// BoardEvents returns the events a board column can be mapped to, e.g.
+// "issues_opened". "issues_demilestoned" is left out as it is ignored.
+func BoardEvents() []string {
+	events := make([]string, 0, len(issueActions)+len(pullRequestActions))
+	for _, action := range issueActions {
+		events = append(events, "issues_"+action)
 	}
+	for _, action := range pullRequestActions {
+		events = append(events, "pull_request_"+action)
+	}
+	return events
+}
 
-	// initialise from config if needed
-	if len(stateMapping) == 0 {
-
-		logger.Info("Initialising state mappings ...")
-
-		for _, col := range config.Board.Columns {
-			c := column{col.Name, col.Id, col.PostMergePipeline, col.IsInbox}
+// boardColumns maps the events of a repo to the columns of its board. It is
+// built from the repo's config for every event, so that config changes take
+// effect right away.
+type boardColumns struct {
+	stateMapping map[string]column
+	doneColumn   column
+	inboxColumn  column
+}
 
-			if c.isPostMergePipeline { // the last one flagged as post process will act as doneColumn
-				doneColumn = &c
-			}
+func newBoardColumns(board config.Board, logger *zap.Logger) *boardColumns {
+	columns := &boardColumns{stateMapping: make(map[string]column)}
 
-			if c.isInbox { // the last one flagged as post process will act as inbox
-				inboxColumn = &c
-			}
+	for _, col := range board.Columns {
+		c := column{col.Name, col.Id, col.PostMergePipeline, col.IsInbox}
 
-			for _, event := range col.Events {
-				logger.Info("Mapping " + event + " to " + col.Name)
-				stateMapping[event] = c
-			}
+		if c.isPostMergePipeline { // the last one flagged as post process will act as doneColumn
+			columns.doneColumn = c
 		}
 
-		if doneColumn == nil || doneColumn.id == "" {
-			logger.Warn("Missing column definition for `Done`")
+		if c.isInbox { // the last one flagged as post process will act as inbox
+			columns.inboxColumn = c
 		}
 
-		if inboxColumn == nil || inboxColumn.id == "" {
-			logger.Warn("Missing column definition for `Inbox`")
+		for _, event := range col.Events {
+			logger.Debug("Mapping " + event + " to " + col.Name)
+			columns.stateMapping[event] = c
 		}
 	}
 
+	if columns.doneColumn.id == "" {
+		logger.Debug("Missing column definition for `Done`")
+	}
+
+	if columns.inboxColumn.id == "" {
+		logger.Debug("Missing column definition for `Inbox`")
+	}
+	return columns
+}
+
+func (h *boardUpdate) HandleEvent(eventObject interface{}, gh *github.Client, config config.RepoConfig, logger *zap.Logger) error {
+
+	if "<repo>" == config.Board.GithubRepo {
+		logger.Warn("Repo not configured, ignore event")
+		return nil
+	}
+
+	columns := newBoardColumns(config.Board, logger)
+
 	switch event := eventObject.(type) {
 	case *github.IssuesEvent:
-		return h.handleIssuesEvent(event, gh, config, logger)
+		return h.handleIssuesEvent(event, gh, config, columns, logger)
 	case *github.PullRequestEvent:
-		return h.handlePullRequestEvent(event, gh, config, logger)
+		return h.handlePullRequestEvent(event, gh, config, columns, logger)
 	default:
 		return nil
 	}
 }
 
-func (h *boardUpdate) handleIssuesEvent(event *github.IssuesEvent, gh *github.Client, config config.RepoConfig, logger *zap.Logger) error {
+func (h *boardUpdate) handleIssuesEvent(event *github.IssuesEvent, gh *github.Client, config config.RepoConfig, columns *boardColumns, logger *zap.Logger) error {
 
 	var messageType = "issues"
 
@@ -101,13 +128,7 @@ func (h *boardUpdate) handleIssuesEvent(event *github.IssuesEvent, gh *github.Cl
 	if "issues_closed" == eventKey {
 
 		// ignore labels present?
-		labels, _, err := gh.Issues.ListLabelsByIssue(
-			context.Background(),
-			event.Repo.Owner.GetLogin(),
-			event.Repo.GetName(),
-			*event.Issue.Number,
-			nil,
-		)
+		labels, err := listAllLabels(gh, event.Repo.Owner.GetLogin(), event.Repo.GetName(), *event.Issue.Number)
 		if err != nil {
 			return errors.Wrapf(err, "failed to list labels for Issue %s", event.Issue.GetHTMLURL())
 		}
@@ -128,7 +149,7 @@ func (h *boardUpdate) handleIssuesEvent(event *github.IssuesEvent, gh *github.Cl
 
 	} else if "issues_reopened" == eventKey && event.GetIssue().GetLocked() {
 		// move
-		err := moveIssueOnBoard(config, number, *doneColumn, logger)
+		err := moveIssueOnBoard(config, number, columns.doneColumn, logger)
 
 		if err != nil {
 			logger.Error("Post processing failed: Cannot move issue")
@@ -140,7 +161,7 @@ func (h *boardUpdate) handleIssuesEvent(event *github.IssuesEvent, gh *github.Cl
 			}
 
 			// update progress/* label
-			changeProgressLabel(gh, event.Repo, *event.Issue, doneColumn.name)
+			changeProgressLabel(gh, event.Repo, *event.Issue, columns.doneColumn.name)
 
 			response, err := gh.Issues.Unlock(context.Background(), event.Repo.Owner.GetLogin(), event.Repo.GetName(), *event.Issue.Number)
 
@@ -152,7 +173,7 @@ func (h *boardUpdate) handleIssuesEvent(event *github.IssuesEvent, gh *github.Cl
 	} else if "issues_opened" == eventKey && event.GetIssue().GetMilestone() != nil {
 
 		// check if milestoned event is configured
-		_, ok := stateMapping["issues_milestoned"]
+		_, ok := columns.stateMapping["issues_milestoned"]
 		if ok {
 			logger.Debug("Issue carries milestone, ignore event")
 			return nil
@@ -164,7 +185,7 @@ func (h *boardUpdate) handleIssuesEvent(event *github.IssuesEvent, gh *github.Cl
 			logger.Error("Error retrieving issue column", zap.Error(err))
 		}
 
-		if col != inboxColumn.name {
+		if col != columns.inboxColumn.name {
 			logger.Debug("Milestone event for issue outside the Inbox, not moving  #" + number)
 			return nil
 		}
@@ -181,7 +202,7 @@ func (h *boardUpdate) handleIssuesEvent(event *github.IssuesEvent, gh *github.Cl
 	}
 
 	// regular processing
-	col, ok := stateMapping[eventKey]
+	col, ok := columns.stateMapping[eventKey]
 	if ok {
 		err := moveIssueOnBoard(config, number, col, logger)
 
@@ -240,7 +261,7 @@ func postProcess(event *github.IssuesEvent, gh *github.Client, config config.Rep
 
 }
 
-func (h *boardUpdate) handlePullRequestEvent(event *github.PullRequestEvent, gh *github.Client, config config.RepoConfig, logger *zap.Logger) error {
+func (h *boardUpdate) handlePullRequestEvent(event *github.PullRequestEvent, gh *github.Client, config config.RepoConfig, columns *boardColumns, logger *zap.Logger) error {
 
 	var messageType = "pull_request"
 	eventKey := messageType + "_" + *event.Action
@@ -248,8 +269,7 @@ func (h *boardUpdate) handlePullRequestEvent(event *github.PullRequestEvent, gh
 	prNumber := strconv.Itoa(*event.PullRequest.Number)
 	logger.Info("<< Event " + eventKey + " on PR " + prNumber + " >>")
 
-	commits, _, err := gh.PullRequests.ListCommits(context.Background(), event.Repo.Owner.GetLogin(), event.Repo.GetName(),
-		*event.PullRequest.Number, nil)
+	commits, err := listAllCommits(gh, event.Repo.Owner.GetLogin(), event.Repo.GetName(), *event.PullRequest.Number)
 
 	if err != nil {
 		logger.Error("Failed to retrieve commits")
@@ -286,16 +306,16 @@ func (h *boardUpdate) handlePullRequestEvent(event *github.PullRequestEvent, gh
 
 		// schedule post processing if needed
 		if "pull_request_opened" == eventKey &&
-			doneColumn.isPostMergePipeline {
+			columns.doneColumn.isPostMergePipeline {
 
 			// schedule completion with next event
 			logger.Debug("Schedule post processing for issue: " + number)
-			postProcessing[number] = *doneColumn
+			postProcessing[number] = columns.doneColumn
 			continue
 		}
 
 		// regular PR processing
-		col, ok := stateMapping[eventKey]
+		col, ok := columns.stateMapping[eventKey]
 		if ok {
 			err := moveIssueOnBoard(config, number, col, logger)
 
@@ -330,6 +350,11 @@ func extractIssueNumbers(issues *[]string, commitMessage string) {
 
 func moveIssueOnBoard(config config.RepoConfig, issue string, col column, logger *zap.Logger) error {
 
+	if config.IsDryRun() {
+		logger.Info("Dry run: not moving #" + issue + " to `" + col.name + "`")
+		return nil
+	}
+
 	logger.Info("Moving #" + issue + " to `" + col.name + "`")
 
 	url := zenHubApi + "/p1/repositories/" + config.Board.GithubRepo + "/issues/" + issue + "/moves"
@@ -338,12 +363,14 @@ func moveIssueOnBoard(config config.RepoConfig, issue string, col column, logger
 		SetHeader("Content-Type", "application/json").
 		SetBody(`{"pipeline_id":"` + col.id + `", "position": "top"}`).
 		Post(url)
-
-	logger.Debug("Zenhub call status: HTTP " + strconv.Itoa(response.StatusCode()) + " from " + url)
-
+	// resty doesn't necessarily return a response with an error
 	if err != nil {
+		zenhubRequests.Inc("move_issue", zenhubOutcome(0, err))
 		return err
 	}
+	zenhubRequests.Inc("move_issue", zenhubOutcome(response.StatusCode(), nil))
+
+	logger.Debug("Zenhub call status: HTTP " + strconv.Itoa(response.StatusCode()) + " from " + url)
 
 	if response.StatusCode() > 400 {
 		logger.Warn("Zenhub call unsuccessful: HTTP " + strconv.Itoa(response.StatusCode()) + " from " + url)
@@ -359,10 +386,11 @@ func getIssueColumn(config config.RepoConfig, issue string, logger *zap.Logger)
 		SetHeader("X-Authentication-Token", config.Board.ZenhubToken).
 		SetHeader("Content-Type", "application/json").
 		Get(url)
-
 	if err != nil {
+		zenhubRequests.Inc("get_issue", zenhubOutcome(0, err))
 		return err, ""
 	}
+	zenhubRequests.Inc("get_issue", zenhubOutcome(response.StatusCode(), nil))
 
 	if response.StatusCode() > 400 {
 		logger.Warn("Zenhub call unsuccessful: HTTP " + strconv.Itoa(response.StatusCode()) + " from " + url)
@@ -0,0 +1,75 @@
+// Copyright © 2017 Syndesis Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/syndesisio/pure-bot/pkg/config"
+	"github.com/syndesisio/pure-bot/pkg/github/apps"
+)
+
+// appBot remembers the login the GitHub App comments as, to recognize its
+// own comments. It is looked up once per app ID.
+var appBot = struct {
+	sync.Mutex
+	appID int64
+	login string
+}{}
+
+// identifyBot looks up the login of the GitHub App's bot user, which is its
+// slug followed by "[bot]", unless it is known already.
+func identifyBot(cfg config.GitHubAppConfig) error {
+	appBot.Lock()
+	defer appBot.Unlock()
+	if appBot.login != "" && appBot.appID == cfg.AppID {
+		return nil
+	}
+
+	key, err := ioutil.ReadFile(cfg.PrivateKeyFile)
+	if err != nil {
+		return errors.Wrap(err, "failed to read private key file")
+	}
+	gh, err := apps.AppClient(cfg.AppID, key, cfg.BaseURL)
+	if err != nil {
+		return errors.Wrap(err, "failed to create GitHub client")
+	}
+	req, err := gh.NewRequest(http.MethodGet, "app", nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to create app request")
+	}
+	// The slug is missing in the GitHub client
+	app := new(struct {
+		Slug string `json:"slug"`
+	})
+	if _, err := gh.Do(context.Background(), req, app); err != nil {
+		return errors.Wrap(err, "failed to get GitHub App")
+	}
+	appBot.appID, appBot.login = cfg.AppID, app.Slug+"[bot]"
+	return nil
+}
+
+// botLogin returns the login of the GitHub App's bot user, or "" if it
+// wasn't identified yet.
+func botLogin() string {
+	appBot.Lock()
+	defer appBot.Unlock()
+	return appBot.login
+}
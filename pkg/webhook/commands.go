@@ -0,0 +1,256 @@
+// Copyright © 2017 Syndesis Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	"github.com/syndesisio/pure-bot/pkg/config"
+)
+
+// Reactions on a comment telling whether its commands succeeded
+const (
+	commandSucceededReaction = "+1"
+	commandFailedReaction    = "confused"
+)
+
+// Permission levels of the collaborators API, from lowest to highest. Triage
+// is only reported as role, its permission is read.
+const (
+	readPermission   = "read"
+	triagePermission = "triage"
+	writePermission  = "write"
+	adminPermission  = "admin"
+)
+
+var permissionRanks = map[string]int{readPermission: 1, triagePermission: 2, writePermission: 3, adminPermission: 4}
+
+// slashCommand is a ChatOps command like `/label bug`, given on a line of
+// its own in a new comment on an issue or pull request.
+type slashCommand struct {
+	// permission is the lowest permission on the repository the commenter
+	// needs
+	permission string
+	// pullRequestOnly commands are refused on issues
+	pullRequestOnly bool
+	run             func(c *commandContext, args []string) error
+}
+
+// slashCommands are all commands by name, without the slash. Other commands
+// are ignored, as they might be meant for another bot.
+var slashCommands map[string]slashCommand
+
+func init() {
+	slashCommands = map[string]slashCommand{
+		"approve":  {writePermission, true, approveCommand},
+		"lgtm":     {writePermission, true, lgtmCommand},
+		"hold":     {triagePermission, false, holdCommand},
+		"unhold":   {writePermission, false, unholdCommand},
+		"label":    {triagePermission, false, labelCommand},
+		"unlabel":  {triagePermission, false, unlabelCommand},
+		"assign":   {triagePermission, false, assignCommand},
+		"cc":       {readPermission, true, ccCommand},
+		"retest":   {writePermission, true, retestCommand},
+		"merge":    {writePermission, true, mergeCommand},
+		"freeze":   {writePermission, false, freezeCommand},
+		"unfreeze": {writePermission, false, unfreezeCommand},
+	}
+}
+
+// commandContext is what a command works on: the comment it was given in
+// and the issue or pull request commented on.
+type commandContext struct {
+	event  *github.IssueCommentEvent
+	gh     *github.Client
+	config config.RepoConfig
+	logger *zap.Logger
+
+	owner, repo string
+	number      int
+	// user is the login of the commenter
+	user string
+	// permission is the commenter's permission on the repository
+	permission string
+	// replies are posted as a single comment after all commands ran
+	replies []string
+}
+
+func (c *commandContext) reply(message string) {
+	c.replies = append(c.replies, message)
+}
+
+func (c *commandContext) hasPermission(permission string) bool {
+	return permissionRanks[c.permission] >= permissionRanks[permission]
+}
+
+func (c *commandContext) isPullRequest() bool {
+	return c.event.Issue.IsPullRequest()
+}
+
+// commandError is a mistake of the commenter, e.g. a missing argument. It is
+// only reported in the reply, not as a failure of the handler.
+type commandError string
+
+func (e commandError) Error() string {
+	return string(e)
+}
+
+type commandLine struct {
+	name string
+	args []string
+	// text is the line as given, to quote it in replies
+	text string
+}
+
+// parseCommands returns the commands of a comment. Quoted lines and lines
+// in code blocks are skipped.
+func parseCommands(body string) []commandLine {
+	var commands []commandLine
+	inCode := false
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "```") {
+			inCode = !inCode
+			continue
+		}
+		if inCode || !strings.HasPrefix(line, "/") {
+			continue
+		}
+		fields := strings.Fields(line[1:])
+		if len(fields) == 0 {
+			continue
+		}
+		commands = append(commands, commandLine{name: strings.ToLower(fields[0]), args: fields[1:], text: line})
+	}
+	return commands
+}
+
+type commandHandler struct{}
+
+func (h *commandHandler) EventTypesHandled() []string {
+	return []string{"issue_comment"}
+}
+
+func (h *commandHandler) HandleEvent(eventObject interface{}, gh *github.Client, config config.RepoConfig, logger *zap.Logger) error {
+	event, ok := eventObject.(*github.IssueCommentEvent)
+	if !ok {
+		return errors.New("wrong event eventObject type")
+	}
+	// Bots, including this one, don't give commands
+	if event.GetAction() != "created" || event.Comment.User.GetType() == "Bot" {
+		return nil
+	}
+
+	var commands []commandLine
+	for _, line := range parseCommands(event.Comment.GetBody()) {
+		if _, known := slashCommands[line.name]; known {
+			commands = append(commands, line)
+		}
+	}
+	if len(commands) == 0 {
+		return nil
+	}
+
+	c := &commandContext{
+		event:  event,
+		gh:     gh,
+		config: config,
+		owner:  event.Repo.Owner.GetLogin(),
+		repo:   event.Repo.GetName(),
+		number: event.Issue.GetNumber(),
+		user:   event.Comment.User.GetLogin(),
+	}
+	c.logger = logger.With(zap.String("repo", event.Repo.GetFullName()), zap.Int("issue", c.number), zap.String("user", c.user))
+
+	permission, err := getPermission(gh, c.owner, c.repo, c.user)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get permission of %s", c.user)
+	}
+	c.permission = permission
+
+	var handlerErr error
+	succeeded := true
+	for _, line := range commands {
+		cmd := slashCommands[line.name]
+		switch {
+		case !c.hasPermission(cmd.permission):
+			err = commandError("needs " + cmd.permission + " permission")
+		case cmd.pullRequestOnly && !c.isPullRequest():
+			err = commandError("only works on pull requests")
+		default:
+			c.logger.Info("Running command", zap.String("command", line.text))
+			err = cmd.run(c, line.args)
+		}
+		if err == nil {
+			continue
+		}
+
+		succeeded = false
+		if _, mistake := err.(commandError); mistake {
+			c.reply("`" + line.text + "` " + err.Error() + ".")
+			continue
+		}
+		c.reply("`" + line.text + "` failed.")
+		handlerErr = multierr.Append(handlerErr, errors.Wrapf(err, "command %s failed", line.text))
+	}
+
+	reaction := commandSucceededReaction
+	if !succeeded {
+		reaction = commandFailedReaction
+	}
+	if _, _, err := gh.Reactions.CreateIssueCommentReaction(context.Background(), c.owner, c.repo, event.Comment.GetID(), reaction); err != nil {
+		handlerErr = multierr.Append(handlerErr, errors.Wrapf(err, "failed to react to %s", event.Comment.GetHTMLURL()))
+	}
+	if len(c.replies) > 0 {
+		message := "@" + c.user + " " + strings.Join(c.replies, "\n")
+		if _, _, err := gh.Issues.CreateComment(context.Background(), c.owner, c.repo, c.number, &github.IssueComment{Body: &message}); err != nil {
+			handlerErr = multierr.Append(handlerErr, errors.Wrapf(err, "failed to comment on %s", event.Issue.GetHTMLURL()))
+		}
+	}
+	return handlerErr
+}
+
+// permissionLevel is the permission of a user on a repository. The role is
+// missing in the GitHub client.
+type permissionLevel struct {
+	Permission string `json:"permission"`
+	RoleName   string `json:"role_name"`
+}
+
+// getPermission returns the permission of the user on the repository, which
+// is triage for users with the triage role.
+func getPermission(gh *github.Client, owner, repository, user string) (string, error) {
+	u := fmt.Sprintf("repos/%s/%s/collaborators/%s/permission", owner, repository, user)
+	req, err := gh.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create permission request")
+	}
+	level := new(permissionLevel)
+	if _, err := gh.Do(context.Background(), req, level); err != nil {
+		return "", err
+	}
+	if level.RoleName == triagePermission {
+		return triagePermission, nil
+	}
+	return level.Permission, nil
+}
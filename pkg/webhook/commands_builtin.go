@@ -0,0 +1,248 @@
+// Copyright © 2017 Syndesis Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+)
+
+// Re-requesting check suites is still a preview API
+const checksPreview = "application/vnd.github.antiope-preview+json"
+
+// cancelArg undoes /approve, /lgtm and /hold
+const cancelArg = "cancel"
+
+// approveCommand adds the approved label, which merges the pull request
+// once nothing blocks it. `/approve cancel` removes it again.
+func approveCommand(c *commandContext, args []string) error {
+	label := c.config.Labels.Approved
+	if label == "" {
+		return commandError("needs `labels.approved` to be configured")
+	}
+	if len(args) > 0 && args[0] == cancelArg {
+		return c.removeLabels(label)
+	}
+	return c.addLabels(label)
+}
+
+// lgtmCommand is /approve, except that authors can't LGTM their own pull
+// request.
+func lgtmCommand(c *commandContext, args []string) error {
+	if strings.EqualFold(c.event.Issue.User.GetLogin(), c.user) && (len(args) == 0 || args[0] != cancelArg) {
+		return commandError("can't be given by the author of the pull request")
+	}
+	return approveCommand(c, args)
+}
+
+// holdCommand adds the first WIP label, which blocks merging until
+// `/unhold` removes all WIP labels again.
+func holdCommand(c *commandContext, args []string) error {
+	if len(c.config.Labels.Wip) == 0 {
+		return commandError("needs `labels.wip` to be configured")
+	}
+	if len(args) > 0 && args[0] == cancelArg {
+		if !c.hasPermission(writePermission) {
+			return commandError("cancel needs write permission")
+		}
+		return unholdCommand(c, nil)
+	}
+	return c.addLabels(c.config.Labels.Wip[0])
+}
+
+func unholdCommand(c *commandContext, _ []string) error {
+	if len(c.config.Labels.Wip) == 0 {
+		return commandError("needs `labels.wip` to be configured")
+	}
+	var present []string
+	for _, label := range c.config.Labels.Wip {
+		if containsLabel(c.event.Issue.Labels, label) {
+			present = append(present, label)
+		}
+	}
+	return c.removeLabels(present...)
+}
+
+// labelCommand adds existing labels, separated by commas if they contain
+// spaces, e.g. `/label kind/bug` or `/label help wanted, good first issue`.
+// The labels managed by other commands can't be added.
+func labelCommand(c *commandContext, args []string) error {
+	labels, err := c.labelArgs(args)
+	if err != nil {
+		return err
+	}
+	for _, label := range labels {
+		if _, _, err := c.gh.Issues.GetLabel(context.Background(), c.owner, c.repo, label); err != nil {
+			if errResp, ok := err.(*github.ErrorResponse); ok && errResp.Response.StatusCode == http.StatusNotFound {
+				return commandError("failed, there is no label " + label)
+			}
+			return errors.Wrapf(err, "failed to get label %s", label)
+		}
+	}
+	return c.addLabels(labels...)
+}
+
+func unlabelCommand(c *commandContext, args []string) error {
+	labels, err := c.labelArgs(args)
+	if err != nil {
+		return err
+	}
+	return c.removeLabels(labels...)
+}
+
+func (c *commandContext) labelArgs(args []string) ([]string, error) {
+	labels := args
+	if text := strings.Join(args, " "); strings.Contains(text, ",") {
+		labels = nil
+		for _, label := range strings.Split(text, ",") {
+			if label = strings.TrimSpace(label); label != "" {
+				labels = append(labels, label)
+			}
+		}
+	}
+	if len(labels) == 0 {
+		return nil, commandError("needs at least one label")
+	}
+	for _, label := range labels {
+		if strings.EqualFold(label, c.config.Labels.Approved) {
+			return nil, commandError("can't change " + label + ", use `/approve` instead")
+		}
+		for _, wip := range c.config.Labels.Wip {
+			if strings.EqualFold(label, wip) {
+				return nil, commandError("can't change " + label + ", use `/hold` instead")
+			}
+		}
+	}
+	return labels, nil
+}
+
+// assignCommand assigns the given users, or the commenter if none are given.
+func assignCommand(c *commandContext, args []string) error {
+	users := usersArgs(args)
+	if len(users) == 0 {
+		users = []string{c.user}
+	}
+	if _, _, err := c.gh.Issues.AddAssignees(context.Background(), c.owner, c.repo, c.number, users); err != nil {
+		return errors.Wrapf(err, "failed to assign %s", strings.Join(users, ", "))
+	}
+	return nil
+}
+
+// ccCommand requests reviews from the given users.
+func ccCommand(c *commandContext, args []string) error {
+	users := usersArgs(args)
+	if len(users) == 0 {
+		return commandError("needs at least one user")
+	}
+	if _, _, err := c.gh.PullRequests.RequestReviewers(context.Background(), c.owner, c.repo, c.number, github.ReviewersRequest{Reviewers: users}); err != nil {
+		if errResp, ok := err.(*github.ErrorResponse); ok && errResp.Response.StatusCode == http.StatusUnprocessableEntity {
+			return commandError("failed, only collaborators can review")
+		}
+		return errors.Wrapf(err, "failed to request reviews from %s", strings.Join(users, ", "))
+	}
+	return nil
+}
+
+func usersArgs(args []string) []string {
+	var users []string
+	for _, arg := range args {
+		for _, user := range strings.Split(arg, ",") {
+			if user = strings.TrimPrefix(strings.TrimSpace(user), "@"); user != "" {
+				users = append(users, user)
+			}
+		}
+	}
+	return users
+}
+
+// retestCommand re-runs the failed check suites of the pull request's head.
+// Statuses can't be re-run through GitHub.
+func retestCommand(c *commandContext, _ []string) error {
+	pr, _, err := c.gh.PullRequests.Get(context.Background(), c.owner, c.repo, c.number)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get pull request %d", c.number)
+	}
+	suites, err := listAllCheckSuites(c.gh, c.owner, c.repo, pr.Head.GetSHA())
+	if err != nil {
+		return errors.Wrapf(err, "failed to list check suites of %s", pr.GetHTMLURL())
+	}
+
+	var multiErr error
+	rerun := 0
+	for _, suite := range suites {
+		switch suite.GetConclusion() {
+		case "failure", "timed_out", "cancelled", "action_required":
+		default:
+			continue
+		}
+		u := fmt.Sprintf("repos/%s/%s/check-suites/%d/rerequest", c.owner, c.repo, suite.GetID())
+		req, err := c.gh.NewRequest(http.MethodPost, u, nil)
+		if err != nil {
+			return errors.Wrap(err, "failed to create rerequest check suite request")
+		}
+		req.Header.Set("Accept", checksPreview)
+		if _, err := c.gh.Do(context.Background(), req, nil); err != nil {
+			multiErr = multierr.Append(multiErr, errors.Wrapf(err, "failed to re-run check suite of %s", suite.GetApp().GetName()))
+			continue
+		}
+		c.logger.Debug("Re-running check suite", zap.String("app", suite.GetApp().GetName()))
+		rerun++
+	}
+	if multiErr == nil && rerun == 0 {
+		return commandError("found no failed checks to re-run")
+	}
+	return multiErr
+}
+
+// mergeCommand approves the pull request and merges it right away if nothing
+// blocks it.
+func mergeCommand(c *commandContext, _ []string) error {
+	label := c.config.Labels.Approved
+	if label == "" {
+		return commandError("needs `labels.approved` to be configured")
+	}
+	if !containsLabel(c.event.Issue.Labels, label) {
+		if err := c.addLabels(label); err != nil {
+			return err
+		}
+	}
+	return mergePRByNumber(c.number, c.owner, c.repo, c.gh, c.config, c.logger)
+}
+
+func (c *commandContext) addLabels(labels ...string) error {
+	if _, _, err := c.gh.Issues.AddLabelsToIssue(context.Background(), c.owner, c.repo, c.number, labels); err != nil {
+		return errors.Wrapf(err, "failed to add labels %s", strings.Join(labels, ", "))
+	}
+	return nil
+}
+
+func (c *commandContext) removeLabels(labels ...string) error {
+	for _, label := range labels {
+		if _, err := c.gh.Issues.RemoveLabelForIssue(context.Background(), c.owner, c.repo, c.number, label); err != nil {
+			if errResp, ok := err.(*github.ErrorResponse); ok && errResp.Response.StatusCode == http.StatusNotFound {
+				continue
+			}
+			return errors.Wrapf(err, "failed to remove label %s", label)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-github/github"
+	"go.uber.org/zap"
+
+	"github.com/syndesisio/pure-bot/pkg/config"
+)
+
+func TestParseCommands(t *testing.T) {
+	body := "Looks good\r\n/LGTM\n> /hold\n```\n/merge\n```\n  /label help wanted, good first issue\n/"
+	commands := parseCommands(body)
+
+	var got [][]string
+	for _, c := range commands {
+		got = append(got, append([]string{c.name}, c.args...))
+	}
+	expected := [][]string{{"lgtm"}, {"label", "help", "wanted,", "good", "first", "issue"}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestCommandHandler(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch {
+		case r.URL.Path == "/repos/o/r/collaborators/triager/permission":
+			w.Write([]byte(`{"permission": "read", "role_name": "triage"}`))
+		case r.URL.Path == "/repos/o/r/labels/bug":
+			w.Write([]byte(`{"name": "bug"}`))
+		case r.URL.Path == "/repos/o/r/labels/nope":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{}`))
+		case r.URL.Path == "/repos/o/r/issues/1/labels":
+			requests = append(requests, r.Method+" "+r.URL.Path+" "+string(body))
+			w.Write([]byte(`[]`))
+		default:
+			requests = append(requests, r.Method+" "+r.URL.Path+" "+string(body))
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	event := &github.IssueCommentEvent{
+		Action: github.String("created"),
+		Issue:  &github.Issue{Number: github.Int(1), PullRequestLinks: &github.PullRequestLinks{}},
+		Comment: &github.IssueComment{
+			ID:   github.Int64(7),
+			Body: github.String("/label bug\n/approve\n/label nope\n/unknown"),
+			User: &github.User{Login: github.String("triager")},
+		},
+		Repo: &github.Repository{Name: github.String("r"), FullName: github.String("o/r"), Owner: &github.User{Login: github.String("o")}},
+	}
+	repoConfig := config.RepoConfig{Labels: config.LabelConfig{Approved: "approved"}}
+
+	if err := (&commandHandler{}).HandleEvent(event, client, repoConfig, zap.NewNop()); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`POST /repos/o/r/issues/1/labels ["bug"]` + "\n",
+		`POST /repos/o/r/issues/comments/7/reactions {"content":"confused"}` + "\n",
+		`POST /repos/o/r/issues/1/comments {"body":"@triager ` + "`/approve` needs write permission.\\n`/label nope` failed, there is no label nope.\"}\n",
+	}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("expected %q, got %q", expected, requests)
+	}
+}
+
+func TestCommandPermissions(t *testing.T) {
+	for _, test := range []struct {
+		name, permission, body, expected string
+	}{
+		{"read", `{"permission": "read"}`, "/hold\n/label bug\n/unlabel bug\n/assign", "@dev `/hold` needs triage permission.\n`/label bug` needs triage permission.\n" +
+			"`/unlabel bug` needs triage permission.\n`/assign` needs triage permission."},
+		{"triage", `{"permission": "read", "role_name": "triage"}`, "/hold cancel\n/unhold", "@dev `/hold cancel` cancel needs write permission.\n`/unhold` needs write permission."},
+		{"write", `{"permission": "write", "role_name": "maintain"}`, "/hold cancel", ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var comments []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				switch {
+				case r.URL.Path == "/repos/o/r/collaborators/dev/permission":
+					w.Write([]byte(test.permission))
+				case r.URL.Path == "/repos/o/r/issues/1/comments":
+					var comment github.IssueComment
+					json.Unmarshal(body, &comment)
+					comments = append(comments, comment.GetBody())
+					w.Write([]byte(`{}`))
+				default:
+					w.Write([]byte(`{}`))
+				}
+			}))
+			defer server.Close()
+
+			client := github.NewClient(nil)
+			client.BaseURL, _ = url.Parse(server.URL + "/")
+			event := &github.IssueCommentEvent{
+				Action: github.String("created"),
+				Issue:  &github.Issue{Number: github.Int(1), Labels: []github.Label{{Name: github.String("wip")}}},
+				Comment: &github.IssueComment{
+					ID:   github.Int64(7),
+					Body: github.String(test.body),
+					User: &github.User{Login: github.String("dev")},
+				},
+				Repo: &github.Repository{Name: github.String("r"), FullName: github.String("o/r"), Owner: &github.User{Login: github.String("o")}},
+			}
+			repoConfig := config.RepoConfig{Labels: config.LabelConfig{Wip: []string{"wip"}}}
+
+			if err := (&commandHandler{}).HandleEvent(event, client, repoConfig, zap.NewNop()); err != nil {
+				t.Fatal(err)
+			}
+			var expected []string
+			if test.expected != "" {
+				expected = []string{test.expected}
+			}
+			if !reflect.DeepEqual(comments, expected) {
+				t.Errorf("expected %q, got %q", expected, comments)
+			}
+		})
+	}
+}
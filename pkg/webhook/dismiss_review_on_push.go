@@ -16,6 +16,10 @@ package webhook
 
 import (
 	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/google/go-github/github"
@@ -25,8 +29,17 @@ import (
 	"go.uber.org/zap"
 )
 
-var dismissMessage = "Code changed after review"
+// The compare API lists at most this many files, so larger diffs can't be
+// compared file by file
+const maxComparedFiles = 300
 
+// hunkHeader matches the line numbers of a hunk, which change when the base
+// changes elsewhere in the file
+var hunkHeader = regexp.MustCompile(`(?m)^@@ [^@]* @@`)
+
+// dismissReview dismisses the approvals of a pull request when the changes
+// they approved were altered by a push. Pushes which only rebase the changes,
+// only touch exempt paths or only add commits of exempt authors keep them.
 type dismissReview struct{}
 
 func (h *dismissReview) EventTypesHandled() []string {
@@ -43,15 +56,41 @@ func (h *dismissReview) HandleEvent(eventObject interface{}, gh *github.Client,
 		return nil
 	}
 
-	reviews, _, err := gh.PullRequests.ListReviews(context.Background(), event.Repo.Owner.GetLogin(), event.Repo.GetName(), event.PullRequest.GetNumber(), &github.ListOptions{})
+	owner, repository, number := event.Repo.Owner.GetLogin(), event.Repo.GetName(), event.PullRequest.GetNumber()
+	base, head := event.PullRequest.Base.GetRef(), event.PullRequest.Head.GetSHA()
+	logger = logger.With(zap.String("repo", event.Repo.GetFullName()), zap.Int("pr", number))
+
+	reviews, err := listAllReviews(gh, owner, repository, number)
 	if err != nil {
-		return errors.Wrap(err, "failed to get pull request")
+		return errors.Wrapf(err, "failed to list reviews of %s", event.PullRequest.GetHTMLURL())
 	}
 
+	c := &diffComparer{gh: gh, owner: owner, repository: repository, base: base, config: config.DismissReviews,
+		diffs: make(map[string]map[string]string), commits: make(map[string][]github.RepositoryCommit)}
+	// Approvals of the same head are kept or dismissed together
+	stale := make(map[string]bool)
+
 	var multiErr error
 	for _, review := range reviews {
-		_, _, err = gh.PullRequests.DismissReview(context.Background(), event.Repo.Owner.GetLogin(), event.Repo.GetName(), int(event.PullRequest.GetID()), review.GetID(), &github.PullRequestReviewDismissalRequest{
-			Message: &dismissMessage,
+		approved := review.GetCommitID()
+		if review.GetState() != "APPROVED" || approved == head {
+			continue
+		}
+		dismiss, decided := stale[approved]
+		if !decided {
+			if dismiss, err = c.changed(approved, head, logger); err != nil {
+				return err
+			}
+			stale[approved] = dismiss
+		}
+		if !dismiss {
+			continue
+		}
+
+		message := fmt.Sprintf("Code changed after review, from %s to %s", commitLink(event.Repo, approved), commitLink(event.Repo, head))
+		logger.Info("Dismissing approval", zap.String("reviewer", review.User.GetLogin()), zap.String("approved", approved))
+		_, _, err = gh.PullRequests.DismissReview(context.Background(), owner, repository, number, review.GetID(), &github.PullRequestReviewDismissalRequest{
+			Message: &message,
 		})
 		multiErr = multierr.Combine(multiErr, err)
 	}
@@ -62,3 +101,131 @@ func (h *dismissReview) HandleEvent(eventObject interface{}, gh *github.Client,
 
 	return nil
 }
+
+func commitLink(repo *github.Repository, sha string) string {
+	short := sha
+	if len(short) > 7 {
+		short = short[:7]
+	}
+	return fmt.Sprintf("[%s](%s/commit/%s)", short, repo.GetHTMLURL(), sha)
+}
+
+// diffComparer compares the changes of the heads of a pull request against
+// its base, caching the diff of every head.
+type diffComparer struct {
+	gh                *github.Client
+	owner, repository string
+	base              string
+	config            config.DismissReviewsConfig
+	// diffs holds the normalized patches of a head by file name
+	diffs map[string]map[string]string
+	// commits holds the commits of a head which aren't on the base
+	commits map[string][]github.RepositoryCommit
+}
+
+// changed tells whether the changes of the pull request at head differ from
+// the ones at approved in a file which isn't exempt, and whether commits by
+// authors who aren't exempt were added. Added commits are the ones of head
+// which aren't on the base and weren't approved, so that commits brought in
+// by a rebase don't count and rebased commits do.
+func (c *diffComparer) changed(approved, head string, logger *zap.Logger) (bool, error) {
+	before, err := c.diff(approved)
+	if err != nil {
+		return false, err
+	}
+	after, err := c.diff(head)
+	if err != nil {
+		return false, err
+	}
+	if before == nil || after == nil {
+		logger.Debug("Diff too large to compare", zap.String("approved", approved))
+		return true, nil
+	}
+
+	var paths []string
+	for file, patch := range after {
+		if before[file] != patch && !c.exemptPath(file) {
+			paths = append(paths, file)
+		}
+	}
+	for file := range before {
+		if _, ok := after[file]; !ok && !c.exemptPath(file) {
+			paths = append(paths, file)
+		}
+	}
+	if len(paths) == 0 {
+		logger.Debug("Diff unchanged apart from exempt paths", zap.String("approved", approved))
+		return false, nil
+	}
+	if len(c.config.ExemptAuthors) == 0 {
+		return true, nil
+	}
+
+	approvedCommits := make(map[string]bool)
+	for _, commit := range c.commits[approved] {
+		approvedCommits[commit.GetSHA()] = true
+	}
+	for _, commit := range c.commits[head] {
+		if approvedCommits[commit.GetSHA()] {
+			continue
+		}
+		if !containsFold(c.config.ExemptAuthors, commit.GetAuthor().GetLogin()) {
+			sort.Strings(paths)
+			logger.Debug("Diff changed", zap.String("approved", approved), zap.Strings("paths", paths))
+			return true, nil
+		}
+	}
+	logger.Debug("Only commits of exempt authors added", zap.String("approved", approved))
+	return false, nil
+}
+
+// diff returns the patches of the changes at head by file name, without the
+// line numbers of the hunks, or nil if there are too many files to compare.
+// The commits of head which aren't on the base are cached as well.
+func (c *diffComparer) diff(head string) (map[string]string, error) {
+	if diff, ok := c.diffs[head]; ok {
+		return diff, nil
+	}
+	comparison, _, err := c.gh.Repositories.CompareCommits(context.Background(), c.owner, c.repository, c.base, head)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to compare %s with %s", c.base, head)
+	}
+
+	var diff map[string]string
+	if len(comparison.Files) < maxComparedFiles {
+		diff = make(map[string]string)
+		for _, file := range comparison.Files {
+			patch := hunkHeader.ReplaceAllString(file.GetPatch(), "@@")
+			// Binary and large files come without a patch
+			if patch == "" {
+				patch = file.GetSHA()
+			}
+			diff[file.GetFilename()] = file.GetStatus() + "\n" + patch
+		}
+	}
+	c.diffs[head] = diff
+	c.commits[head] = comparison.Commits
+	return diff, nil
+}
+
+// exemptPath matches the file against the exempt paths. Patterns without a
+// slash match the file name in any directory, patterns ending with a slash
+// match all files in the directory.
+func (c *diffComparer) exemptPath(file string) bool {
+	for _, pattern := range c.config.ExemptPaths {
+		if strings.HasSuffix(pattern, "/") {
+			if strings.HasPrefix(file, strings.TrimPrefix(pattern, "/")) {
+				return true
+			}
+			continue
+		}
+		name := file
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(file)
+		}
+		if matched, _ := path.Match(strings.TrimPrefix(pattern, "/"), name); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package webhook
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-github/github"
+	"go.uber.org/zap"
+
+	"github.com/syndesisio/pure-bot/pkg/config"
+)
+
+func TestDismissReview(t *testing.T) {
+	var dismissed []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/repos/o/r/pulls/2/reviews":
+			w.Write([]byte(`[
+				{"id": 1, "user": {"login": "a"}, "state": "APPROVED", "commit_id": "old"},
+				{"id": 2, "user": {"login": "b"}, "state": "CHANGES_REQUESTED", "commit_id": "old"},
+				{"id": 3, "user": {"login": "c"}, "state": "APPROVED", "commit_id": "rebased"},
+				{"id": 4, "user": {"login": "d"}, "state": "APPROVED", "commit_id": "docs"},
+				{"id": 5, "user": {"login": "e"}, "state": "APPROVED", "commit_id": "new"},
+				{"id": 6, "user": {"login": "f"}, "state": "APPROVED", "commit_id": "dev"}]`))
+		case "/repos/o/r/compare/master...old":
+			// Approved before a rebase, its commit was replaced
+			w.Write([]byte(`{"files": [{"filename": "main.go", "status": "modified", "patch": "@@ -1,2 +1,3 @@ package\n+a"}],
+				"commits": [{"sha": "c0", "author": {"login": "dev"}}]}`))
+		case "/repos/o/r/compare/master...rebased":
+			w.Write([]byte(`{"files": [{"filename": "main.go", "status": "modified", "patch": "@@ -5,2 +5,3 @@ package\n+a\n+b"},
+				{"filename": "README.md", "status": "modified", "patch": "@@ -1 +1 @@\n-x\n+y"}]}`))
+		case "/repos/o/r/compare/master...docs":
+			w.Write([]byte(`{"files": [{"filename": "main.go", "status": "modified", "patch": "@@ -5,2 +5,3 @@ package\n+a\n+b"}]}`))
+		case "/repos/o/r/compare/master...new":
+			w.Write([]byte(`{"files": [{"filename": "main.go", "status": "modified", "patch": "@@ -7,2 +7,3 @@ package\n+a\n+b"}],
+				"commits": [{"sha": "c1", "author": {"login": "dev"}}, {"sha": "c2", "author": {"login": "bot"}}]}`))
+		case "/repos/o/r/compare/master...dev":
+			// Only a commit of an exempt author was added since
+			w.Write([]byte(`{"files": [{"filename": "main.go", "status": "modified", "patch": "@@ -7,2 +7,2 @@ package\n+a"}],
+				"commits": [{"sha": "c1", "author": {"login": "dev"}}]}`))
+		default:
+			dismissed = append(dismissed, r.Method+" "+r.URL.Path+" "+string(body))
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	event := &github.PullRequestEvent{
+		Action: github.String("synchronize"),
+		PullRequest: &github.PullRequest{
+			Number: github.Int(2),
+			Base:   &github.PullRequestBranch{Ref: github.String("master")},
+			Head:   &github.PullRequestBranch{SHA: github.String("new")},
+		},
+		Repo: &github.Repository{Name: github.String("r"), HTMLURL: github.String("https://github.com/o/r"), Owner: &github.User{Login: github.String("o")}},
+	}
+	repoConfig := config.RepoConfig{DismissReviews: config.DismissReviewsConfig{ExemptPaths: []string{"*.md"}, ExemptAuthors: []string{"bot"}}}
+
+	if err := (&dismissReview{}).HandleEvent(event, client, repoConfig, zap.NewNop()); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`PUT /repos/o/r/pulls/2/reviews/1/dismissals {"message":"Code changed after review, from [old](https://github.com/o/r/commit/old) to [new](https://github.com/o/r/commit/new)"}` + "\n",
+	}
+	if !reflect.DeepEqual(dismissed, expected) {
+		t.Errorf("expected %q, got %q", expected, dismissed)
+	}
+}
+
+func TestExemptPath(t *testing.T) {
+	c := &diffComparer{config: config.DismissReviewsConfig{ExemptPaths: []string{"*.md", "docs/", "/build/*.sh"}}}
+	for file, expected := range map[string]bool{
+		"README.md":         true,
+		"pkg/docs/index.md": true,
+		"docs/guide.adoc":   true,
+		"pkg/docs/guide.go": false,
+		"build/release.sh":  true,
+		"build/ci/test.sh":  false,
+		"main.go":           false,
+	} {
+		if exempt := c.exemptPath(file); exempt != expected {
+			t.Errorf("expected exemptPath(%s) to be %v", file, expected)
+		}
+	}
+}
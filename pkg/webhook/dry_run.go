@@ -0,0 +1,68 @@
+// Copyright © 2017 Syndesis Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/syndesisio/pure-bot/pkg/github/apps"
+)
+
+// dryRunTransport lets read-only GitHub API requests pass and only logs all
+// others, answering them with an empty success response.
+type dryRunTransport struct {
+	next   http.RoundTripper
+	logger *zap.Logger
+}
+
+func dryRun(logger *zap.Logger) apps.Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &dryRunTransport{next: next, logger: logger}
+	}
+}
+
+func (t *dryRunTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		return t.next.RoundTrip(req)
+	}
+
+	var body []byte
+	if req.Body != nil {
+		body, _ = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+	}
+	t.logger.Info("Dry run: skipping GitHub API call", zap.String("method", req.Method), zap.String("path", req.URL.Path), zap.ByteString("body", body))
+
+	status := http.StatusOK
+	if req.Method == http.MethodDelete {
+		status = http.StatusNoContent
+	}
+	// An empty body leaves the result of the go-github call empty
+	return &http.Response{
+		Status:        http.StatusText(status),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{},
+		Body:          ioutil.NopCloser(bytes.NewReader(nil)),
+		ContentLength: 0,
+		Request:       req,
+	}, nil
+}
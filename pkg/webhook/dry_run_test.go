@@ -0,0 +1,41 @@
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-github/github"
+	"go.uber.org/zap"
+)
+
+func TestDryRunOnlyPassesReadOnlyCalls(t *testing.T) {
+	var methods []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		w.Write([]byte(`{"number": 1}`))
+	}))
+	defer server.Close()
+
+	client := github.NewClient(&http.Client{Transport: dryRun(zap.NewNop())(http.DefaultTransport)})
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+
+	if _, _, err := client.Issues.Get(context.Background(), "o", "r", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := client.Issues.AddLabelsToIssue(context.Background(), "o", "r", 1, []string{"approved"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Issues.RemoveLabelForIssue(context.Background(), "o", "r", 1, "approved"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := client.PullRequests.Merge(context.Background(), "o", "r", 1, "", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(methods) != 1 || methods[0] != http.MethodGet {
+		t.Errorf("expected only the GET request to reach the server, got %v", methods)
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/google/go-github/github"
 	"github.com/pkg/errors"
@@ -26,74 +27,227 @@ import (
 	"go.uber.org/zap"
 )
 
-type failedStatusCheckAddComment struct{}
-
-func (h *failedStatusCheckAddComment) EventTypesHandled() []string {
-	return []string{"status"}
+// ciSummaryMarker is the hidden marker of the summary comment, which is
+// updated in place instead of adding a comment per failure
+const ciSummaryMarker = "<!-- pure-bot:ci-summary -->"
+
+// ciSummaries serializes the updates of the summary comment of every pull
+// request, by lower cased "owner/repository#number". Without it the workers
+// handling two events of a pull request at once could both find no comment
+// and each create one.
+var ciSummaries = struct {
+	sync.Mutex
+	byPR map[string]*ciSummaryLock
+}{byPR: make(map[string]*ciSummaryLock)}
+
+// ciSummaryLock is dropped from ciSummaries once nobody holds or waits for it.
+type ciSummaryLock struct {
+	sync.Mutex
+	users int
 }
 
-func (h *failedStatusCheckAddComment) HandleEvent(eventObject interface{}, gh *github.Client, config config.RepoConfig, logger *zap.Logger) error {
-	event, ok := eventObject.(*github.StatusEvent)
+// lockCISummary locks the summary comment of the pull request and returns
+// the function unlocking it.
+func lockCISummary(owner, repository string, number int) func() {
+	key := strings.ToLower(fmt.Sprintf("%s/%s#%d", owner, repository, number))
+	ciSummaries.Lock()
+	lock, ok := ciSummaries.byPR[key]
 	if !ok {
-		return errors.New("wrong event eventObject type")
+		lock = &ciSummaryLock{}
+		ciSummaries.byPR[key] = lock
 	}
-
-	state := strings.ToLower(event.GetState())
-	if state == "pending" || state == "success" {
-		return nil
+	lock.users++
+	ciSummaries.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		ciSummaries.Lock()
+		if lock.users--; lock.users == 0 {
+			delete(ciSummaries.byPR, key)
+		}
+		ciSummaries.Unlock()
 	}
+}
 
-	// CodeCov and Codacy already comment so let's just ignore those status checks... Noisy otherwise...
-	statusContext := event.GetContext()
-	if strings.HasPrefix(statusContext, "codecov/") || strings.HasPrefix(statusContext, "codacy/") {
-		return nil
+// failedCheck is a failed status or check run of a pull request's head.
+type failedCheck struct {
+	name, state, description, url string
+}
+
+// failedStatusCheckAddComment keeps a single comment on every pull request
+// listing the failed statuses and check runs of its head. Once nothing fails
+// anymore the comment is collapsed or deleted.
+type failedStatusCheckAddComment struct{}
+
+func (h *failedStatusCheckAddComment) EventTypesHandled() []string {
+	return []string{"status", "check_run"}
+}
+
+func (h *failedStatusCheckAddComment) HandleEvent(eventObject interface{}, gh *github.Client, config config.RepoConfig, logger *zap.Logger) error {
+	var repo *github.Repository
+	var sha string
+	switch event := eventObject.(type) {
+	case *github.StatusEvent:
+		repo, sha = event.Repo, event.GetSHA()
+	case *github.CheckRunEvent:
+		if event.GetAction() != "completed" {
+			return nil
+		}
+		repo, sha = event.Repo, event.CheckRun.GetHeadSHA()
+	default:
+		return errors.Errorf("wrong event eventObject type %v", event)
 	}
 
-	commitSHA := event.GetSHA()
-	query := fmt.Sprintf("type:pr state:open repo:%s %s", event.Repo.GetFullName(), commitSHA)
-	searchResult, _, err := gh.Search.Issues(context.Background(), query, nil)
+	query := fmt.Sprintf("type:pr state:open repo:%s %s", repo.GetFullName(), sha)
+	searchResult, err := searchAllIssues(gh, query)
 	if err != nil {
 		return errors.Wrapf(err, "failed to find PR using query %s", query)
 	}
 
-	owner, repo := event.Repo.Owner.GetLogin(), event.Repo.GetName()
+	owner, repository := repo.Owner.GetLogin(), repo.GetName()
 
 	var multiErr error
-	for _, issue := range searchResult.Issues {
+	for _, issue := range searchResult {
 		if issue.PullRequestLinks == nil {
 			continue
 		}
-
-		prNumber := issue.GetNumber()
-
-		existingComments, _, err := gh.Issues.ListComments(context.Background(), owner, repo, prNumber, &github.IssueListCommentsOptions{
-			Sort:      "updated",
-			Direction: "desc",
-		})
+		pr, _, err := gh.PullRequests.Get(context.Background(), owner, repository, issue.GetNumber())
 		if err != nil {
-			multiErr = multierr.Combine(multiErr, errors.Wrapf(err, "failed to retrieve existing comments on PR %s", issue.GetHTMLURL()))
+			multiErr = multierr.Combine(multiErr, errors.Wrapf(err, "failed to get PR %s", issue.GetHTMLURL()))
+			continue
+		}
+		// Results of earlier heads don't matter anymore
+		if pr.Head.GetSHA() != sha {
 			continue
 		}
+		prLogger := logger.With(zap.String("repo", repo.GetFullName()), zap.Int("pr", pr.GetNumber()))
+		if err := updateCISummary(pr, owner, repository, gh, config.CISummary, prLogger); err != nil {
+			multiErr = multierr.Combine(multiErr, errors.Wrapf(err, "failed to update CI summary of PR %s", issue.GetHTMLURL()))
+		}
+	}
+
+	return multiErr
+}
+
+// updateCISummary creates, updates, collapses or deletes the summary comment
+// of the pull request, depending on the checks of its head.
+func updateCISummary(pr *github.PullRequest, owner, repository string, gh *github.Client, cfg config.CISummaryConfig, logger *zap.Logger) error {
+	defer lockCISummary(owner, repository, pr.GetNumber())()
+
+	sha := pr.Head.GetSHA()
+	failed, pending, err := headChecks(owner, repository, sha, gh, cfg)
+	if err != nil {
+		return err
+	}
+	existing, err := findMarkedComment(gh, owner, repository, pr.GetNumber(), ciSummaryMarker)
+	if err != nil {
+		return err
+	}
 
-		message := fmt.Sprintf(":warning: Status check _%s_ returned **%s**.", event.GetContext(), state)
-		if event.GetDescription() != "" {
-			message += "\n\n" + event.GetDescription()
+	short := sha
+	if len(short) > 7 {
+		short = short[:7]
+	}
+	var body string
+	switch {
+	case len(failed) > 0:
+		body = ciSummaryTable(failed, short)
+	case existing == nil:
+		return nil
+	case pending > 0:
+		body = fmt.Sprintf("%s\n:hourglass: No failed checks on %s so far, %d pending.", ciSummaryMarker, short, pending)
+	case cfg.GetDeleteWhenGreen():
+		logger.Debug("Deleting CI summary")
+		if _, err := gh.Issues.DeleteComment(context.Background(), owner, repository, existing.GetID()); err != nil {
+			return errors.Wrapf(err, "failed to delete comment %s", existing.GetHTMLURL())
 		}
-		if event.GetTargetURL() != "" {
-			message += fmt.Sprintf("\n\nSee %s for more details.", event.GetTargetURL())
+		return nil
+	default:
+		body = fmt.Sprintf("%s\n:white_check_mark: All checks passed on %s.", ciSummaryMarker, short)
+	}
+
+	logger.Debug("Updating CI summary", zap.Int("failed", len(failed)), zap.Int("pending", pending))
+	return upsertComment(gh, owner, repository, pr.GetNumber(), existing, body)
+}
+
+// headChecks returns the failed statuses and check runs of the head and the
+// number of pending ones, leaving out the ignored ones.
+func headChecks(owner, repository, sha string, gh *github.Client, cfg config.CISummaryConfig) ([]failedCheck, int, error) {
+	ignored := func(name string) bool {
+		for _, prefix := range cfg.IgnoredPrefixes {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
 		}
-		if commentsContainMessage(existingComments, message) {
+		return false
+	}
+
+	combined, err := getCombinedStatus(gh, owner, repository, sha)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "failed to get statuses of %s", sha)
+	}
+	var failed []failedCheck
+	pending := 0
+	for _, status := range combined.Statuses {
+		if ignored(status.GetContext()) {
 			continue
 		}
+		switch status.GetState() {
+		case "failure", "error":
+			failed = append(failed, failedCheck{status.GetContext(), status.GetState(), status.GetDescription(), status.GetTargetURL()})
+		case "pending":
+			pending++
+		}
+	}
 
-		_, _, err = gh.Issues.CreateComment(context.Background(), owner, repo, prNumber, &github.IssueComment{
-			Body: &message,
-		})
-		if err != nil {
-			multiErr = multierr.Combine(multiErr, errors.Wrapf(err, "failed to create comment on PR %s", issue.GetHTMLURL()))
+	runs, err := listAllCheckRuns(gh, owner, repository, sha)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "failed to list check runs of %s", sha)
+	}
+	for _, run := range runs {
+		if ignored(run.GetName()) {
 			continue
 		}
+		if run.GetStatus() != "completed" {
+			pending++
+			continue
+		}
+		switch run.GetConclusion() {
+		case "failure", "timed_out", "cancelled", "action_required":
+			failed = append(failed, failedCheck{run.GetName(), run.GetConclusion(), run.GetOutput().GetTitle(), run.GetHTMLURL()})
+		}
 	}
+	return failed, pending, nil
+}
 
-	return multiErr
+func ciSummaryTable(failed []failedCheck, sha string) string {
+	checks := "checks"
+	if len(failed) == 1 {
+		checks = "check"
+	}
+	lines := []string{
+		ciSummaryMarker,
+		fmt.Sprintf(":warning: %d %s failed on %s:", len(failed), checks, sha),
+		"",
+		"| Check | Result | Details |",
+		"|-------|--------|---------|",
+	}
+	for _, check := range failed {
+		details := tableCell(check.description)
+		if check.url != "" {
+			if details == "" {
+				details = "Details"
+			}
+			details = fmt.Sprintf("[%s](%s)", details, check.url)
+		}
+		lines = append(lines, fmt.Sprintf("| %s | **%s** | %s |", tableCell(check.name), check.state, details))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// tableCell escapes text for a cell of a Markdown table.
+func tableCell(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	return strings.NewReplacer("|", `\|`, "[", `\[`, "]", `\]`).Replace(text)
 }
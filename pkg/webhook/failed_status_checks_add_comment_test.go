@@ -0,0 +1,159 @@
+package webhook
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/google/go-github/github"
+	"go.uber.org/zap"
+
+	"github.com/syndesisio/pure-bot/pkg/config"
+)
+
+func TestCISummary(t *testing.T) {
+	appBot.login = "pure-bot[bot]"
+	defer func() { appBot.login = "" }()
+
+	for _, test := range []struct {
+		name      string
+		statuses  string
+		checkRuns string
+		comments  string
+		config    config.CISummaryConfig
+		expected  []string
+	}{
+		{
+			name: "failures",
+			statuses: `[{"context": "ci/jenkins", "state": "failure", "description": "Tests | failed", "target_url": "https://ci/1"},
+				{"context": "codecov/patch", "state": "failure"}, {"context": "ci/lint", "state": "success"}]`,
+			checkRuns: `[{"name": "build", "status": "completed", "conclusion": "timed_out", "html_url": "https://github.com/o/r/runs/2"}]`,
+			comments:  `[]`,
+			config:    config.CISummaryConfig{IgnoredPrefixes: []string{"codecov/"}},
+			expected: []string{
+				`POST /repos/o/r/issues/3/comments {"body":"` + ciSummaryMarker + `\n:warning: 2 checks failed on abc1234:\n\n| Check | Result | Details |\n|-------|--------|---------|\n` +
+					`| ci/jenkins | **failure** | [Tests \\| failed](https://ci/1) |\n| build | **timed_out** | [Details](https://github.com/o/r/runs/2) |"}` + "\n",
+			},
+		},
+		{
+			name:      "green",
+			statuses:  `[{"context": "ci/jenkins", "state": "success"}]`,
+			checkRuns: `[{"name": "build", "status": "completed", "conclusion": "success"}]`,
+			comments:  `[{"id": 9, "user": {"login": "pure-bot[bot]", "type": "Bot"}, "body": "` + ciSummaryMarker + `\n:warning: 1 check failed"}]`,
+			expected: []string{
+				`PATCH /repos/o/r/issues/comments/9 {"body":"` + ciSummaryMarker + `\n:white_check_mark: All checks passed on abc1234."}` + "\n",
+			},
+		},
+		{
+			name:      "green delete",
+			statuses:  `[]`,
+			checkRuns: `[]`,
+			comments:  `[{"id": 9, "user": {"login": "pure-bot[bot]", "type": "Bot"}, "body": "` + ciSummaryMarker + `\n:warning: 1 check failed"}]`,
+			config:    config.CISummaryConfig{DeleteWhenGreen: config.Bool(true)},
+			expected:  []string{"DELETE /repos/o/r/issues/comments/9 "},
+		},
+		{
+			name:      "marker pasted by a user",
+			statuses:  `[]`,
+			checkRuns: `[]`,
+			comments:  `[{"id": 9, "user": {"login": "dev", "type": "User"}, "body": "` + ciSummaryMarker + `\n:warning: 1 check failed"}]`,
+			config:    config.CISummaryConfig{DeleteWhenGreen: config.Bool(true)},
+		},
+		{
+			name:      "green without comment",
+			statuses:  `[]`,
+			checkRuns: `[]`,
+			comments:  `[]`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var requests []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				switch {
+				case r.URL.Path == "/search/issues":
+					w.Write([]byte(`{"total_count": 1, "items": [{"number": 3, "pull_request": {}}]}`))
+				case r.URL.Path == "/repos/o/r/pulls/3":
+					w.Write([]byte(`{"number": 3, "head": {"sha": "abc1234567"}}`))
+				case r.URL.Path == "/repos/o/r/commits/abc1234567/status":
+					w.Write([]byte(`{"state": "failure", "statuses": ` + test.statuses + `}`))
+				case r.URL.Path == "/repos/o/r/commits/abc1234567/check-runs":
+					w.Write([]byte(`{"check_runs": ` + test.checkRuns + `}`))
+				case r.Method == http.MethodGet && r.URL.Path == "/repos/o/r/issues/3/comments":
+					w.Write([]byte(test.comments))
+				default:
+					requests = append(requests, r.Method+" "+r.URL.Path+" "+string(body))
+					w.Write([]byte(`{}`))
+				}
+			}))
+			defer server.Close()
+
+			client := github.NewClient(nil)
+			client.BaseURL, _ = url.Parse(server.URL + "/")
+			event := &github.StatusEvent{
+				SHA:   github.String("abc1234567"),
+				State: github.String("failure"),
+				Repo:  &github.Repository{Name: github.String("r"), FullName: github.String("o/r"), Owner: &github.User{Login: github.String("o")}},
+			}
+
+			if err := (&failedStatusCheckAddComment{}).HandleEvent(event, client, config.RepoConfig{CISummary: test.config}, zap.NewNop()); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(requests, test.expected) {
+				t.Errorf("expected %q, got %q", test.expected, requests)
+			}
+		})
+	}
+}
+
+func TestCISummaryCreatesOneCommentForConcurrentEvents(t *testing.T) {
+	appBot.login = "pure-bot[bot]"
+	defer func() { appBot.login = "" }()
+
+	var mu sync.Mutex
+	comments := `[]`
+	created := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.URL.Path == "/repos/o/r/commits/abc1234567/status":
+			w.Write([]byte(`{"state": "failure", "statuses": [{"context": "ci/jenkins", "state": "failure"}]}`))
+		case r.URL.Path == "/repos/o/r/commits/abc1234567/check-runs":
+			w.Write([]byte(`{"check_runs": []}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/o/r/issues/3/comments":
+			w.Write([]byte(comments))
+		case r.Method == http.MethodPost && r.URL.Path == "/repos/o/r/issues/3/comments":
+			body, _ := ioutil.ReadAll(r.Body)
+			created++
+			comments = `[{"id": 9, "user": {"login": "pure-bot[bot]", "type": "Bot"}, "body": ` + string(body)[len(`{"body":`):len(body)-2] + `}]`
+			w.Write([]byte(`{}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	pr := &github.PullRequest{Number: github.Int(3), Head: &github.PullRequestBranch{SHA: github.String("abc1234567")}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := updateCISummary(pr, "o", "r", client, config.CISummaryConfig{}, zap.NewNop()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if created != 1 || len(ciSummaries.byPR) != 0 {
+		t.Errorf("expected one comment and no remaining locks, got %d comments and %d locks", created, len(ciSummaries.byPR))
+	}
+}
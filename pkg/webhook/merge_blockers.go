@@ -0,0 +1,151 @@
+// Copyright © 2017 Syndesis Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/syndesisio/pure-bot/pkg/config"
+)
+
+const (
+	autoMergeContext = "pure-bot/auto-merge"
+
+	// The draft flag of pull requests is still a preview API
+	draftPullRequestPreview = "application/vnd.github.shadow-cat-preview+json"
+)
+
+// Results of checkMergeable, as counted by the auto-merge metric
+const (
+	mergeBlocked     = "blocked"
+	mergeNotApproved = "not_approved"
+	mergeUnknown     = "mergeability_unknown"
+)
+
+// draftPullRequest adds the draft flag and the requested teams the GitHub
+// client doesn't know yet.
+type draftPullRequest struct {
+	github.PullRequest
+	Draft          bool           `json:"draft"`
+	RequestedTeams []*github.Team `json:"requested_teams"`
+}
+
+// checkMergeable verifies that nothing but pending checks keeps an approved
+// pull request from being merged. It returns the auto-merge result and the
+// reason if it may not be merged, which is also reported in the
+// pure-bot/auto-merge status of its head. While GitHub still computes
+// whether the pull request can be merged the result is mergeUnknown, and it
+// is evaluated again on a later event.
+func checkMergeable(pr *github.PullRequest, owner, repository string, gh *github.Client, config config.RepoConfig, logger *zap.Logger) (string, string, error) {
+	current, err := getDraftPullRequest(gh, owner, repository, pr.GetNumber())
+	if err != nil {
+		return "", "", err
+	}
+	statuses, err := getCombinedStatus(gh, owner, repository, current.Head.GetSHA())
+	if err != nil {
+		return "", "", errors.Wrapf(err, "failed to get statuses of pull request %s", pr.GetHTMLURL())
+	}
+
+	result, reason := mergeBlocked, blockingReason(current, statuses.Statuses, config)
+	if reason == "" {
+		result = mergeNotApproved
+		if reason, err = checkApprovals(&current.PullRequest, owner, repository, gh, config.AutoMerge, logger); err != nil {
+			return "", "", err
+		}
+	}
+	if reason == "" && current.GetMergeableState() == "unknown" {
+		result, reason = mergeUnknown, "GitHub is still checking whether it can be merged"
+	}
+	if reason == "" {
+		result = ""
+	}
+
+	status, description := successStatus, "OK - nothing blocks merging once all checks passed"
+	switch {
+	case result == mergeUnknown:
+		status, description = pendingStatus, "Waiting - "+reason
+	case reason != "":
+		status, description = pendingStatus, "Blocked - "+reason
+	}
+	for _, s := range statuses.Statuses {
+		if s.GetContext() == autoMergeContext && s.GetState() == string(status) && s.GetDescription() == description {
+			return result, reason, nil
+		}
+	}
+	if _, _, err := gh.Repositories.CreateStatus(context.Background(), owner, repository, current.Head.GetSHA(), &github.RepoStatus{
+		State:       github.String(string(status)),
+		Context:     github.String(autoMergeContext),
+		Description: github.String(description),
+	}); err != nil {
+		return "", "", errors.Wrapf(err, "failed to set %s status of pull request %s", autoMergeContext, pr.GetHTMLURL())
+	}
+	return result, reason, nil
+}
+
+// blockingReason returns why the pull request must not be merged regardless
+// of its reviews and checks, or an empty string.
+func blockingReason(pr *draftPullRequest, statuses []github.RepoStatus, config config.RepoConfig) string {
+	if pr.Draft || pr.GetMergeableState() == "draft" {
+		return "pull request is a draft"
+	}
+	for _, label := range config.AutoMerge.BlockingLabels {
+		if labelsContainsLabel(pr.Labels, label) {
+			return fmt.Sprintf("labelled '%s'", label)
+		}
+	}
+	for _, status := range statuses {
+		if status.GetContext() == wipContext && status.GetState() != string(successStatus) {
+			return "marked as work in progress"
+		}
+	}
+
+	switch pr.GetMergeableState() {
+	case "dirty":
+		return "merge conflicts with " + pr.Base.GetRef()
+	case "behind":
+		// The merge queue updates the branch itself
+		if !config.AutoMerge.GetQueue() {
+			return "branch is behind " + pr.Base.GetRef()
+		}
+	case "blocked":
+		// The merge queue waits for the required checks itself, which keep
+		// the pull request blocked until they passed, and removes it if the
+		// merge is refused
+		if !config.AutoMerge.GetQueue() {
+			return "blocked by the branch protection of " + pr.Base.GetRef()
+		}
+	}
+	return ""
+}
+
+func getDraftPullRequest(gh *github.Client, owner, repository string, number int) (*draftPullRequest, error) {
+	req, err := gh.NewRequest(http.MethodGet, fmt.Sprintf("repos/%s/%s/pulls/%d", owner, repository, number), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create pull request request")
+	}
+	req.Header.Set("Accept", draftPullRequestPreview)
+
+	pr := new(draftPullRequest)
+	if _, err := gh.Do(context.Background(), req, pr); err != nil {
+		return nil, errors.Wrapf(err, "failed to get pull request %d", number)
+	}
+	return pr, nil
+}
@@ -0,0 +1,91 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-github/github"
+	"go.uber.org/zap"
+
+	"github.com/syndesisio/pure-bot/pkg/config"
+)
+
+func TestBlockingReason(t *testing.T) {
+	repoConfig := config.RepoConfig{AutoMerge: config.AutoMergeConfig{BlockingLabels: []string{"do not merge"}}}
+	pr := func(draft bool, mergeableState string, labels ...string) *draftPullRequest {
+		ret := &draftPullRequest{Draft: draft}
+		ret.MergeableState = github.String(mergeableState)
+		ret.Base = &github.PullRequestBranch{Ref: github.String("main")}
+		for _, label := range labels {
+			ret.Labels = append(ret.Labels, &github.Label{Name: github.String(label)})
+		}
+		return ret
+	}
+	wipPending := []github.RepoStatus{{Context: github.String(wipContext), State: github.String("pending")}}
+
+	tests := []struct {
+		name     string
+		pr       *draftPullRequest
+		statuses []github.RepoStatus
+		reason   string
+	}{
+		{"clean", pr(false, "clean", "approved"), nil, ""},
+		{"unstable", pr(false, "unstable"), nil, ""},
+		{"draft", pr(true, "clean"), nil, "pull request is a draft"},
+		{"blocking label", pr(false, "clean", "approved", "Do Not Merge"), nil, "labelled 'do not merge'"},
+		{"wip", pr(false, "clean"), wipPending, "marked as work in progress"},
+		{"conflicts", pr(false, "dirty"), nil, "merge conflicts with main"},
+		{"behind", pr(false, "behind"), nil, "branch is behind main"},
+		{"branch protection", pr(false, "blocked"), nil, "blocked by the branch protection of main"},
+		{"unknown", pr(false, "unknown"), nil, ""},
+	}
+	for _, test := range tests {
+		if reason := blockingReason(test.pr, test.statuses, repoConfig); reason != test.reason {
+			t.Errorf("%s: expected %q, got %q", test.name, test.reason, reason)
+		}
+	}
+
+	repoConfig.AutoMerge.Queue = config.Bool(true)
+	if reason := blockingReason(pr(false, "behind"), nil, repoConfig); reason != "" {
+		t.Errorf("the merge queue updates branches itself, got %q", reason)
+	}
+	if reason := blockingReason(pr(false, "blocked"), nil, repoConfig); reason != "" {
+		t.Errorf("the merge queue waits for required checks itself, got %q", reason)
+	}
+}
+
+func TestCheckMergeableWaitsForMergeability(t *testing.T) {
+	var descriptions []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/repos/o/r/pulls/1":
+			w.Write([]byte(`{"number": 1, "mergeable_state": "unknown", "head": {"sha": "a1"}, "base": {"ref": "main"}}`))
+		case r.Method == http.MethodPost:
+			var status github.RepoStatus
+			body, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(body, &status)
+			descriptions = append(descriptions, status.GetDescription())
+			w.Write([]byte(`{}`))
+		default:
+			w.Write([]byte(`{"statuses": []}`))
+		}
+	}))
+	defer server.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	pr := &github.PullRequest{Number: github.Int(1)}
+	result, reason, err := checkMergeable(pr, "o", "r", client, config.RepoConfig{}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"Waiting - GitHub is still checking whether it can be merged"}
+	if result != mergeUnknown || reason == "" || !reflect.DeepEqual(descriptions, expected) {
+		t.Errorf("expected result %q and statuses %v, got %q and %v", mergeUnknown, expected, result, descriptions)
+	}
+}
@@ -0,0 +1,501 @@
+// Copyright © 2017 Syndesis Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/syndesisio/pure-bot/pkg/config"
+	"github.com/syndesisio/pure-bot/pkg/github/apps"
+)
+
+const mergeFreezeContext = "pure-bot/merge-freeze"
+
+// MergeFreeze is an ad-hoc merge freeze of a repository, started with a
+// `/freeze` comment or the admin endpoint.
+type MergeFreeze struct {
+	Repo   string    `json:"repo"`
+	Reason string    `json:"reason,omitempty"`
+	By     string    `json:"by"`
+	Since  time.Time `json:"since"`
+}
+
+// adHocFreezes holds the ad-hoc merge freezes by the lower cased full name
+// of their repository. They are written to file on every change, if set,
+// together with frozenPullRequests and repoInstallations. The lock also
+// serializes writing the file and has to be taken before theirs.
+var adHocFreezes = struct {
+	sync.Mutex
+	byRepo map[string]MergeFreeze
+	file   string
+}{byRepo: make(map[string]MergeFreeze)}
+
+// frozenPullRequests remembers the head of every pull request a merge freeze
+// status was set on, by repository and number, so that the status can be
+// cleared when the freeze ends.
+var frozenPullRequests = struct {
+	sync.Mutex
+	byRepo map[string]map[int]frozenPullRequest
+}{byRepo: make(map[string]map[int]frozenPullRequest)}
+
+type frozenPullRequest struct {
+	HeadSHA     string `json:"headSha"`
+	Description string `json:"description"`
+}
+
+// repoInstallations remembers the installation of the GitHub App for every
+// repository an event was received for, to act on repositories outside of
+// event handling.
+var repoInstallations = struct {
+	sync.Mutex
+	byRepo map[string]int64
+}{byRepo: make(map[string]int64)}
+
+// rememberInstallation records the installation of the repository and
+// persists it if it changed.
+func rememberInstallation(repo string, installationID int64) error {
+	repoInstallations.Lock()
+	known := repoInstallations.byRepo[strings.ToLower(repo)] == installationID
+	repoInstallations.byRepo[strings.ToLower(repo)] = installationID
+	repoInstallations.Unlock()
+	if known {
+		return nil
+	}
+	return persistMergeFreezes()
+}
+
+// mergeFreezeState is the content of the merge freeze file. Files written
+// before pull requests and installations were persisted only hold the list
+// of freezes.
+type mergeFreezeState struct {
+	Freezes            []MergeFreeze                        `json:"freezes"`
+	FrozenPullRequests map[string]map[int]frozenPullRequest `json:"frozenPullRequests"`
+	Installations      map[string]int64                     `json:"installations"`
+}
+
+// LoadMergeFreezes restores the ad-hoc merge freezes, the pull requests held
+// back by merge freezes and the installations of repositories persisted to
+// file and persists all later changes there. Without a file they are only
+// kept in memory.
+func LoadMergeFreezes(file string) error {
+	adHocFreezes.Lock()
+	defer adHocFreezes.Unlock()
+
+	adHocFreezes.file = file
+	if file == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrap(err, "failed to read merge freeze file")
+	}
+	var state mergeFreezeState
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+		err = json.Unmarshal(data, &state.Freezes)
+	} else {
+		err = json.Unmarshal(data, &state)
+	}
+	if err != nil {
+		return errors.Wrap(err, "invalid merge freeze file")
+	}
+	for _, f := range state.Freezes {
+		adHocFreezes.byRepo[strings.ToLower(f.Repo)] = f
+	}
+
+	frozenPullRequests.Lock()
+	for repo, prs := range state.FrozenPullRequests {
+		frozenPullRequests.byRepo[repo] = prs
+	}
+	frozenPullRequests.Unlock()
+
+	repoInstallations.Lock()
+	for repo, id := range state.Installations {
+		repoInstallations.byRepo[repo] = id
+	}
+	repoInstallations.Unlock()
+	return nil
+}
+
+// freezeMerges starts or replaces the ad-hoc freeze of a repository. If it
+// can't be persisted the previous state is kept.
+func freezeMerges(freeze MergeFreeze) error {
+	adHocFreezes.Lock()
+	defer adHocFreezes.Unlock()
+
+	repo := strings.ToLower(freeze.Repo)
+	previous, existed := adHocFreezes.byRepo[repo]
+	adHocFreezes.byRepo[repo] = freeze
+	if err := saveMergeFreezes(); err != nil {
+		if existed {
+			adHocFreezes.byRepo[repo] = previous
+		} else {
+			delete(adHocFreezes.byRepo, repo)
+		}
+		return err
+	}
+	return nil
+}
+
+// unfreezeMerges ends the ad-hoc freeze of a repository and reports whether
+// there was one. If it can't be persisted the freeze is kept.
+func unfreezeMerges(repo string) (bool, error) {
+	adHocFreezes.Lock()
+	defer adHocFreezes.Unlock()
+
+	repo = strings.ToLower(repo)
+	previous, ok := adHocFreezes.byRepo[repo]
+	if !ok {
+		return false, nil
+	}
+	delete(adHocFreezes.byRepo, repo)
+	if err := saveMergeFreezes(); err != nil {
+		adHocFreezes.byRepo[repo] = previous
+		return true, err
+	}
+	return true, nil
+}
+
+func listMergeFreezes() []MergeFreeze {
+	adHocFreezes.Lock()
+	defer adHocFreezes.Unlock()
+
+	freezes := []MergeFreeze{}
+	for _, f := range adHocFreezes.byRepo {
+		freezes = append(freezes, f)
+	}
+	sort.Slice(freezes, func(i, j int) bool {
+		return freezes[i].Repo < freezes[j].Repo
+	})
+	return freezes
+}
+
+// persistMergeFreezes writes the merge freeze file. It must not be called
+// with any of adHocFreezes, frozenPullRequests or repoInstallations locked.
+func persistMergeFreezes() error {
+	adHocFreezes.Lock()
+	defer adHocFreezes.Unlock()
+	return saveMergeFreezes()
+}
+
+// saveMergeFreezes must be called with adHocFreezes locked.
+func saveMergeFreezes() error {
+	if adHocFreezes.file == "" {
+		return nil
+	}
+	state := mergeFreezeState{
+		Freezes:            make([]MergeFreeze, 0, len(adHocFreezes.byRepo)),
+		FrozenPullRequests: make(map[string]map[int]frozenPullRequest),
+		Installations:      make(map[string]int64),
+	}
+	for _, f := range adHocFreezes.byRepo {
+		state.Freezes = append(state.Freezes, f)
+	}
+
+	frozenPullRequests.Lock()
+	for repo, prs := range frozenPullRequests.byRepo {
+		state.FrozenPullRequests[repo] = make(map[int]frozenPullRequest, len(prs))
+		for number, pr := range prs {
+			state.FrozenPullRequests[repo][number] = pr
+		}
+	}
+	frozenPullRequests.Unlock()
+
+	repoInstallations.Lock()
+	for repo, id := range repoInstallations.byRepo {
+		state.Installations[repo] = id
+	}
+	repoInstallations.Unlock()
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode merge freezes")
+	}
+	tmp := adHocFreezes.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return errors.Wrap(err, "failed to write merge freeze file")
+	}
+	return errors.Wrap(os.Rename(tmp, adHocFreezes.file), "failed to write merge freeze file")
+}
+
+// activeMergeFreeze returns the description of the merge freeze of the
+// repository at now, or false if there is none.
+func activeMergeFreeze(repo string, config config.RepoConfig, now time.Time) (string, bool) {
+	adHocFreezes.Lock()
+	freeze, ok := adHocFreezes.byRepo[strings.ToLower(repo)]
+	adHocFreezes.Unlock()
+	if ok {
+		description := "Merges frozen by @" + freeze.By
+		if freeze.Reason != "" {
+			description += ": " + freeze.Reason
+		}
+		return description, true
+	}
+
+	for _, w := range config.MergeFreeze {
+		active, end, err := w.ActiveAt(now)
+		if err != nil || !active {
+			continue
+		}
+		name := w.Name
+		if name == "" {
+			name = "Merge freeze"
+		}
+		if loc, err := time.LoadLocation(w.TimeZone); err == nil {
+			end = end.In(loc)
+		}
+		return name + " until " + end.Format("2006-01-02 15:04 MST"), true
+	}
+	return "", false
+}
+
+// checkMergeFreeze tells whether merges into the repository are frozen. If
+// so it sets the merge freeze status on the pull request's head, which is
+// cleared once the freeze ended.
+func checkMergeFreeze(pr *github.PullRequest, owner, repository string, gh *github.Client, config config.RepoConfig, logger *zap.Logger) (bool, error) {
+	repo := strings.ToLower(owner + "/" + repository)
+	description, frozen := activeMergeFreeze(repo, config, time.Now())
+	if !frozen {
+		return false, nil
+	}
+	logger.Debug("Not merging pull request during merge freeze", zap.Int("pr", pr.GetNumber()), zap.String("freeze", description))
+	autoMergeResults.Inc("frozen")
+
+	current := frozenPullRequest{HeadSHA: pr.Head.GetSHA(), Description: description}
+	frozenPullRequests.Lock()
+	reported := frozenPullRequests.byRepo[repo][pr.GetNumber()] == current
+	frozenPullRequests.Unlock()
+	if reported {
+		return true, nil
+	}
+
+	if err := setMergeFreezeStatus(pr.GetNumber(), current.HeadSHA, pendingStatus, description, owner, repository, gh); err != nil {
+		return true, err
+	}
+	frozenPullRequests.Lock()
+	if frozenPullRequests.byRepo[repo] == nil {
+		frozenPullRequests.byRepo[repo] = make(map[int]frozenPullRequest)
+	}
+	frozenPullRequests.byRepo[repo][pr.GetNumber()] = current
+	frozenPullRequests.Unlock()
+	return true, errors.Wrap(persistMergeFreezes(), "failed to persist frozen pull request")
+}
+
+func setMergeFreezeStatus(number int, headSHA string, status commitStatus, description, owner, repository string, gh *github.Client) error {
+	if _, _, err := gh.Repositories.CreateStatus(context.Background(), owner, repository, headSHA, &github.RepoStatus{
+		State:       github.String(string(status)),
+		Context:     github.String(mergeFreezeContext),
+		Description: github.String(description),
+	}); err != nil {
+		return errors.Wrapf(err, "failed to set %s status of pull request %d", mergeFreezeContext, number)
+	}
+	return nil
+}
+
+// RunMergeFreezes checks every interval whether the merge freezes pull
+// requests were held back by ended. It clears their merge freeze status and
+// evaluates them for auto-merge again, until stop is closed.
+func RunMergeFreezes(store *config.Store, interval time.Duration, stop <-chan struct{}, logger *zap.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			releaseMergeFreezes(store.Get(), time.Now(), logger)
+		}
+	}
+}
+
+func releaseMergeFreezes(cfg config.Config, now time.Time, logger *zap.Logger) {
+	frozenPullRequests.Lock()
+	repos := make([]string, 0, len(frozenPullRequests.byRepo))
+	for repo := range frozenPullRequests.byRepo {
+		repos = append(repos, repo)
+	}
+	frozenPullRequests.Unlock()
+
+	for _, repo := range repos {
+		slash := strings.Index(repo, "/")
+		owner, name := repo[:slash], repo[slash+1:]
+		repoConfig := cachedRepoConfig(cfg, owner, name)
+		if _, frozen := activeMergeFreeze(repo, repoConfig, now); frozen {
+			continue
+		}
+
+		repoLogger := logger.With(zap.String("repo", repo))
+		gh, err := installationClient(cfg, repo, repoConfig, repoLogger)
+		if err != nil {
+			repoLogger.Error("Failed to create GitHub client to end merge freeze", zap.Error(err))
+			continue
+		}
+		if gh == nil {
+			continue
+		}
+
+		frozenPullRequests.Lock()
+		prs := frozenPullRequests.byRepo[repo]
+		delete(frozenPullRequests.byRepo, repo)
+		frozenPullRequests.Unlock()
+		if err := persistMergeFreezes(); err != nil {
+			repoLogger.Warn("Failed to persist end of merge freeze", zap.Error(err))
+		}
+
+		repoLogger.Info("Merge freeze ended", zap.Int("pullRequests", len(prs)))
+		for number, frozen := range prs {
+			if err := setMergeFreezeStatus(number, frozen.HeadSHA, successStatus, "No merge freeze", owner, name, gh); err != nil {
+				repoLogger.Warn("Failed to clear merge freeze status", zap.Int("pr", number), zap.Error(err))
+			}
+			if repoConfig.IsDisabled() || !repoConfig.HandlerEnabled("autoMerge", true) {
+				continue
+			}
+			if err := mergePRByNumber(number, owner, name, gh, repoConfig, repoLogger); err != nil {
+				repoLogger.Warn("Failed to merge pull request after merge freeze", zap.Int("pr", number), zap.Error(err))
+			}
+		}
+	}
+}
+
+// cachedRepoConfig returns the configuration of the repository including
+// its config file, as far as it was cached by earlier events.
+func cachedRepoConfig(cfg config.Config, owner, name string) config.RepoConfig {
+	repoConfig, _ := cfg.RepoConfigFor(owner, name)
+	repoConfigFiles.Lock()
+	defer repoConfigFiles.Unlock()
+	for fullName, cached := range repoConfigFiles.byRepo {
+		if strings.EqualFold(fullName, owner+"/"+name) && cached.config != nil {
+			repoConfig.MergeRepoFile(*cached.config)
+		}
+	}
+	return repoConfig
+}
+
+// installationClient returns a client for the installation remembered for
+// the repository, which honors its dry run setting, or nil if no event of
+// the repository was received yet.
+func installationClient(cfg config.Config, repo string, repoConfig config.RepoConfig, logger *zap.Logger) (*github.Client, error) {
+	repoInstallations.Lock()
+	installationID, ok := repoInstallations.byRepo[strings.ToLower(repo)]
+	repoInstallations.Unlock()
+	if !ok {
+		return nil, nil
+	}
+	middleware := []apps.Middleware{githubAPIMetrics(installationID)}
+	if repoConfig.IsDryRun() {
+		middleware = append(middleware, dryRun(logger))
+	}
+	return newGitHubClient(cfg.GitHubApp, installationID, middleware...)
+}
+
+// MergeFreezeHandler lists, starts and ends ad-hoc merge freezes: GET lists
+// all, POST freezes the repository given by the `repo` query parameter with
+// an optional `reason` and DELETE ends its freeze. Requests have to carry the
+// admin token of the active configuration as bearer token; without a token
+// the endpoint doesn't exist.
+func MergeFreezeHandler(store *config.Store, logger *zap.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := store.Get().Admin.Token
+		if token == "" {
+			http.NotFound(w, r)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		repo := r.URL.Query().Get("repo")
+		if r.Method != http.MethodGet && strings.Count(repo, "/") != 1 {
+			http.Error(w, "repo must be given as owner/name", http.StatusBadRequest)
+			return
+		}
+
+		var result interface{}
+		switch r.Method {
+		case http.MethodGet:
+			result = listMergeFreezes()
+		case http.MethodPost:
+			freeze := MergeFreeze{Repo: repo, Reason: r.URL.Query().Get("reason"), By: "admin", Since: time.Now()}
+			if err := freezeMerges(freeze); err != nil {
+				logger.Error("Failed to persist merge freeze", zap.Error(err))
+				http.Error(w, "failed to persist merge freeze", http.StatusInternalServerError)
+				return
+			}
+			logger.Info("Merges frozen", zap.String("repo", repo), zap.String("by", freeze.By))
+			result = freeze
+		case http.MethodDelete:
+			found, err := unfreezeMerges(repo)
+			if err != nil {
+				logger.Error("Failed to persist merge freeze", zap.Error(err))
+				http.Error(w, "failed to persist merge freeze", http.StatusInternalServerError)
+				return
+			}
+			if !found {
+				http.NotFound(w, r)
+				return
+			}
+			logger.Info("Merges unfrozen", zap.String("repo", repo), zap.String("by", "admin"))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	})
+}
+
+// freezeCommand freezes merges into the repository, `/freeze [reason]`.
+func freezeCommand(c *commandContext, args []string) error {
+	freeze := MergeFreeze{Repo: c.event.Repo.GetFullName(), Reason: strings.Join(args, " "), By: c.user, Since: time.Now()}
+	if err := freezeMerges(freeze); err != nil {
+		return errors.Wrap(err, "failed to persist merge freeze")
+	}
+	c.logger.Info("Merges frozen", zap.String("by", c.user))
+	c.reply(":snowflake: Merges into " + freeze.Repo + " are frozen until a maintainer comments `/unfreeze`.")
+	return nil
+}
+
+func unfreezeCommand(c *commandContext, _ []string) error {
+	repo := c.event.Repo.GetFullName()
+	found, err := unfreezeMerges(repo)
+	if err != nil {
+		return errors.Wrap(err, "failed to persist merge freeze")
+	}
+	if !found {
+		return commandError("failed, merges into " + repo + " are not frozen by `/freeze`")
+	}
+	c.logger.Info("Merges unfrozen", zap.String("by", c.user))
+	c.reply(":sunny: Merges into " + repo + " are no longer frozen.")
+	return nil
+}
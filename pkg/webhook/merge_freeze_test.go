@@ -0,0 +1,187 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+	"go.uber.org/zap"
+
+	"github.com/syndesisio/pure-bot/pkg/config"
+)
+
+func TestActiveMergeFreeze(t *testing.T) {
+	adHocFreezes.byRepo = make(map[string]MergeFreeze)
+	now := time.Date(2018, 11, 9, 18, 30, 0, 0, time.UTC)
+	repoConfig := config.RepoConfig{
+		MergeFreeze: []config.MergeFreezeWindow{
+			{Name: "Release", From: "2018-12-20", To: "2018-12-24"},
+			{Name: "Weekend", Cron: "0 18 * * 5", Duration: 62 * time.Hour},
+		},
+	}
+
+	if description, frozen := activeMergeFreeze("o/r", repoConfig, now); !frozen || description != "Weekend until 2018-11-12 08:00 UTC" {
+		t.Errorf("expected weekend freeze, got %v %q", frozen, description)
+	}
+	if _, frozen := activeMergeFreeze("o/r", repoConfig, now.Add(-time.Hour)); frozen {
+		t.Error("expected no freeze before the weekend")
+	}
+
+	freezeMerges(MergeFreeze{Repo: "O/R", Reason: "release", By: "maintainer"})
+	if description, frozen := activeMergeFreeze("o/r", config.RepoConfig{}, now); !frozen || description != "Merges frozen by @maintainer: release" {
+		t.Errorf("expected ad-hoc freeze, got %v %q", frozen, description)
+	}
+	if found, _ := unfreezeMerges("o/r"); !found {
+		t.Error("expected ad-hoc freeze to be ended")
+	}
+	if _, frozen := activeMergeFreeze("o/r", config.RepoConfig{}, now); frozen {
+		t.Error("expected no freeze after unfreezing")
+	}
+}
+
+func TestCheckMergeFreezeReportsOnce(t *testing.T) {
+	adHocFreezes.byRepo = map[string]MergeFreeze{"o/r": {Repo: "o/r", By: "maintainer"}}
+	frozenPullRequests.byRepo = make(map[string]map[int]frozenPullRequest)
+	defer func() { adHocFreezes.byRepo = make(map[string]MergeFreeze) }()
+
+	var statuses []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var status github.RepoStatus
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &status)
+		statuses = append(statuses, r.URL.Path+" "+status.GetState()+" "+status.GetDescription())
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	pr := &github.PullRequest{Number: github.Int(1), Head: &github.PullRequestBranch{SHA: github.String("a1")}}
+
+	for i := 0; i < 2; i++ {
+		frozen, err := checkMergeFreeze(pr, "o", "r", client, config.RepoConfig{}, zap.NewNop())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !frozen {
+			t.Fatal("expected pull request to be frozen")
+		}
+	}
+	expected := []string{"/repos/o/r/statuses/a1 pending Merges frozen by @maintainer"}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("expected %v, got %v", expected, statuses)
+	}
+	if frozenPullRequests.byRepo["o/r"][1].HeadSHA != "a1" {
+		t.Error("expected frozen pull request to be remembered")
+	}
+}
+
+func TestMergeFreezeHandlerRequiresToken(t *testing.T) {
+	adHocFreezes.byRepo = make(map[string]MergeFreeze)
+	store := config.NewStore(config.Config{Admin: config.AdminConfig{Token: "secret"}})
+	handler := MergeFreezeHandler(store, zap.NewNop())
+
+	tests := []struct {
+		method, target, token string
+		expected              int
+	}{
+		{http.MethodPost, "/?repo=o/r", "", http.StatusUnauthorized},
+		{http.MethodPost, "/?repo=o/r", "wrong", http.StatusUnauthorized},
+		{http.MethodPost, "/?repo=o", "secret", http.StatusBadRequest},
+		{http.MethodPost, "/?repo=o/r&reason=release", "secret", http.StatusOK},
+		{http.MethodGet, "/", "secret", http.StatusOK},
+		{http.MethodDelete, "/?repo=o/r", "secret", http.StatusNoContent},
+		{http.MethodDelete, "/?repo=o/r", "secret", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.target, nil)
+		if test.token != "" {
+			r.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != test.expected {
+			t.Errorf("%s %s: expected %d, got %d", test.method, test.target, test.expected, w.Code)
+		}
+	}
+}
+
+func TestMergeFreezePersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "merge-freeze")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	reset := func() {
+		adHocFreezes.byRepo = make(map[string]MergeFreeze)
+		adHocFreezes.file = ""
+		frozenPullRequests.byRepo = make(map[string]map[int]frozenPullRequest)
+		repoInstallations.byRepo = make(map[string]int64)
+	}
+	reset()
+	defer reset()
+
+	file := filepath.Join(dir, "merge-freezes.json")
+	if err := LoadMergeFreezes(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := freezeMerges(MergeFreeze{Repo: "o/r", By: "maintainer"}); err != nil {
+		t.Fatal(err)
+	}
+	frozenPullRequests.byRepo["o/r"] = map[int]frozenPullRequest{1: {HeadSHA: "a1", Description: "Merges frozen by @maintainer"}}
+	if err := rememberInstallation("O/R", 42); err != nil {
+		t.Fatal(err)
+	}
+
+	reset()
+	if err := LoadMergeFreezes(file); err != nil {
+		t.Fatal(err)
+	}
+	if len(adHocFreezes.byRepo) != 1 || adHocFreezes.byRepo["o/r"].By != "maintainer" {
+		t.Errorf("expected freeze to be restored, got %v", adHocFreezes.byRepo)
+	}
+	if frozenPullRequests.byRepo["o/r"][1].HeadSHA != "a1" {
+		t.Errorf("expected frozen pull request to be restored, got %v", frozenPullRequests.byRepo)
+	}
+	if repoInstallations.byRepo["o/r"] != 42 {
+		t.Errorf("expected installation to be restored, got %v", repoInstallations.byRepo)
+	}
+
+	// Files written before only hold the list of freezes
+	reset()
+	if err := ioutil.WriteFile(file, []byte(`[{"repo": "o/r", "by": "maintainer"}]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadMergeFreezes(file); err != nil {
+		t.Fatal(err)
+	}
+	if len(adHocFreezes.byRepo) != 1 {
+		t.Errorf("expected freeze to be restored from list, got %v", adHocFreezes.byRepo)
+	}
+}
+
+func TestMergeFreezeHandlerPersistenceFailure(t *testing.T) {
+	adHocFreezes.byRepo = make(map[string]MergeFreeze)
+	adHocFreezes.file = filepath.Join("does", "not", "exist", "merge-freezes.json")
+	defer func() { adHocFreezes.file = "" }()
+	store := config.NewStore(config.Config{Admin: config.AdminConfig{Token: "secret"}})
+
+	r := httptest.NewRequest(http.MethodPost, "/?repo=o/r", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	MergeFreezeHandler(store, zap.NewNop()).ServeHTTP(w, r)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected %d, got %d", http.StatusInternalServerError, w.Code)
+	}
+	if len(adHocFreezes.byRepo) != 0 {
+		t.Errorf("expected no freeze, got %v", adHocFreezes.byRepo)
+	}
+}
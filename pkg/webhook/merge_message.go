@@ -0,0 +1,225 @@
+// Copyright © 2017 Syndesis Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+
+	"github.com/syndesisio/pure-bot/pkg/config"
+)
+
+// Locations GitHub looks for a pull request template at
+var pullRequestTemplateFiles = []string{
+	".github/PULL_REQUEST_TEMPLATE.md",
+	".github/pull_request_template.md",
+	"PULL_REQUEST_TEMPLATE.md",
+	"docs/PULL_REQUEST_TEMPLATE.md",
+}
+
+var (
+	htmlCommentRE   = regexp.MustCompile(`(?s)<!--.*?-->`)
+	checkedBoxRE    = regexp.MustCompile(`\[[xX]\]`)
+	blankLinesRE    = regexp.MustCompile(`\n{3,}`)
+	mergeMethodPref = []string{config.MergeMethodMerge, config.MergeMethodSquash, config.MergeMethodRebase}
+)
+
+// mergeMessage is the data available to the commit title and body templates
+// of the autoMerge config.
+type mergeMessage struct {
+	Title     string
+	Number    int
+	Body      string
+	Author    string
+	Approvers []string
+	// Issues are the issues closed by the pull request, e.g. "#42"
+	Issues []string
+}
+
+// mergeRequest describes how a pull request is merged.
+type mergeRequest struct {
+	method string
+	title  string
+	body   string
+}
+
+// newMergeRequest determines the merge method and renders the commit message
+// of the pull request as configured.
+func newMergeRequest(gh *github.Client, owner, repository string, pr *github.PullRequest, cfg config.AutoMergeConfig) (*mergeRequest, error) {
+	req := &mergeRequest{}
+
+	if cfg.Method != "" {
+		repo, _, err := gh.Repositories.Get(context.Background(), owner, repository)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get repository %s/%s", owner, repository)
+		}
+		req.method = selectMergeMethod(cfg.Method, repo)
+	}
+
+	bodyTemplate := cfg.CommitBody
+	if bodyTemplate == "" && (cfg.GetStripTemplate() || cfg.GetCoAuthors()) {
+		bodyTemplate = "{{.Body}}"
+	}
+	if cfg.CommitTitle == "" && bodyTemplate == "" {
+		return req, nil
+	}
+
+	message := &mergeMessage{
+		Title:  pr.GetTitle(),
+		Number: pr.GetNumber(),
+		Body:   pr.GetBody(),
+		Author: pr.GetUser().GetLogin(),
+	}
+	extractIssueNumbers(&message.Issues, pr.GetBody())
+	for i, issue := range message.Issues {
+		message.Issues[i] = "#" + issue
+	}
+
+	approvers, err := listApprovers(gh, owner, repository, pr.GetNumber())
+	if err != nil {
+		return nil, err
+	}
+	message.Approvers = approvers
+
+	if cfg.GetStripTemplate() && req.method == config.MergeMethodSquash {
+		prTemplate, err := getPullRequestTemplate(gh, owner, repository, pr.GetBase().GetRef())
+		if err != nil {
+			return nil, err
+		}
+		message.Body = stripPullRequestTemplate(message.Body, prTemplate)
+	}
+
+	if req.title, err = renderMergeTemplate("commitTitle", cfg.CommitTitle, message); err != nil {
+		return nil, err
+	}
+	if req.body, err = renderMergeTemplate("commitBody", bodyTemplate, message); err != nil {
+		return nil, err
+	}
+
+	if cfg.GetCoAuthors() && req.method != config.MergeMethodRebase {
+		trailers, err := coAuthorTrailers(gh, owner, repository, pr)
+		if err != nil {
+			return nil, err
+		}
+		if len(trailers) > 0 {
+			req.body = strings.TrimSpace(req.body + "\n\n" + strings.Join(trailers, "\n"))
+		}
+	}
+	return req, nil
+}
+
+// selectMergeMethod returns the preferred method if the repository allows it
+// and the first allowed method otherwise.
+func selectMergeMethod(preferred string, repo *github.Repository) string {
+	allowed := map[string]bool{
+		config.MergeMethodMerge:  repo.GetAllowMergeCommit(),
+		config.MergeMethodSquash: repo.GetAllowSquashMerge(),
+		config.MergeMethodRebase: repo.GetAllowRebaseMerge(),
+	}
+	if allowed[preferred] {
+		return preferred
+	}
+	for _, method := range mergeMethodPref {
+		if allowed[method] {
+			return method
+		}
+	}
+	return ""
+}
+
+func renderMergeTemplate(name, text string, message *mergeMessage) (string, error) {
+	if text == "" {
+		return "", nil
+	}
+	t, err := template.New(name).Funcs(config.TemplateFuncs).Parse(text)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid autoMerge.%s template", name)
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, message); err != nil {
+		return "", errors.Wrapf(err, "failed to render autoMerge.%s template", name)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+func getPullRequestTemplate(gh *github.Client, owner, repository, ref string) (string, error) {
+	for _, file := range pullRequestTemplateFiles {
+		content, _, _, err := gh.Repositories.GetContents(context.Background(), owner, repository, file, &github.RepositoryContentGetOptions{Ref: ref})
+		if err != nil {
+			if errResp, ok := err.(*github.ErrorResponse); ok && errResp.Response.StatusCode == http.StatusNotFound {
+				continue
+			}
+			return "", errors.Wrapf(err, "failed to get %s", file)
+		}
+		return content.GetContent()
+	}
+	return "", nil
+}
+
+// stripPullRequestTemplate removes HTML comments and all lines of the
+// template which were left unchanged from the body. Checked boxes count as
+// unchanged.
+func stripPullRequestTemplate(body, prTemplate string) string {
+	normalize := func(line string) string {
+		return checkedBoxRE.ReplaceAllString(strings.TrimSpace(line), "[ ]")
+	}
+
+	boilerplate := make(map[string]bool)
+	for _, line := range strings.Split(htmlCommentRE.ReplaceAllString(prTemplate, ""), "\n") {
+		if line = normalize(line); line != "" {
+			boilerplate[line] = true
+		}
+	}
+
+	var kept []string
+	for _, line := range strings.Split(htmlCommentRE.ReplaceAllString(strings.Replace(body, "\r\n", "\n", -1), ""), "\n") {
+		if !boilerplate[normalize(line)] {
+			kept = append(kept, strings.TrimRight(line, " \t"))
+		}
+	}
+	return strings.TrimSpace(blankLinesRE.ReplaceAllString(strings.Join(kept, "\n"), "\n\n"))
+}
+
+// coAuthorTrailers returns a Co-authored-by trailer for every author of the
+// pull request's commits other than the pull request's author.
+func coAuthorTrailers(gh *github.Client, owner, repository string, pr *github.PullRequest) ([]string, error) {
+	commits, err := listAllCommits(gh, owner, repository, pr.GetNumber())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list commits of pull request %d", pr.GetNumber())
+	}
+
+	var trailers []string
+	seen := make(map[string]bool)
+	for _, commit := range commits {
+		if commit.GetAuthor().GetLogin() != "" && commit.GetAuthor().GetLogin() == pr.GetUser().GetLogin() {
+			continue
+		}
+		author := commit.GetCommit().GetAuthor()
+		email := strings.ToLower(author.GetEmail())
+		if email == "" || seen[email] {
+			continue
+		}
+		seen[email] = true
+		trailers = append(trailers, "Co-authored-by: "+author.GetName()+" <"+author.GetEmail()+">")
+	}
+	return trailers, nil
+}
@@ -0,0 +1,51 @@
+package webhook
+
+import (
+	"testing"
+
+	"github.com/google/go-github/github"
+
+	"github.com/syndesisio/pure-bot/pkg/config"
+)
+
+func TestSelectMergeMethod(t *testing.T) {
+	repo := &github.Repository{
+		AllowMergeCommit: github.Bool(false),
+		AllowSquashMerge: github.Bool(true),
+		AllowRebaseMerge: github.Bool(true),
+	}
+
+	if method := selectMergeMethod(config.MergeMethodRebase, repo); method != config.MergeMethodRebase {
+		t.Errorf("allowed method not used: %s", method)
+	}
+	if method := selectMergeMethod(config.MergeMethodMerge, repo); method != config.MergeMethodSquash {
+		t.Errorf("expected fallback to squash, got %s", method)
+	}
+}
+
+func TestStripPullRequestTemplate(t *testing.T) {
+	prTemplate := "## Description\n\n<!-- What does this change? -->\n\n## Checklist\n\n- [ ] Tests added\n- [ ] Docs updated\n"
+	body := "## Description\r\n\r\n<!-- What does this change? -->\r\nAdds the frobnicator.\r\n\r\n## Checklist\r\n\r\n- [x] Tests added\r\n- [ ] Docs updated\r\n\r\nFixes #12"
+
+	expected := "Adds the frobnicator.\n\nFixes #12"
+	if stripped := stripPullRequestTemplate(body, prTemplate); stripped != expected {
+		t.Errorf("expected %q, got %q", expected, stripped)
+	}
+}
+
+func TestRenderMergeTemplate(t *testing.T) {
+	message := &mergeMessage{
+		Title:     "Add frobnicator",
+		Number:    42,
+		Approvers: []string{"alice", "bob"},
+		Issues:    []string{"#12"},
+	}
+
+	rendered, err := renderMergeTemplate("commitTitle", `{{.Title}} (#{{.Number}}) {{join .Issues ", "}} by {{join .Approvers ", "}}`, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "Add frobnicator (#42) #12 by alice, bob"; rendered != expected {
+		t.Errorf("expected %q, got %q", expected, rendered)
+	}
+}
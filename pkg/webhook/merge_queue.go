@@ -0,0 +1,585 @@
+// Copyright © 2017 Syndesis Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	"github.com/syndesisio/pure-bot/pkg/config"
+)
+
+const (
+	mergeQueueContext = "pure-bot/merge-queue"
+
+	// Updating a pull request's branch is still a preview API
+	updateBranchPreview = "application/vnd.github.lydian-preview+json"
+)
+
+// States of a pull request in a merge queue
+const (
+	mergeQueueQueued   = "queued"
+	mergeQueueUpdating = "updating"
+	mergeQueueWaiting  = "waiting"
+)
+
+type mergeQueueEntry struct {
+	number     int
+	headSHA    string
+	enqueuedAt time.Time
+	// firstSince is when the pull request became the first of the queue
+	firstSince time.Time
+	// updatedFrom is the head the last branch update was requested for
+	updatedFrom string
+	state       string
+	// description is the last one reported in the merge queue status
+	description string
+}
+
+// mergeQueue holds the approved pull requests of a base branch in the order
+// they are merged. Only the first pull request is worked on: it is updated
+// from the base branch if it is behind, and merged once its checks passed
+// on the updated head.
+type mergeQueue struct {
+	repo    string
+	base    string
+	entries []*mergeQueueEntry
+	// failed maps pull requests removed because of failed checks to their
+	// head SHA, so they are only queued again after a new push
+	failed map[int]string
+	// processing serializes the work on the first pull request
+	processing sync.Mutex
+}
+
+// mergeQueues holds the queue of every base branch, guarded by the embedded
+// mutex. The queues are only kept in memory: after a restart pull requests
+// are queued again by the next event about them.
+var mergeQueues = struct {
+	sync.Mutex
+	byBranch map[string]*mergeQueue
+}{byBranch: make(map[string]*mergeQueue)}
+
+func mergeQueueFor(owner, repository, base string) *mergeQueue {
+	repo := owner + "/" + repository
+	key := strings.ToLower(repo) + ":" + base
+
+	mergeQueues.Lock()
+	defer mergeQueues.Unlock()
+	q, ok := mergeQueues.byBranch[key]
+	if !ok {
+		q = &mergeQueue{repo: repo, base: base, failed: make(map[int]string)}
+		mergeQueues.byBranch[key] = q
+	}
+	return q
+}
+
+// enqueue appends the pull request unless it is queued already and returns
+// its position, starting at 1. It returns 0 if the checks of its head failed
+// in the queue before.
+func (q *mergeQueue) enqueue(pr *github.PullRequest) int {
+	mergeQueues.Lock()
+	defer mergeQueues.Unlock()
+
+	for i, e := range q.entries {
+		if e.number == pr.GetNumber() {
+			e.headSHA = pr.Head.GetSHA()
+			return i + 1
+		}
+	}
+	if sha, ok := q.failed[pr.GetNumber()]; ok && sha == pr.Head.GetSHA() {
+		return 0
+	}
+	delete(q.failed, pr.GetNumber())
+
+	q.entries = append(q.entries, &mergeQueueEntry{
+		number:     pr.GetNumber(),
+		headSHA:    pr.Head.GetSHA(),
+		enqueuedAt: time.Now(),
+		state:      mergeQueueQueued,
+	})
+	return len(q.entries)
+}
+
+// remove removes the pull request and reports whether it was queued.
+func (q *mergeQueue) remove(number int) bool {
+	mergeQueues.Lock()
+	defer mergeQueues.Unlock()
+
+	for i, e := range q.entries {
+		if e.number == number {
+			q.entries = append(q.entries[:i], q.entries[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// fail removes the pull request and keeps it from being queued again until
+// its head changes.
+func (q *mergeQueue) fail(number int, headSHA string) {
+	q.remove(number)
+
+	mergeQueues.Lock()
+	defer mergeQueues.Unlock()
+	q.failed[number] = headSHA
+}
+
+func (q *mergeQueue) first() (int, bool) {
+	mergeQueues.Lock()
+	defer mergeQueues.Unlock()
+
+	if len(q.entries) == 0 {
+		return 0, false
+	}
+	if q.entries[0].firstSince.IsZero() {
+		q.entries[0].firstSince = time.Now()
+	}
+	return q.entries[0].number, true
+}
+
+// firstFor returns for how long the pull request is the first of the queue,
+// or 0 if it isn't.
+func (q *mergeQueue) firstFor(number int, now time.Time) time.Duration {
+	mergeQueues.Lock()
+	defer mergeQueues.Unlock()
+
+	if len(q.entries) == 0 || q.entries[0].number != number || q.entries[0].firstSince.IsZero() {
+		return 0
+	}
+	return now.Sub(q.entries[0].firstSince)
+}
+
+// restartFirst restarts the time the pull request is the first of the queue,
+// e.g. while merges are frozen.
+func (q *mergeQueue) restartFirst(number int) {
+	mergeQueues.Lock()
+	defer mergeQueues.Unlock()
+
+	if len(q.entries) > 0 && q.entries[0].number == number {
+		q.entries[0].firstSince = time.Now()
+	}
+}
+
+// setState records the state of the pull request with the given head.
+func (q *mergeQueue) setState(number int, headSHA, state string) {
+	mergeQueues.Lock()
+	defer mergeQueues.Unlock()
+
+	for _, e := range q.entries {
+		if e.number == number {
+			e.headSHA, e.state = headSHA, state
+		}
+	}
+}
+
+// startUpdate records that the branch of the pull request is updated from
+// the given head. It returns false if an update from that head was already
+// requested, so the pull request waits for the new head. Events don't change
+// the recorded head, so a pull request which is still behind after its update
+// is updated again.
+func (q *mergeQueue) startUpdate(number int, headSHA string) bool {
+	mergeQueues.Lock()
+	defer mergeQueues.Unlock()
+
+	for _, e := range q.entries {
+		if e.number == number {
+			if e.updatedFrom == headSHA {
+				return false
+			}
+			e.headSHA, e.updatedFrom, e.state = headSHA, headSHA, mergeQueueUpdating
+			return true
+		}
+	}
+	return false
+}
+
+// report sets the merge queue status of the pull request's head, unless it
+// was already reported for a queued pull request.
+func (q *mergeQueue) report(number int, headSHA string, status commitStatus, description string, owner, repository string, gh *github.Client) error {
+	mergeQueues.Lock()
+	var entry *mergeQueueEntry
+	for _, e := range q.entries {
+		if e.number == number && e.headSHA == headSHA {
+			entry = e
+		}
+	}
+	if entry != nil && entry.description == description {
+		mergeQueues.Unlock()
+		return nil
+	}
+	mergeQueues.Unlock()
+
+	if _, _, err := gh.Repositories.CreateStatus(context.Background(), owner, repository, headSHA, &github.RepoStatus{
+		State:       github.String(string(status)),
+		Context:     github.String(mergeQueueContext),
+		Description: github.String(description),
+	}); err != nil {
+		return errors.Wrapf(err, "failed to set %s status of pull request %d", mergeQueueContext, number)
+	}
+
+	if entry != nil {
+		mergeQueues.Lock()
+		entry.description = description
+		mergeQueues.Unlock()
+	}
+	return nil
+}
+
+// reportPositions updates the status of all pull requests waiting behind the
+// first one.
+func (q *mergeQueue) reportPositions(owner, repository string, gh *github.Client) error {
+	type waiting struct {
+		number  int
+		headSHA string
+	}
+
+	mergeQueues.Lock()
+	var entries []waiting
+	for i, e := range q.entries {
+		if i > 0 {
+			entries = append(entries, waiting{e.number, e.headSHA})
+		}
+	}
+	mergeQueues.Unlock()
+
+	var err error
+	for i, e := range entries {
+		err = multierr.Append(err, q.report(e.number, e.headSHA, pendingStatus, q.positionDescription(i+2), owner, repository, gh))
+	}
+	return err
+}
+
+func (q *mergeQueue) positionDescription(position int) string {
+	return fmt.Sprintf("Position %d in the merge queue of %s", position, q.base)
+}
+
+// queueMerge adds the pull request to the merge queue of its base branch and
+// works on the queue.
+func queueMerge(pr *github.PullRequest, owner, repository string, gh *github.Client, config config.RepoConfig, logger *zap.Logger) error {
+	q := mergeQueueFor(owner, repository, pr.Base.GetRef())
+	position := q.enqueue(pr)
+	switch {
+	case position == 0:
+		logger.Debug("Not queueing pull request again before its checks are rerun", zap.Int("pr", pr.GetNumber()), zap.String("sha", pr.Head.GetSHA()))
+		return nil
+	case position > 1:
+		logger.Debug("Pull request is waiting in the merge queue", zap.Int("pr", pr.GetNumber()), zap.Int("position", position))
+		return q.report(pr.GetNumber(), pr.Head.GetSHA(), pendingStatus, q.positionDescription(position), owner, repository, gh)
+	}
+	return processMergeQueue(q, owner, repository, gh, config, logger)
+}
+
+// dequeueMerge removes a closed or no longer approved pull request from its
+// merge queue and moves on to the next one.
+func dequeueMerge(pr *github.PullRequest, owner, repository string, gh *github.Client, config config.RepoConfig, logger *zap.Logger) error {
+	q := mergeQueueFor(owner, repository, pr.Base.GetRef())
+	if !q.remove(pr.GetNumber()) {
+		return nil
+	}
+	logger.Info("Removed pull request from the merge queue", zap.String("repo", q.repo), zap.String("base", q.base), zap.Int("pr", pr.GetNumber()))
+
+	var err error
+	if pr.GetState() == "open" {
+		err = q.report(pr.GetNumber(), pr.Head.GetSHA(), successStatus, "Not in the merge queue", owner, repository, gh)
+	}
+	return multierr.Append(err, processMergeQueue(q, owner, repository, gh, config, logger))
+}
+
+// processMergeQueuesOf works on the merge queues of the repository holding a
+// pull request with the given head, e.g. when one of its checks failed, so
+// that the first pull request is removed instead of blocking its queue.
+func processMergeQueuesOf(owner, repository, headSHA string, gh *github.Client, config config.RepoConfig, logger *zap.Logger) error {
+	mergeQueues.Lock()
+	var queues []*mergeQueue
+	for _, q := range mergeQueues.byBranch {
+		if !strings.EqualFold(q.repo, owner+"/"+repository) {
+			continue
+		}
+		for _, e := range q.entries {
+			if e.headSHA == headSHA {
+				queues = append(queues, q)
+				break
+			}
+		}
+	}
+	mergeQueues.Unlock()
+
+	var err error
+	for _, q := range queues {
+		err = multierr.Append(err, processMergeQueue(q, owner, repository, gh, config, logger))
+	}
+	return err
+}
+
+// leavesMergeQueue tells whether the event takes the pull request out of the
+// merge queue.
+func leavesMergeQueue(event *github.PullRequestEvent, config config.RepoConfig) bool {
+	switch strings.ToLower(event.GetAction()) {
+	case "closed":
+		return true
+	case "unlabeled":
+		return strings.EqualFold(event.Label.GetName(), config.Labels.Approved)
+	}
+	return false
+}
+
+// processMergeQueue works on the first pull request of the queue until it
+// has to wait for a branch update or checks, or the queue is empty.
+func processMergeQueue(q *mergeQueue, owner, repository string, gh *github.Client, config config.RepoConfig, logger *zap.Logger) error {
+	q.processing.Lock()
+	defer q.processing.Unlock()
+
+	var err error
+	for {
+		number, ok := q.first()
+		if !ok {
+			break
+		}
+		done, processErr := q.processFirst(number, owner, repository, gh, config, logger)
+		err = multierr.Append(err, processErr)
+		if !done {
+			break
+		}
+	}
+	return multierr.Append(err, q.reportPositions(owner, repository, gh))
+}
+
+// processFirst moves the first pull request of the queue on and reports
+// whether it left the queue.
+func (q *mergeQueue) processFirst(number int, owner, repository string, gh *github.Client, config config.RepoConfig, logger *zap.Logger) (bool, error) {
+	logger = logger.With(zap.String("repo", q.repo), zap.String("base", q.base), zap.Int("pr", number))
+
+	pr, _, err := gh.PullRequests.Get(context.Background(), owner, repository, number)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to get pull request %d", number)
+	}
+	if pr.GetState() != "open" || pr.Base.GetRef() != q.base || !labelsContainsLabel(pr.Labels, config.Labels.Approved) {
+		logger.Info("Removed pull request from the merge queue", zap.String("state", pr.GetState()))
+		q.remove(number)
+		return true, nil
+	}
+	headSHA := pr.Head.GetSHA()
+
+	// Frozen pull requests are evaluated again when the freeze ends
+	if frozen, err := checkMergeFreeze(pr, owner, repository, gh, config, logger); err != nil || frozen {
+		if frozen {
+			q.restartFirst(number)
+		}
+		return false, err
+	}
+
+	if timeout := config.AutoMerge.GetQueueTimeout(); timeout > 0 && q.firstFor(number, time.Now()) > timeout {
+		logger.Info("Removed pull request from the merge queue", zap.Duration("timeout", timeout))
+		q.remove(number)
+		return true, q.report(number, headSHA, failureStatus, fmt.Sprintf("Removed from the merge queue, not merged within %s", timeout), owner, repository, gh)
+	}
+
+	mergeable, reason, err := checkMergeable(pr, owner, repository, gh, config, logger)
+	if err != nil {
+		return false, err
+	}
+	if mergeable == mergeUnknown {
+		logger.Debug("Waiting for the mergeability of the pull request")
+		q.setState(number, headSHA, mergeQueueWaiting)
+		return false, nil
+	}
+	if reason != "" {
+		logger.Info("Removed pull request from the merge queue", zap.String("reason", reason))
+		q.remove(number)
+		return true, q.report(number, headSHA, failureStatus, "Removed from the merge queue, "+reason, owner, repository, gh)
+	}
+
+	comparison, _, err := gh.Repositories.CompareCommits(context.Background(), owner, repository, q.base, headSHA)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to compare pull request %s with %s", pr.GetHTMLURL(), q.base)
+	}
+	// In a dry run the branch is never updated, so the pull request is
+	// evaluated as it is to let the queue move on
+	if comparison.GetBehindBy() > 0 && config.IsDryRun() {
+		logger.Info("Dry run: not updating the branch of the pull request", zap.Int("behindBy", comparison.GetBehindBy()))
+	} else if comparison.GetBehindBy() > 0 {
+		if !q.startUpdate(number, headSHA) {
+			logger.Debug("Waiting for the branch update of the pull request")
+			return false, nil
+		}
+		logger.Info("Updating the branch of the pull request", zap.Int("behindBy", comparison.GetBehindBy()))
+		if err := updateBranch(pr, owner, repository, gh); err != nil {
+			q.fail(number, headSHA)
+			return true, multierr.Append(err, q.report(number, headSHA, failureStatus, "Removed from the merge queue, updating the branch failed", owner, repository, gh))
+		}
+		return false, q.report(number, headSHA, pendingStatus, "Updating the branch with "+q.base, owner, repository, gh)
+	}
+
+	result, failedContext, err := evaluateContexts(pr, owner, repository, gh, logger)
+	if err != nil {
+		return false, err
+	}
+	switch result {
+	case contextsPending:
+		autoMergeResults.Inc("checks_pending")
+		q.setState(number, headSHA, mergeQueueWaiting)
+		return false, q.report(number, headSHA, pendingStatus, "Next to merge into "+q.base+", waiting for checks", owner, repository, gh)
+	case contextsFailed:
+		logger.Info("Removed pull request from the merge queue", zap.String("failed", failedContext))
+		q.fail(number, headSHA)
+		return true, q.report(number, headSHA, failureStatus, "Removed from the merge queue, "+failedContext+" failed", owner, repository, gh)
+	}
+
+	if err := q.report(number, headSHA, successStatus, "Merging into "+q.base, owner, repository, gh); err != nil {
+		return false, err
+	}
+	q.remove(number)
+	if err := mergePullRequest(pr, owner, repository, gh, config, logger); err != nil {
+		q.fail(number, headSHA)
+		return true, multierr.Append(err, q.report(number, headSHA, failureStatus, "Removed from the merge queue, merging failed", owner, repository, gh))
+	}
+	return true, afterMerge(pr, owner, repository, gh, config, logger)
+}
+
+// updateBranch merges the base branch into the pull request's branch, unless
+// the head changed in the meantime.
+func updateBranch(pr *github.PullRequest, owner, repository string, gh *github.Client) error {
+	u := fmt.Sprintf("repos/%s/%s/pulls/%d/update-branch", owner, repository, pr.GetNumber())
+	req, err := gh.NewRequest(http.MethodPut, u, map[string]string{"expected_head_sha": pr.Head.GetSHA()})
+	if err != nil {
+		return errors.Wrap(err, "failed to create update branch request")
+	}
+	req.Header.Set("Accept", updateBranchPreview)
+
+	if _, err := gh.Do(context.Background(), req, nil); err != nil {
+		if _, accepted := err.(*github.AcceptedError); !accepted {
+			return errors.Wrapf(err, "failed to update branch of pull request %s", pr.GetHTMLURL())
+		}
+	}
+	return nil
+}
+
+// RunMergeQueueTimeouts removes the first pull request of every merge queue
+// which exceeded autoMerge.queueTimeout every interval until stop is closed,
+// using the active configuration of store.
+func RunMergeQueueTimeouts(store *config.Store, interval time.Duration, stop <-chan struct{}, logger *zap.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			expireMergeQueues(store.Get(), time.Now(), logger)
+		}
+	}
+}
+
+// expireMergeQueues works on every merge queue whose first pull request
+// exceeded the timeout, which removes it and moves on to the next one.
+// Queues without events would otherwise wait forever.
+func expireMergeQueues(cfg config.Config, now time.Time, logger *zap.Logger) {
+	mergeQueues.Lock()
+	var queues []*mergeQueue
+	for _, q := range mergeQueues.byBranch {
+		if len(q.entries) > 0 && !q.entries[0].firstSince.IsZero() {
+			queues = append(queues, q)
+		}
+	}
+	mergeQueues.Unlock()
+
+	for _, q := range queues {
+		slash := strings.Index(q.repo, "/")
+		owner, name := q.repo[:slash], q.repo[slash+1:]
+		repoConfig := cachedRepoConfig(cfg, owner, name)
+		if repoConfig.IsDisabled() || !repoConfig.HandlerEnabled("autoMerge", true) {
+			continue
+		}
+		number, ok := q.first()
+		timeout := repoConfig.AutoMerge.GetQueueTimeout()
+		if !ok || timeout <= 0 || q.firstFor(number, now) <= timeout {
+			continue
+		}
+
+		queueLogger := logger.With(zap.String("repo", q.repo), zap.String("base", q.base))
+		gh, err := installationClient(cfg, q.repo, repoConfig, queueLogger)
+		if err != nil {
+			queueLogger.Error("Failed to create GitHub client to expire merge queue", zap.Error(err))
+			continue
+		}
+		if gh == nil {
+			continue
+		}
+		if err := processMergeQueue(q, owner, name, gh, repoConfig, queueLogger); err != nil {
+			queueLogger.Warn("Failed to process expired merge queue", zap.Error(err))
+		}
+	}
+}
+
+type mergeQueueReport struct {
+	Repo         string                  `json:"repo"`
+	Base         string                  `json:"base"`
+	PullRequests []mergeQueueEntryReport `json:"pullRequests"`
+}
+
+type mergeQueueEntryReport struct {
+	Number     int       `json:"number"`
+	HeadSHA    string    `json:"headSha"`
+	State      string    `json:"state"`
+	EnqueuedAt time.Time `json:"enqueuedAt"`
+}
+
+// MergeQueueHandler serves all non-empty merge queues as JSON, optionally
+// only those of the repository given by the `repo` query parameter.
+func MergeQueueHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		repo := r.URL.Query().Get("repo")
+
+		reports := []mergeQueueReport{}
+		mergeQueues.Lock()
+		for _, q := range mergeQueues.byBranch {
+			if len(q.entries) == 0 || (repo != "" && !strings.EqualFold(repo, q.repo)) {
+				continue
+			}
+			report := mergeQueueReport{Repo: q.repo, Base: q.base}
+			for _, e := range q.entries {
+				report.PullRequests = append(report.PullRequests, mergeQueueEntryReport{
+					Number:     e.number,
+					HeadSHA:    e.headSHA,
+					State:      e.state,
+					EnqueuedAt: e.enqueuedAt,
+				})
+			}
+			reports = append(reports, report)
+		}
+		mergeQueues.Unlock()
+
+		sort.Slice(reports, func(i, j int) bool {
+			if reports[i].Repo != reports[j].Repo {
+				return reports[i].Repo < reports[j].Repo
+			}
+			return reports[i].Base < reports[j].Base
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reports)
+	})
+}
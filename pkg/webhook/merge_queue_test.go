@@ -0,0 +1,276 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+	"go.uber.org/zap"
+
+	"github.com/syndesisio/pure-bot/pkg/config"
+)
+
+func TestMergeQueue(t *testing.T) {
+	mergeQueues.byBranch = make(map[string]*mergeQueue)
+
+	heads := map[string]string{"1": "a1", "2": "b1"}
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && (r.URL.Path == "/repos/o/r/pulls/1" || r.URL.Path == "/repos/o/r/pulls/2"):
+			number := r.URL.Path[len(r.URL.Path)-1:]
+			w.Write([]byte(`{"number": ` + number + `, "state": "open", "labels": [{"name": "approved"}], "head": {"sha": "` + heads[number] + `"}, "base": {"ref": "main"}}`))
+		case r.URL.Path == "/repos/o/r/compare/main...a2":
+			w.Write([]byte(`{"behind_by": 0}`))
+		case strings.HasPrefix(r.URL.Path, "/repos/o/r/compare/"):
+			w.Write([]byte(`{"behind_by": 1}`))
+		case r.URL.Path == "/repos/o/r/commits/a2/status":
+			w.Write([]byte(`{"statuses": [{"context": "ci", "state": "success"}, {"context": "pure-bot/merge-queue", "state": "pending"}]}`))
+		case strings.HasSuffix(r.URL.Path, "/status"):
+			w.Write([]byte(`{"statuses": []}`))
+		case r.URL.Path == "/repos/o/r/commits/a2/check-runs":
+			w.Write([]byte(`{"check_runs": []}`))
+		case r.URL.Path == "/repos/o/r/branches/main/protection/required_status_checks/contexts":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{}`))
+		case r.Method == http.MethodPost:
+			var status github.RepoStatus
+			body, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(body, &status)
+			requests = append(requests, r.URL.Path+" "+status.GetDescription())
+			w.Write([]byte(`{}`))
+		default:
+			requests = append(requests, r.Method+" "+r.URL.Path)
+			if r.URL.Path == "/repos/o/r/pulls/1/update-branch" || r.URL.Path == "/repos/o/r/pulls/2/update-branch" {
+				w.WriteHeader(http.StatusAccepted)
+			}
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	repoConfig := config.RepoConfig{
+		Labels:    config.LabelConfig{Approved: "approved"},
+		AutoMerge: config.AutoMergeConfig{Queue: config.Bool(true)},
+	}
+	pr := func(number int, sha string) *github.PullRequest {
+		return &github.PullRequest{Number: github.Int(number), Head: &github.PullRequestBranch{SHA: github.String(sha)}, Base: &github.PullRequestBranch{Ref: github.String("main")}}
+	}
+
+	// The first pull request is behind its base and gets updated, the second one waits
+	if err := queueMerge(pr(1, "a1"), "o", "r", client, repoConfig, zap.NewNop()); err != nil {
+		t.Fatal(err)
+	}
+	if err := queueMerge(pr(2, "b1"), "o", "r", client, repoConfig, zap.NewNop()); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"/repos/o/r/statuses/a1 OK - nothing blocks merging once all checks passed",
+		"PUT /repos/o/r/pulls/1/update-branch",
+		"/repos/o/r/statuses/a1 Updating the branch with main",
+		"/repos/o/r/statuses/b1 Position 2 in the merge queue of main",
+	}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("expected requests %v, got %v", expected, requests)
+	}
+
+	recorder := httptest.NewRecorder()
+	MergeQueueHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/merge-queue?repo=o/r", nil))
+	var reports []mergeQueueReport
+	if err := json.Unmarshal(recorder.Body.Bytes(), &reports); err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || len(reports[0].PullRequests) != 2 || reports[0].PullRequests[0].State != mergeQueueUpdating {
+		t.Errorf("unexpected merge queue report %+v", reports)
+	}
+
+	// Once its checks passed on the updated head it is merged and the second one is updated
+	requests = nil
+	heads["1"] = "a2"
+	if err := queueMerge(pr(1, "a2"), "o", "r", client, repoConfig, zap.NewNop()); err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{
+		"/repos/o/r/statuses/a2 OK - nothing blocks merging once all checks passed",
+		"/repos/o/r/statuses/a2 Merging into main",
+		"PUT /repos/o/r/pulls/1/merge",
+		"/repos/o/r/statuses/b1 OK - nothing blocks merging once all checks passed",
+		"PUT /repos/o/r/pulls/2/update-branch",
+		"/repos/o/r/statuses/b1 Updating the branch with main",
+	}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("expected requests %v, got %v", expected, requests)
+	}
+
+	// The second one is removed once it waited for longer than the timeout
+	requests = nil
+	q := mergeQueueFor("o", "r", "main")
+	q.entries[0].firstSince = time.Now().Add(-2 * time.Hour)
+	repoConfig.AutoMerge.QueueTimeout = config.Duration(time.Hour)
+	if err := processMergeQueue(q, "o", "r", client, repoConfig, zap.NewNop()); err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{"/repos/o/r/statuses/b1 Removed from the merge queue, not merged within 1h0m0s"}
+	if !reflect.DeepEqual(requests, expected) || len(q.entries) != 0 {
+		t.Errorf("expected requests %v and an empty queue, got %v and %d entries", expected, requests, len(q.entries))
+	}
+}
+
+func TestMergeQueueUpdatesBranchAgainWhileBehind(t *testing.T) {
+	mergeQueues.byBranch = make(map[string]*mergeQueue)
+
+	head := "a1"
+	var updates []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/o/r/pulls/1":
+			w.Write([]byte(`{"number": 1, "state": "open", "labels": [{"name": "approved"}], "head": {"sha": "` + head + `"}, "base": {"ref": "main"}}`))
+		case strings.HasPrefix(r.URL.Path, "/repos/o/r/compare/"):
+			// The base moves on while the branch is updated
+			w.Write([]byte(`{"behind_by": 1}`))
+		case r.URL.Path == "/repos/o/r/pulls/1/update-branch":
+			body, _ := ioutil.ReadAll(r.Body)
+			updates = append(updates, string(body))
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(`{}`))
+		case strings.HasSuffix(r.URL.Path, "/status"):
+			w.Write([]byte(`{"statuses": []}`))
+		case strings.HasSuffix(r.URL.Path, "/contexts"):
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	repoConfig := config.RepoConfig{
+		Labels:    config.LabelConfig{Approved: "approved"},
+		AutoMerge: config.AutoMergeConfig{Queue: config.Bool(true)},
+	}
+	pr := func(sha string) *github.PullRequest {
+		return &github.PullRequest{Number: github.Int(1), Head: &github.PullRequestBranch{SHA: github.String(sha)}, Base: &github.PullRequestBranch{Ref: github.String("main")}}
+	}
+
+	for _, sha := range []string{"a1", "a1", "a2", "a2"} {
+		head = sha
+		if err := queueMerge(pr(sha), "o", "r", client, repoConfig, zap.NewNop()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := []string{`{"expected_head_sha":"a1"}` + "\n", `{"expected_head_sha":"a2"}` + "\n"}
+	if !reflect.DeepEqual(updates, expected) {
+		t.Errorf("expected one update per head %q, got %q", expected, updates)
+	}
+}
+
+func TestMergeQueueRemovesFirstOnFailure(t *testing.T) {
+	mergeQueues.byBranch = make(map[string]*mergeQueue)
+
+	var statuses []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/o/r/pulls/1":
+			w.Write([]byte(`{"number": 1, "state": "open", "labels": [{"name": "approved"}], "head": {"sha": "a1"}, "base": {"ref": "main"}}`))
+		case strings.HasPrefix(r.URL.Path, "/repos/o/r/compare/"):
+			w.Write([]byte(`{"behind_by": 0}`))
+		case r.URL.Path == "/repos/o/r/commits/a1/status":
+			w.Write([]byte(`{"statuses": [{"context": "ci", "state": "failure"}]}`))
+		case strings.HasSuffix(r.URL.Path, "/contexts"):
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{}`))
+		case r.Method == http.MethodPost:
+			var status github.RepoStatus
+			body, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(body, &status)
+			statuses = append(statuses, r.URL.Path+" "+status.GetDescription())
+			w.Write([]byte(`{}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	repoConfig := config.RepoConfig{
+		Labels:    config.LabelConfig{Approved: "approved"},
+		AutoMerge: config.AutoMergeConfig{Queue: config.Bool(true)},
+	}
+	q := mergeQueueFor("o", "r", "main")
+	q.enqueue(&github.PullRequest{Number: github.Int(1), Head: &github.PullRequestBranch{SHA: github.String("a1")}})
+
+	event := &github.StatusEvent{
+		SHA:   github.String("a1"),
+		State: github.String("failure"),
+		Repo:  &github.Repository{Name: github.String("r"), Owner: &github.User{Login: github.String("o")}},
+	}
+	if err := (&autoMerger{}).handleStatusEvent(event, client, repoConfig, zap.NewNop()); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"/repos/o/r/statuses/a1 OK - nothing blocks merging once all checks passed",
+		"/repos/o/r/statuses/a1 Removed from the merge queue, ci failed",
+	}
+	if !reflect.DeepEqual(statuses, expected) || len(q.entries) != 0 {
+		t.Errorf("expected statuses %v and an empty queue, got %v and %d entries", expected, statuses, len(q.entries))
+	}
+}
+
+func TestMergeQueueSkipsBranchUpdateInDryRun(t *testing.T) {
+	mergeQueues.byBranch = make(map[string]*mergeQueue)
+
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/o/r/pulls/1":
+			w.Write([]byte(`{"number": 1, "state": "open", "labels": [{"name": "approved"}], "head": {"sha": "a1"}, "base": {"ref": "main"}}`))
+		case strings.HasPrefix(r.URL.Path, "/repos/o/r/compare/"):
+			w.Write([]byte(`{"behind_by": 1}`))
+		case r.URL.Path == "/repos/o/r/commits/a1/status":
+			w.Write([]byte(`{"statuses": [{"context": "ci", "state": "success"}]}`))
+		case strings.HasSuffix(r.URL.Path, "/contexts"):
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{}`))
+		case r.Method == http.MethodGet:
+			w.Write([]byte(`{}`))
+		default:
+			requests = append(requests, r.Method+" "+r.URL.Path)
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	repoConfig := config.RepoConfig{
+		DryRun:    config.Bool(true),
+		Labels:    config.LabelConfig{Approved: "approved"},
+		AutoMerge: config.AutoMergeConfig{Queue: config.Bool(true)},
+	}
+	pr := &github.PullRequest{Number: github.Int(1), Head: &github.PullRequestBranch{SHA: github.String("a1")}, Base: &github.PullRequestBranch{Ref: github.String("main")}}
+	if err := queueMerge(pr, "o", "r", client, repoConfig, zap.NewNop()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The merge is only logged by the dry run transport, which the test leaves out
+	expected := []string{
+		"POST /repos/o/r/statuses/a1",
+		"POST /repos/o/r/statuses/a1",
+		"PUT /repos/o/r/pulls/1/merge",
+	}
+	if !reflect.DeepEqual(requests, expected) || len(mergeQueueFor("o", "r", "main").entries) != 0 {
+		t.Errorf("expected requests %v and an empty queue, got %v", expected, requests)
+	}
+}
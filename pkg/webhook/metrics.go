@@ -0,0 +1,117 @@
+// Copyright © 2017 Syndesis Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/syndesisio/pure-bot/pkg/github/apps"
+	"github.com/syndesisio/pure-bot/pkg/metrics"
+)
+
+var (
+	duplicateDeliveries = metrics.NewCounterVec(
+		"purebot_webhook_duplicate_deliveries_total",
+		"Webhook deliveries skipped because their delivery ID has been seen before.",
+		"event",
+	)
+	receivedEvents = metrics.NewCounterVec(
+		"purebot_webhook_events_received_total",
+		"Webhook events received, by event type and action.",
+		"event", "action",
+	)
+	handlerInvocations = metrics.NewCounterVec(
+		"purebot_handler_invocations_total",
+		"Handler invocations, by handler and event type.",
+		"handler", "event",
+	)
+	handlerFailures = metrics.NewCounterVec(
+		"purebot_handler_failures_total",
+		"Handler invocations which returned an error, by handler and event type.",
+		"handler", "event",
+	)
+	handlerDuration = metrics.NewHistogramVec(
+		"purebot_handler_duration_seconds",
+		"Time spent in handlers, by handler and event type.",
+		metrics.DefaultBuckets,
+		"handler", "event",
+	)
+	githubRequests = metrics.NewCounterVec(
+		"purebot_github_api_requests_total",
+		"GitHub API requests, by installation, method and response status code.",
+		"installation", "method", "code",
+	)
+	githubRateLimitRemaining = metrics.NewGaugeVec(
+		"purebot_github_rate_limit_remaining",
+		"Remaining GitHub API requests in the current rate limit window, by installation.",
+		"installation",
+	)
+	zenhubRequests = metrics.NewCounterVec(
+		"purebot_zenhub_requests_total",
+		"ZenHub API requests, by operation and outcome.",
+		"operation", "outcome",
+	)
+	autoMergeResults = metrics.NewCounterVec(
+		"purebot_automerge_results_total",
+		"Outcome of auto-merge evaluations of approved pull requests.",
+		"result",
+	)
+)
+
+// eventAction returns the action of a webhook payload, or an empty string for
+// event types without actions.
+func eventAction(payload []byte) string {
+	var event struct {
+		Action string `json:"action"`
+	}
+	json.Unmarshal(payload, &event)
+	return event.Action
+}
+
+// zenhubOutcome classifies the result of a ZenHub API call.
+func zenhubOutcome(statusCode int, err error) string {
+	if err != nil {
+		return "error"
+	}
+	return strconv.Itoa(statusCode/100) + "xx"
+}
+
+// githubAPIMetrics counts the GitHub API requests made on behalf of an
+// installation and keeps track of its remaining rate limit.
+func githubAPIMetrics(installationID int64) apps.Middleware {
+	installation := strconv.FormatInt(installationID, 10)
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			resp, err := next.RoundTrip(req)
+			if err != nil {
+				githubRequests.Inc(installation, req.Method, "error")
+				return resp, err
+			}
+			githubRequests.Inc(installation, req.Method, strconv.Itoa(resp.StatusCode))
+			if remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining")); err == nil {
+				githubRateLimitRemaining.Set(float64(remaining), installation)
+			}
+			return resp, err
+		})
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
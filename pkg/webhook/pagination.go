@@ -0,0 +1,260 @@
+// Copyright © 2017 Syndesis Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/google/go-github/github"
+)
+
+// pageSize is the largest page the GitHub API returns.
+const pageSize = 100
+
+// paginate calls fetch until it returned the last page, setting the page of
+// opts before each call. fetch has to request opts and collect the results.
+func paginate(opts *github.ListOptions, fetch func() (*github.Response, error)) error {
+	if opts.PerPage == 0 {
+		opts.PerPage = pageSize
+	}
+	for {
+		resp, err := fetch()
+		if err != nil {
+			return err
+		}
+		if resp == nil || resp.NextPage == 0 {
+			return nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+func listAllReviews(gh *github.Client, owner, repository string, number int) ([]*github.PullRequestReview, error) {
+	var all []*github.PullRequestReview
+	opts := &github.ListOptions{}
+	err := paginate(opts, func() (*github.Response, error) {
+		reviews, resp, err := gh.PullRequests.ListReviews(context.Background(), owner, repository, number, opts)
+		all = append(all, reviews...)
+		return resp, err
+	})
+	return all, err
+}
+
+func listAllReviewers(gh *github.Client, owner, repository string, number int) (*github.Reviewers, error) {
+	all := &github.Reviewers{}
+	opts := &github.ListOptions{}
+	err := paginate(opts, func() (*github.Response, error) {
+		reviewers, resp, err := gh.PullRequests.ListReviewers(context.Background(), owner, repository, number, opts)
+		if reviewers != nil {
+			all.Users = append(all.Users, reviewers.Users...)
+			all.Teams = append(all.Teams, reviewers.Teams...)
+		}
+		return resp, err
+	})
+	return all, err
+}
+
+func listAllCommits(gh *github.Client, owner, repository string, number int) ([]*github.RepositoryCommit, error) {
+	var all []*github.RepositoryCommit
+	opts := &github.ListOptions{}
+	err := paginate(opts, func() (*github.Response, error) {
+		commits, resp, err := gh.PullRequests.ListCommits(context.Background(), owner, repository, number, opts)
+		all = append(all, commits...)
+		return resp, err
+	})
+	return all, err
+}
+
+func listAllFiles(gh *github.Client, owner, repository string, number int) ([]*github.CommitFile, error) {
+	var all []*github.CommitFile
+	opts := &github.ListOptions{}
+	err := paginate(opts, func() (*github.Response, error) {
+		files, resp, err := gh.PullRequests.ListFiles(context.Background(), owner, repository, number, opts)
+		all = append(all, files...)
+		return resp, err
+	})
+	return all, err
+}
+
+func listAllPullRequests(gh *github.Client, owner, repository string, opts github.PullRequestListOptions) ([]*github.PullRequest, error) {
+	var all []*github.PullRequest
+	err := paginate(&opts.ListOptions, func() (*github.Response, error) {
+		prs, resp, err := gh.PullRequests.List(context.Background(), owner, repository, &opts)
+		all = append(all, prs...)
+		return resp, err
+	})
+	return all, err
+}
+
+func listAllComments(gh *github.Client, owner, repository string, number int, opts github.IssueListCommentsOptions) ([]*github.IssueComment, error) {
+	var all []*github.IssueComment
+	err := paginate(&opts.ListOptions, func() (*github.Response, error) {
+		comments, resp, err := gh.Issues.ListComments(context.Background(), owner, repository, number, &opts)
+		all = append(all, comments...)
+		return resp, err
+	})
+	return all, err
+}
+
+func listAllLabels(gh *github.Client, owner, repository string, number int) ([]*github.Label, error) {
+	var all []*github.Label
+	opts := &github.ListOptions{}
+	err := paginate(opts, func() (*github.Response, error) {
+		labels, resp, err := gh.Issues.ListLabelsByIssue(context.Background(), owner, repository, number, opts)
+		all = append(all, labels...)
+		return resp, err
+	})
+	return all, err
+}
+
+func listAllMilestones(gh *github.Client, owner, repository string, opts github.MilestoneListOptions) ([]*github.Milestone, error) {
+	var all []*github.Milestone
+	err := paginate(&opts.ListOptions, func() (*github.Response, error) {
+		milestones, resp, err := gh.Issues.ListMilestones(context.Background(), owner, repository, &opts)
+		all = append(all, milestones...)
+		return resp, err
+	})
+	return all, err
+}
+
+// getCombinedStatus returns the combined status of ref with the statuses of
+// all pages.
+func getCombinedStatus(gh *github.Client, owner, repository, ref string) (*github.CombinedStatus, error) {
+	var combined *github.CombinedStatus
+	opts := &github.ListOptions{}
+	err := paginate(opts, func() (*github.Response, error) {
+		status, resp, err := gh.Repositories.GetCombinedStatus(context.Background(), owner, repository, ref, opts)
+		if status != nil {
+			if combined == nil {
+				combined = status
+			} else {
+				combined.Statuses = append(combined.Statuses, status.Statuses...)
+			}
+		}
+		return resp, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if combined == nil {
+		combined = &github.CombinedStatus{}
+	}
+	return combined, nil
+}
+
+func listAllCheckRuns(gh *github.Client, owner, repository, ref string) ([]*github.CheckRun, error) {
+	var all []*github.CheckRun
+	opts := &github.ListCheckRunsOptions{}
+	err := paginate(&opts.ListOptions, func() (*github.Response, error) {
+		result, resp, err := gh.Checks.ListCheckRunsForRef(context.Background(), owner, repository, ref, opts)
+		if result != nil {
+			all = append(all, result.CheckRuns...)
+		}
+		return resp, err
+	})
+	return all, err
+}
+
+func listAllCheckSuites(gh *github.Client, owner, repository, ref string) ([]*github.CheckSuite, error) {
+	var all []*github.CheckSuite
+	opts := &github.ListCheckSuiteOptions{}
+	err := paginate(&opts.ListOptions, func() (*github.Response, error) {
+		result, resp, err := gh.Checks.ListCheckSuitesForRef(context.Background(), owner, repository, ref, opts)
+		if result != nil {
+			all = append(all, result.CheckSuites...)
+		}
+		return resp, err
+	})
+	return all, err
+}
+
+// searchAllIssues returns all issues and pull requests found by query. The
+// search API returns at most 1000 results.
+func searchAllIssues(gh *github.Client, query string) ([]github.Issue, error) {
+	var all []github.Issue
+	opts := &github.SearchOptions{}
+	err := paginate(&opts.ListOptions, func() (*github.Response, error) {
+		result, resp, err := gh.Search.Issues(context.Background(), query, opts)
+		if result != nil {
+			all = append(all, result.Issues...)
+		}
+		return resp, err
+	})
+	return all, err
+}
+
+func listAllInstallations(gh *github.Client) ([]*github.Installation, error) {
+	var all []*github.Installation
+	opts := &github.ListOptions{}
+	err := paginate(opts, func() (*github.Response, error) {
+		installations, resp, err := gh.Apps.ListInstallations(context.Background(), opts)
+		all = append(all, installations...)
+		return resp, err
+	})
+	return all, err
+}
+
+// listAllInstallationRepos returns the repositories of the installation gh
+// is authenticated as.
+func listAllInstallationRepos(gh *github.Client) ([]*github.Repository, error) {
+	var all []*github.Repository
+	opts := &github.ListOptions{}
+	err := paginate(opts, func() (*github.Response, error) {
+		repos, resp, err := gh.Apps.ListRepos(context.Background(), opts)
+		all = append(all, repos...)
+		return resp, err
+	})
+	return all, err
+}
+
+// listAllReviewRequestEvents returns the events of the issue, decoded with
+// the requested reviewer the GitHub client doesn't know yet.
+func listAllReviewRequestEvents(gh *github.Client, owner, repository string, number int) ([]*reviewRequestEvent, error) {
+	var all []*reviewRequestEvent
+	opts := &github.ListOptions{}
+	err := paginate(opts, func() (*github.Response, error) {
+		u := fmt.Sprintf("repos/%s/%s/issues/%d/events?per_page=%d&page=%d", owner, repository, number, opts.PerPage, opts.Page)
+		req, err := gh.NewRequest(http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		var events []*reviewRequestEvent
+		resp, err := gh.Do(context.Background(), req, &events)
+		all = append(all, events...)
+		return resp, err
+	})
+	return all, err
+}
+
+// listAllTeamMembers looks the team up by its slug, which the Teams API of the
+// GitHub client doesn't support.
+func listAllTeamMembers(gh *github.Client, org, slug string) ([]*github.User, error) {
+	var all []*github.User
+	opts := &github.ListOptions{}
+	err := paginate(opts, func() (*github.Response, error) {
+		u := fmt.Sprintf("orgs/%s/teams/%s/members?per_page=%d&page=%d", org, slug, opts.PerPage, opts.Page)
+		req, err := gh.NewRequest(http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		var members []*github.User
+		resp, err := gh.Do(context.Background(), req, &members)
+		all = append(all, members...)
+		return resp, err
+	})
+	return all, err
+}
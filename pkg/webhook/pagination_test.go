@@ -0,0 +1,149 @@
+package webhook
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/google/go-github/github"
+	"go.uber.org/zap"
+)
+
+// pagedServer serves each path's pages, linking to the next page like GitHub
+// does. Pages are selected by the `page` query parameter, starting at 1. All
+// other paths are not found.
+func pagedServer(t *testing.T, pages map[string][]string) (*github.Client, func()) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bodies, ok := pages[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("per_page") != strconv.Itoa(pageSize) {
+			t.Errorf("expected %s to request %d per page", r.URL, pageSize)
+		}
+		page := 1
+		if p := r.URL.Query().Get("page"); p != "" {
+			page, _ = strconv.Atoi(p)
+		}
+		if page < len(bodies) {
+			next := *r.URL
+			q := next.Query()
+			q.Set("page", strconv.Itoa(page+1))
+			next.RawQuery = q.Encode()
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s%s>; rel="next"`, r.Host, next.RequestURI()))
+		}
+		w.Write([]byte(bodies[page-1]))
+	}))
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	return client, server.Close
+}
+
+func TestPaginationCollectsAllPages(t *testing.T) {
+	client, closeServer := pagedServer(t, map[string][]string{
+		"/repos/o/r/pulls/1/reviews":             {`[{"id": 1}, {"id": 2}]`, `[{"id": 3}]`},
+		"/repos/o/r/pulls/1/requested_reviewers": {`{"users": [{"login": "a"}], "teams": [{"slug": "t"}]}`, `{"users": [{"login": "b"}]}`},
+		"/repos/o/r/pulls/1/commits":             {`[{"sha": "a"}]`, `[{"sha": "b"}]`, `[{"sha": "c"}]`},
+		"/repos/o/r/issues/1/comments":           {`[{"body": "first"}]`, `[{"body": "second"}]`},
+		"/repos/o/r/issues/1/labels":             {`[{"name": "a"}]`, `[{"name": "b"}]`},
+		"/repos/o/r/commits/a/status":            {`{"state": "pending", "statuses": [{"context": "a"}]}`, `{"state": "pending", "statuses": [{"context": "b"}]}`},
+		"/repos/o/r/commits/a/check-runs":        {`{"total_count": 2, "check_runs": [{"name": "a"}]}`, `{"total_count": 2, "check_runs": [{"name": "b"}]}`},
+		"/search/issues":                         {`{"items": [{"number": 1}]}`, `{"items": [{"number": 2}]}`},
+	})
+	defer closeServer()
+
+	var got []string
+	reviews, err := listAllReviews(client, "o", "r", 1)
+	for _, r := range reviews {
+		got = append(got, strconv.FormatInt(r.GetID(), 10))
+	}
+	expectPages(t, "reviews", err, got, "1", "2", "3")
+
+	got = nil
+	reviewers, err := listAllReviewers(client, "o", "r", 1)
+	for _, u := range reviewers.Users {
+		got = append(got, u.GetLogin())
+	}
+	for _, team := range reviewers.Teams {
+		got = append(got, team.GetSlug())
+	}
+	expectPages(t, "reviewers", err, got, "a", "b", "t")
+
+	got = nil
+	commits, err := listAllCommits(client, "o", "r", 1)
+	for _, c := range commits {
+		got = append(got, c.GetSHA())
+	}
+	expectPages(t, "commits", err, got, "a", "b", "c")
+
+	got = nil
+	comments, err := listAllComments(client, "o", "r", 1, github.IssueListCommentsOptions{Sort: "updated"})
+	for _, c := range comments {
+		got = append(got, c.GetBody())
+	}
+	expectPages(t, "comments", err, got, "first", "second")
+
+	got = nil
+	labels, err := listAllLabels(client, "o", "r", 1)
+	for _, l := range labels {
+		got = append(got, l.GetName())
+	}
+	expectPages(t, "labels", err, got, "a", "b")
+
+	got = nil
+	status, err := getCombinedStatus(client, "o", "r", "a")
+	for _, s := range status.Statuses {
+		got = append(got, s.GetContext())
+	}
+	expectPages(t, "statuses", err, got, "a", "b")
+	if status.GetState() != "pending" {
+		t.Errorf("expected combined state of the first page, got %q", status.GetState())
+	}
+
+	got = nil
+	checkRuns, err := listAllCheckRuns(client, "o", "r", "a")
+	for _, c := range checkRuns {
+		got = append(got, c.GetName())
+	}
+	expectPages(t, "check runs", err, got, "a", "b")
+
+	got = nil
+	issues, err := searchAllIssues(client, "repo:o/r a")
+	for _, i := range issues {
+		got = append(got, strconv.Itoa(i.GetNumber()))
+	}
+	expectPages(t, "search", err, got, "1", "2")
+}
+
+func TestEvaluateContextsSeesAllPages(t *testing.T) {
+	client, closeServer := pagedServer(t, map[string][]string{
+		"/repos/o/r/commits/a/status":     {`{"statuses": [{"context": "a", "state": "success"}]}`, `{"statuses": [{"context": "b", "state": "failure"}]}`},
+		"/repos/o/r/commits/a/check-runs": {`{"check_runs": [{"name": "c", "conclusion": "success"}]}`, `{"check_runs": [{"name": "d", "conclusion": "success"}]}`},
+	})
+	defer closeServer()
+
+	pr := &github.PullRequest{Number: github.Int(1), Head: &github.PullRequestBranch{SHA: github.String("a")}, Base: &github.PullRequestBranch{Ref: github.String("main")}}
+	result, failed, err := evaluateContexts(pr, "o", "r", client, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != contextsFailed || failed != "b" {
+		t.Errorf("expected context b on the second page to fail, got %v %q", result, failed)
+	}
+}
+
+func expectPages(t *testing.T, name string, err error, got []string, expected ...string) {
+	if err != nil {
+		t.Errorf("%s: %v", name, err)
+		return
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("%s: expected %v, got %v", name, expected, got)
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright © 2017 Syndesis Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	"github.com/syndesisio/pure-bot/pkg/config"
+)
+
+// closingReference matches the keywords closing an issue followed by a
+// reference to it, which is #1, owner/repository#1 or the issue's URL
+var closingReference = regexp.MustCompile(`(?mi)(?:clos(?:e[sd]?|ing)|fix(?:e[sd]|ing))[^\s]*\s+(?:([\w.-]+/[\w.-]+)?#|https://github\.com/([^/\s]+/[^/\s]+)/issues/)([0-9]+)`)
+
+// afterMerge runs the configured post-merge actions on a pull request which
+// was just merged. All actions are tried even if one of them fails.
+func afterMerge(pr *github.PullRequest, owner, repository string, gh *github.Client, config config.RepoConfig, logger *zap.Logger) error {
+	actions := config.AutoMerge.PostMerge
+	logger = logger.With(zap.String("repo", owner+"/"+repository), zap.Int("pr", pr.GetNumber()))
+
+	var err error
+	if actions.GetDeleteBranch() {
+		err = multierr.Append(err, deleteHeadBranch(pr, owner, repository, gh, logger))
+	}
+	if actions.GetRemoveLabels() {
+		for _, label := range []string{config.Labels.Approved, config.Labels.ReviewRequested} {
+			err = multierr.Append(err, removeMergedLabel(pr, label, owner, repository, gh, logger))
+		}
+	}
+	if actions.GetSetMilestone() {
+		err = multierr.Append(err, setCurrentMilestone(pr, owner, repository, gh, logger))
+	}
+	return err
+}
+
+// deleteHeadBranch deletes the pull request's branch unless it belongs to a
+// fork, is the default branch, is protected or other open pull requests are
+// based on it, as GitHub would close them.
+func deleteHeadBranch(pr *github.PullRequest, owner, repository string, gh *github.Client, logger *zap.Logger) error {
+	branch := pr.Head.GetRef()
+	if pr.Head.GetRepo().GetID() != pr.Base.GetRepo().GetID() || branch == pr.Base.GetRepo().GetDefaultBranch() {
+		logger.Debug("Not deleting branch of another repository or the default branch", zap.String("branch", pr.Head.GetLabel()))
+		return nil
+	}
+
+	b, _, err := gh.Repositories.GetBranch(context.Background(), owner, repository, branch)
+	if err != nil {
+		if errResp, ok := err.(*github.ErrorResponse); ok && errResp.Response.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		return errors.Wrapf(err, "failed to get branch %s", branch)
+	}
+	if b.GetProtected() {
+		logger.Debug("Not deleting protected branch", zap.String("branch", branch))
+		return nil
+	}
+
+	dependents, _, err := gh.PullRequests.List(context.Background(), owner, repository, &github.PullRequestListOptions{
+		State: "open",
+		Base:  branch,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to list pull requests based on %s", branch)
+	}
+	if len(dependents) > 0 {
+		logger.Debug("Not deleting branch other pull requests are based on", zap.String("branch", branch), zap.Int("pullRequests", len(dependents)))
+		return nil
+	}
+
+	logger.Info("Deleting merged branch", zap.String("branch", branch))
+	if _, err := gh.Git.DeleteRef(context.Background(), owner, repository, "heads/"+branch); err != nil {
+		return errors.Wrapf(err, "failed to delete branch %s", branch)
+	}
+	return nil
+}
+
+func removeMergedLabel(pr *github.PullRequest, label, owner, repository string, gh *github.Client, logger *zap.Logger) error {
+	if label == "" {
+		return nil
+	}
+
+	logger.Debug("Removing label from merged pull request", zap.String("label", label))
+	if _, err := gh.Issues.RemoveLabelForIssue(context.Background(), owner, repository, pr.GetNumber(), label); err != nil {
+		if errResp, ok := err.(*github.ErrorResponse); ok && errResp.Response.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		return errors.Wrapf(err, "failed to remove label %s from pull request %s", label, pr.GetHTMLURL())
+	}
+	return nil
+}
+
+// setCurrentMilestone sets the current milestone on the pull request and the
+// issues of the repository its description or commit messages close, unless
+// they have one already.
+func setCurrentMilestone(pr *github.PullRequest, owner, repository string, gh *github.Client, logger *zap.Logger) error {
+	milestones, err := listAllMilestones(gh, owner, repository, github.MilestoneListOptions{State: "open"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to list milestones of %s/%s", owner, repository)
+	}
+	milestone := currentMilestone(milestones, time.Now())
+	if milestone == nil {
+		logger.Debug("No current milestone to set")
+		return nil
+	}
+
+	commits, err := listAllCommits(gh, owner, repository, pr.GetNumber())
+	if err != nil {
+		return errors.Wrapf(err, "failed to list commits of %s", pr.GetHTMLURL())
+	}
+	messages := []string{pr.GetBody()}
+	for _, commit := range commits {
+		messages = append(messages, commit.Commit.GetMessage())
+	}
+	numbers := append([]int{pr.GetNumber()}, closedIssues(owner, repository, messages...)...)
+
+	var multiErr error
+	for _, n := range numbers {
+		issue, _, err := gh.Issues.Get(context.Background(), owner, repository, n)
+		if err != nil {
+			multiErr = multierr.Append(multiErr, errors.Wrapf(err, "failed to get issue %d", n))
+			continue
+		}
+		if issue.Milestone != nil {
+			continue
+		}
+
+		logger.Debug("Setting milestone", zap.Int("issue", n), zap.String("milestone", milestone.GetTitle()))
+		if _, _, err := gh.Issues.Edit(context.Background(), owner, repository, n, &github.IssueRequest{
+			Milestone: milestone.Number,
+		}); err != nil {
+			multiErr = multierr.Append(multiErr, errors.Wrapf(err, "failed to set milestone of issue %d", n))
+		}
+	}
+	return multiErr
+}
+
+// closedIssues returns the numbers of the issues of owner/repository the
+// messages close, in order of appearance. Issues of other repositories are
+// left out.
+func closedIssues(owner, repository string, messages ...string) []int {
+	var numbers []int
+	seen := make(map[int]bool)
+	for _, message := range messages {
+		for _, match := range closingReference.FindAllStringSubmatch(message, -1) {
+			repo := match[1] + match[2]
+			if repo != "" && !strings.EqualFold(repo, owner+"/"+repository) {
+				continue
+			}
+			n, err := strconv.Atoi(match[3])
+			if err != nil || seen[n] {
+				continue
+			}
+			seen[n] = true
+			numbers = append(numbers, n)
+		}
+	}
+	return numbers
+}
+
+// currentMilestone returns the open milestone which is due next. If no
+// milestone has a due date that is not past, the only open milestone is
+// current, if there is just one.
+func currentMilestone(milestones []*github.Milestone, now time.Time) *github.Milestone {
+	today := now.Truncate(24 * time.Hour)
+
+	var current *github.Milestone
+	for _, m := range milestones {
+		if m.DueOn == nil || m.DueOn.Before(today) {
+			continue
+		}
+		if current == nil || m.DueOn.Before(*current.DueOn) {
+			current = m
+		}
+	}
+	if current == nil && len(milestones) == 1 {
+		current = milestones[0]
+	}
+	return current
+}
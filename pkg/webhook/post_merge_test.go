@@ -0,0 +1,49 @@
+package webhook
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+func TestCurrentMilestone(t *testing.T) {
+	now := time.Date(2018, 11, 5, 15, 0, 0, 0, time.UTC)
+	milestone := func(number int, due *time.Time) *github.Milestone {
+		return &github.Milestone{Number: github.Int(number), DueOn: due}
+	}
+	day := func(d int) *time.Time {
+		t := time.Date(2018, 11, d, 0, 0, 0, 0, time.UTC)
+		return &t
+	}
+
+	tests := []struct {
+		name       string
+		milestones []*github.Milestone
+		expected   int
+	}{
+		{"none", nil, 0},
+		{"next due", []*github.Milestone{milestone(1, day(30)), milestone(2, day(4)), milestone(3, day(5)), milestone(4, nil)}, 3},
+		{"all past", []*github.Milestone{milestone(1, day(1)), milestone(2, nil)}, 0},
+		{"single without due date", []*github.Milestone{milestone(1, nil)}, 1},
+	}
+	for _, test := range tests {
+		if current := currentMilestone(test.milestones, now); current.GetNumber() != test.expected {
+			t.Errorf("%s: expected milestone %d, got %d", test.name, test.expected, current.GetNumber())
+		}
+	}
+}
+
+func TestClosedIssues(t *testing.T) {
+	messages := []string{
+		"Fixes #12 and closes https://github.com/O/R/issues/13",
+		"Closes other/repo#14, fixes https://github.com/other/repo/issues/15",
+		"Resolve the race\n\nfixed o/r#16, closing #12",
+		"See #17",
+	}
+	expected := []int{12, 13, 16}
+	if numbers := closedIssues("o", "r", messages...); !reflect.DeepEqual(numbers, expected) {
+		t.Errorf("expected %v, got %v", expected, numbers)
+	}
+}
@@ -0,0 +1,277 @@
+// Copyright © 2017 Syndesis Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	"github.com/syndesisio/pure-bot/pkg/config"
+	"github.com/syndesisio/pure-bot/pkg/github/apps"
+)
+
+// reconciledHandlers are run for every open pull request as if it was just
+// pushed to. Auto-merge is evaluated separately.
+var reconciledHandlers = []string{"wip", "reviewerRequest"}
+
+// Reconcile evaluates every open pull request of the repositories the GitHub
+// App is installed on, as if an event about it had arrived: the WIP and
+// review statuses are updated and approved pull requests are merged. This
+// catches up on events which were missed while the bot was down. If repos
+// is not empty only the repositories given as owner/name are reconciled.
+func Reconcile(cfg config.Config, repos []string, logger *zap.Logger) error {
+	r := &reconciler{
+		semaphore: make(chan struct{}, cfg.Reconcile.Concurrency),
+	}
+	start := time.Now()
+	err := walkRepos(cfg, repos, logger, r.reconcileRepo)
+	r.wg.Wait()
+
+	logger.Info("Reconciliation finished", zap.Int("pullRequests", r.pullRequests), zap.Duration("duration", time.Since(start)))
+	return multierr.Append(err, r.err)
+}
+
+// RunReconciliation reconciles all repositories every interval until stop is
+// closed, using the active configuration of store.
+func RunReconciliation(store *config.Store, interval time.Duration, stop <-chan struct{}, logger *zap.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := Reconcile(store.Get(), nil, logger); err != nil {
+				logger.Error("Reconciliation failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// repoFunc acts on a repository outside of event handling, see walkRepos.
+type repoFunc func(repo *github.Repository, installation *github.Installation, gh *github.Client, config config.RepoConfig, logger *zap.Logger) error
+
+// walkRepos calls fn for every repository the GitHub App is installed on, or
+// only for the repositories given as owner/name if repos is not empty.
+// Disabled repositories are skipped. fn gets the configuration of the
+// repository, including its config file, and a client which honors its dry
+// run setting. Requests pause while at most reconcile.rateLimitReserve
+// requests of an installation's rate limit are left.
+func walkRepos(cfg config.Config, repos []string, logger *zap.Logger, fn repoFunc) error {
+	key, err := ioutil.ReadFile(cfg.GitHubApp.PrivateKeyFile)
+	if err != nil {
+		return errors.Wrap(err, "failed to read private key file")
+	}
+	appClient, err := apps.AppClient(cfg.GitHubApp.AppID, key, cfg.GitHubApp.BaseURL)
+	if err != nil {
+		return errors.Wrap(err, "failed to create GitHub client")
+	}
+	installations, err := listAllInstallations(appClient)
+	if err != nil {
+		return errors.Wrap(err, "failed to list installations")
+	}
+	if err := identifyBot(cfg.GitHubApp); err != nil {
+		logger.Warn("Failed to identify the bot user, its comments won't be found", zap.Error(err))
+	}
+
+	var walkErr error
+	for _, installation := range installations {
+		walkErr = multierr.Append(walkErr, walkInstallation(cfg, repos, installation, logger, fn))
+	}
+	return walkErr
+}
+
+func walkInstallation(cfg config.Config, selected []string, installation *github.Installation, logger *zap.Logger, fn repoFunc) error {
+	id := installation.GetID()
+	logger = logger.With(zap.Int64("installation", id))
+
+	// Both clients share the rate limit of the installation
+	limit := waitForRateLimit(cfg.Reconcile.RateLimitReserve, logger)
+	gh, err := newGitHubClient(cfg.GitHubApp, id, githubAPIMetrics(id), limit)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create GitHub client for installation %d", id)
+	}
+	dryRunClient, err := newGitHubClient(cfg.GitHubApp, id, githubAPIMetrics(id), limit, dryRun(logger))
+	if err != nil {
+		return errors.Wrapf(err, "failed to create GitHub client for installation %d", id)
+	}
+
+	repos, err := listAllInstallationRepos(gh)
+	if err != nil {
+		return errors.Wrapf(err, "failed to list repositories of installation %d", id)
+	}
+	var walkErr error
+	for _, repo := range repos {
+		if len(selected) > 0 && !containsFold(selected, repo.GetFullName()) {
+			continue
+		}
+		repoLogger := logger.With(zap.String("repo", repo.GetFullName()))
+		if err := rememberInstallation(repo.GetFullName(), id); err != nil {
+			repoLogger.Warn("Failed to persist installation", zap.Error(err))
+		}
+
+		repoConfig := extractRepoConfigWithDefaults(repo, cfg, repoLogger)
+		if repoConfig.IsDisabled() {
+			repoLogger.Debug("Disabled by configuration")
+			continue
+		}
+		client := gh
+		if repoConfig.IsDryRun() {
+			client = dryRunClient
+		}
+		repoFile, err := loadRepoConfigFile(client, repo, repoLogger)
+		if err != nil {
+			repoLogger.Warn("Ignoring repo config file", zap.Error(err))
+		} else if repoFile != nil {
+			repoConfig.MergeRepoFile(*repoFile)
+		}
+
+		walkErr = multierr.Append(walkErr, fn(repo, installation, client, *repoConfig, repoLogger))
+	}
+	return walkErr
+}
+
+type reconciler struct {
+	// semaphore bounds the number of pull requests evaluated at once
+	semaphore chan struct{}
+
+	wg           sync.WaitGroup
+	mu           sync.Mutex // mu protects err and pullRequests
+	err          error
+	pullRequests int
+}
+
+func (r *reconciler) fail(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.err = multierr.Append(r.err, err)
+}
+
+// reconcileRepo starts reconciling the open pull requests of the repository
+// and returns before they are done.
+func (r *reconciler) reconcileRepo(repo *github.Repository, installation *github.Installation, gh *github.Client, repoConfig config.RepoConfig, logger *zap.Logger) error {
+	prs, err := listAllPullRequests(gh, repo.GetOwner().GetLogin(), repo.GetName(), github.PullRequestListOptions{State: "open"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to list pull requests of %s", repo.GetFullName())
+	}
+	logger.Debug("Reconciling pull requests", zap.Int("pullRequests", len(prs)))
+	for _, pr := range prs {
+		r.semaphore <- struct{}{}
+		r.wg.Add(1)
+		go func(pr *github.PullRequest) {
+			defer func() {
+				<-r.semaphore
+				r.wg.Done()
+			}()
+			prLogger := logger.With(zap.Int("pr", pr.GetNumber()))
+			if err := reconcilePullRequest(pr, repo, installation, gh, repoConfig, prLogger); err != nil {
+				r.fail(errors.Wrapf(err, "failed to reconcile %s", pr.GetHTMLURL()))
+			}
+			r.mu.Lock()
+			r.pullRequests++
+			r.mu.Unlock()
+		}(pr)
+	}
+	return nil
+}
+
+// reconcilePullRequest runs the reconciled handlers which are switched on
+// for a synthetic push to the pull request and evaluates it for auto-merge.
+func reconcilePullRequest(pr *github.PullRequest, repo *github.Repository, installation *github.Installation, gh *github.Client, config config.RepoConfig, logger *zap.Logger) error {
+	event := &github.PullRequestEvent{
+		Action:       github.String("synchronize"),
+		Number:       pr.Number,
+		PullRequest:  pr,
+		Repo:         repo,
+		Installation: installation,
+	}
+
+	var err error
+	for _, h := range handlers {
+		if !containsString(reconciledHandlers, h.name) || !config.HandlerEnabled(h.name, h.enabled) {
+			continue
+		}
+		err = multierr.Append(err, h.handler.HandleEvent(event, gh, config, logger))
+	}
+
+	if config.Labels.Approved != "" && config.HandlerEnabled("autoMerge", true) {
+		err = multierr.Append(err, mergePRByNumber(pr.GetNumber(), repo.GetOwner().GetLogin(), repo.GetName(), gh, config, logger))
+	}
+	return err
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, e := range list {
+		if strings.EqualFold(e, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// waitForRateLimit delays requests while at most reserve requests of the
+// rate limit are left, until the limit is reset. The limit is taken from the
+// headers of the last response.
+func waitForRateLimit(reserve int, logger *zap.Logger) apps.Middleware {
+	var (
+		mu        sync.Mutex
+		remaining = -1
+		reset     time.Time
+	)
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			mu.Lock()
+			var wait time.Duration
+			if remaining >= 0 && remaining <= reserve {
+				wait = time.Until(reset)
+			}
+			mu.Unlock()
+			if wait > 0 {
+				logger.Info("Waiting for the rate limit to reset", zap.Duration("wait", wait))
+				time.Sleep(wait)
+			}
+
+			resp, err := next.RoundTrip(req)
+			if err != nil {
+				return resp, err
+			}
+			if left, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining")); err == nil {
+				resetAt, _ := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+				mu.Lock()
+				remaining, reset = left, time.Unix(resetAt, 0)
+				mu.Unlock()
+			}
+			return resp, nil
+		})
+	}
+}
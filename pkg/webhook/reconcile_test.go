@@ -0,0 +1,86 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+	"go.uber.org/zap"
+
+	"github.com/syndesisio/pure-bot/pkg/config"
+)
+
+func TestReconcilePullRequest(t *testing.T) {
+	var statuses []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost:
+			var status github.RepoStatus
+			body, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(body, &status)
+			statuses = append(statuses, status.GetContext()+" "+status.GetState())
+			w.Write([]byte(`{}`))
+		case r.URL.Path == "/repos/o/r/pulls/1":
+			w.Write([]byte(`{"number": 1, "state": "open", "title": "WIP: reconcile", "head": {"sha": "a1"}}`))
+		case r.URL.Path == "/repos/o/r/issues/1":
+			w.Write([]byte(`{"number": 1, "labels": []}`))
+		default:
+			w.Write([]byte(`[]`))
+		}
+	}))
+	defer server.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	repo := &github.Repository{Name: github.String("r"), Owner: &github.User{Login: github.String("o")}}
+	pr := &github.PullRequest{Number: github.Int(1), Title: github.String("WIP: reconcile"), Head: &github.PullRequestBranch{SHA: github.String("a1")}}
+	repoConfig := config.RepoConfig{
+		Labels:      config.LabelConfig{Approved: "approved", ReviewRequested: "review requested"},
+		WipPatterns: []string{"wip"},
+	}
+
+	if err := reconcilePullRequest(pr, repo, &github.Installation{ID: github.Int64(1)}, client, repoConfig, zap.NewNop()); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(statuses)
+	expected := []string{prReviewContext + " success", wipContext + " pending"}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("expected %v, got %v", expected, statuses)
+	}
+}
+
+func TestWaitForRateLimit(t *testing.T) {
+	reset := time.Now().Add(time.Second).Truncate(time.Second).Add(time.Second)
+	remaining := 2
+	var requests []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, time.Now())
+		remaining--
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: waitForRateLimit(0, zap.NewNop())(http.DefaultTransport)}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if requests[1].After(reset) {
+		t.Error("expected second request not to wait")
+	}
+	if requests[2].Before(reset) {
+		t.Error("expected third request to wait for the rate limit reset")
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright © 2017 Syndesis Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/syndesisio/pure-bot/pkg/config"
+)
+
+// Recording is a webhook delivery as written by the recording handler and
+// read back by LoadRecordings.
+type Recording struct {
+	File    string          `json:"-"`
+	Header  http.Header     `json:"header"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// EventType returns the GitHub event type the recording was delivered as.
+func (r *Recording) EventType() string {
+	return r.Header.Get("X-GitHub-Event")
+}
+
+// NewRecordingHandler writes every valid delivery with its headers to dir
+// before passing it on to next. If next is nil, deliveries are only recorded
+// and acknowledged.
+func NewRecordingHandler(store *config.Store, dir string, next http.HandlerFunc, logger *zap.Logger) (http.HandlerFunc, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrapf(err, "failed to create recording directory %s", dir)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := store.Get().Webhook
+		var payload []byte
+		if cfg.Secret != "" {
+			pl, err := github.ValidatePayload(r, ([]byte)(cfg.Secret))
+			if err != nil {
+				logger.Error("webhook payload validation failed", zap.Error(err))
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			payload = pl
+		} else {
+			pl, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				logger.Error("failed to read payload", zap.Error(err))
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			payload = pl
+		}
+
+		file, err := writeRecording(dir, r.Header, payload)
+		if err != nil {
+			logger.Error("failed to record webhook", zap.Error(err))
+		} else {
+			logger.Info("Recorded webhook", zap.String("messageType", github.WebHookType(r)), zap.String("file", file))
+		}
+
+		if next == nil {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(payload))
+		next(w, r)
+	}, nil
+}
+
+func writeRecording(dir string, header http.Header, payload []byte) (string, error) {
+	recording := Recording{
+		Header:  http.Header{},
+		Payload: payload,
+	}
+	for name, values := range header {
+		// Only GitHub's own headers are of interest when replaying
+		if strings.HasPrefix(strings.ToLower(name), "x-github-") || strings.HasPrefix(strings.ToLower(name), "x-hub-") {
+			recording.Header[name] = values
+		}
+	}
+
+	data, err := json.MarshalIndent(recording, "", "  ")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode recording")
+	}
+
+	name := fmt.Sprintf("%d-%s", time.Now().UnixNano(), recording.EventType())
+	if delivery := header.Get("X-GitHub-Delivery"); delivery != "" {
+		name += "-" + delivery
+	}
+	file := filepath.Join(dir, name+".json")
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		return "", errors.Wrapf(err, "failed to write %s", file)
+	}
+	return file, nil
+}
+
+// LoadRecordings reads the recordings in path, which may be a single file or
+// a directory whose JSON files are read in lexical order. Besides recordings
+// written by NewRecordingHandler, plain payloads as copied from the GitHub
+// App's "Advanced" page are accepted. Their event type is taken from
+// eventType or, if empty, from the file name (e.g. "status.json").
+func LoadRecordings(path string, eventType string) ([]*Recording, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to access %s", path)
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		files, err = filepath.Glob(filepath.Join(path, "*.json"))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list %s", path)
+		}
+		sort.Strings(files)
+	}
+
+	recordings := make([]*Recording, 0, len(files))
+	for _, file := range files {
+		recording, err := loadRecording(file, eventType)
+		if err != nil {
+			return nil, err
+		}
+		recordings = append(recordings, recording)
+	}
+	return recordings, nil
+}
+
+func loadRecording(file string, eventType string) (*Recording, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", file)
+	}
+
+	recording := &Recording{File: file}
+	if err := json.Unmarshal(data, recording); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode %s", file)
+	}
+	if len(recording.Payload) == 0 {
+		// Plain payload without headers
+		recording.Header = nil
+		recording.Payload = data
+	}
+	if recording.Header == nil {
+		recording.Header = http.Header{}
+	}
+
+	if eventType != "" {
+		recording.Header.Set("X-GitHub-Event", eventType)
+	}
+	if recording.EventType() == "" {
+		recording.Header.Set("X-GitHub-Event", strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)))
+	}
+	return recording, nil
+}
@@ -0,0 +1,59 @@
+package webhook
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestLoadPlainPayload(t *testing.T) {
+	recordings, err := LoadRecordings("../../testdata/status.json", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recordings) != 1 {
+		t.Fatalf("expected 1 recording, got %d", len(recordings))
+	}
+	if recordings[0].EventType() != "status" {
+		t.Errorf("expected event type from file name, got %q", recordings[0].EventType())
+	}
+}
+
+func TestRecordingRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recordings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	payload, err := ioutil.ReadFile("../../testdata/pull_request_review.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{}
+	header.Set("X-GitHub-Event", "pull_request_review")
+	header.Set("X-GitHub-Delivery", "72d3162e-cc78-11e3-81ab-4c9367dc0958")
+	header.Set("Authorization", "secret")
+	if _, err := writeRecording(dir, header, payload); err != nil {
+		t.Fatal(err)
+	}
+
+	recordings, err := LoadRecordings(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recordings) != 1 {
+		t.Fatalf("expected 1 recording, got %d", len(recordings))
+	}
+	r := recordings[0]
+	if r.EventType() != "pull_request_review" {
+		t.Errorf("unexpected event type %q", r.EventType())
+	}
+	if r.Header.Get("Authorization") != "" {
+		t.Error("non GitHub headers should not be recorded")
+	}
+	if len(r.Payload) == 0 {
+		t.Error("payload missing")
+	}
+}
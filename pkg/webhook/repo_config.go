@@ -0,0 +1,165 @@
+// Copyright © 2017 Syndesis Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/syndesisio/pure-bot/pkg/config"
+)
+
+const repoConfigCheckName = "pure-bot/config"
+
+type repoConfigFile struct {
+	sha    string
+	config *config.RepoConfig
+	err    error
+}
+
+// repoConfigFiles caches the parsed configuration file of each repository
+// by the SHA of its blob, so it is only downloaded again after it changed.
+var repoConfigFiles = struct {
+	sync.Mutex
+	byRepo map[string]repoConfigFile
+}{byRepo: make(map[string]repoConfigFile)}
+
+// loadRepoConfigFile returns the configuration file on the repository's
+// default branch, or nil if there is none.
+func loadRepoConfigFile(gh *github.Client, repo *github.Repository, logger *zap.Logger) (*config.RepoConfig, error) {
+	owner, name := repo.GetOwner().GetLogin(), repo.GetName()
+	dir, file := path.Split(config.RepoFile)
+
+	_, entries, _, err := gh.Repositories.GetContents(context.Background(), owner, name, strings.TrimSuffix(dir, "/"), &github.RepositoryContentGetOptions{
+		Ref: repo.GetDefaultBranch(),
+	})
+	if err != nil {
+		if errResp, ok := err.(*github.ErrorResponse); ok && errResp.Response.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "failed to list %s of %s", dir, repo.GetFullName())
+	}
+
+	var sha string
+	for _, entry := range entries {
+		if entry.GetName() == file && entry.GetType() == "file" {
+			sha = entry.GetSHA()
+		}
+	}
+	if sha == "" {
+		return nil, nil
+	}
+
+	repoConfigFiles.Lock()
+	cached, ok := repoConfigFiles.byRepo[repo.GetFullName()]
+	repoConfigFiles.Unlock()
+	if ok && cached.sha == sha {
+		return cached.config, cached.err
+	}
+
+	logger.Debug("Loading repo config file", zap.String("repo", repo.GetFullName()), zap.String("sha", sha))
+	data, _, err := gh.Git.GetBlobRaw(context.Background(), owner, name, sha)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to download %s of %s", config.RepoFile, repo.GetFullName())
+	}
+
+	cached = repoConfigFile{sha: sha}
+	repoConfig, err := config.ParseRepoFile(data)
+	if err != nil {
+		cached.err = errors.Wrapf(err, "invalid %s in %s", config.RepoFile, repo.GetFullName())
+	} else {
+		cached.config = &repoConfig
+	}
+
+	repoConfigFiles.Lock()
+	repoConfigFiles.byRepo[repo.GetFullName()] = cached
+	repoConfigFiles.Unlock()
+	return cached.config, cached.err
+}
+
+// repoConfigCheck validates the repository's configuration file whenever a
+// push changes it and reports the result as a check run on the pushed commit.
+type repoConfigCheck struct{}
+
+func (h *repoConfigCheck) EventTypesHandled() []string {
+	return []string{"push"}
+}
+
+func (h *repoConfigCheck) HandleEvent(eventObject interface{}, gh *github.Client, _ config.RepoConfig, logger *zap.Logger) error {
+	event, ok := eventObject.(*github.PushEvent)
+	if !ok {
+		return errors.New("wrong event eventObject type")
+	}
+
+	if event.GetDeleted() || !pushChangesRepoConfigFile(event) {
+		return nil
+	}
+
+	owner, repo := event.Repo.GetOwner().GetName(), event.Repo.GetName()
+	fileContent, _, _, err := gh.Repositories.GetContents(context.Background(), owner, repo, config.RepoFile, &github.RepositoryContentGetOptions{
+		Ref: event.GetAfter(),
+	})
+	if err != nil {
+		if errResp, ok := err.(*github.ErrorResponse); ok && errResp.Response.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		return errors.Wrapf(err, "failed to get %s of %s at %s", config.RepoFile, event.Repo.GetFullName(), event.GetAfter())
+	}
+	content, err := fileContent.GetContent()
+	if err != nil {
+		return errors.Wrapf(err, "failed to decode %s of %s", config.RepoFile, event.Repo.GetFullName())
+	}
+
+	conclusion, title, summary := "success", "Configuration is valid", "`"+config.RepoFile+"` is valid."
+	if _, err := config.ParseRepoFile([]byte(content)); err != nil {
+		logger.Info("Invalid repo config file pushed", zap.String("repo", event.Repo.GetFullName()), zap.String("sha", event.GetAfter()), zap.Error(err))
+		conclusion, title, summary = "failure", "Configuration is invalid", "`"+config.RepoFile+"` is invalid and is ignored:\n\n```\n"+err.Error()+"\n```"
+	}
+
+	_, _, err = gh.Checks.CreateCheckRun(context.Background(), owner, repo, github.CreateCheckRunOptions{
+		Name:        repoConfigCheckName,
+		HeadBranch:  strings.TrimPrefix(event.GetRef(), "refs/heads/"),
+		HeadSHA:     event.GetAfter(),
+		Status:      github.String("completed"),
+		Conclusion:  github.String(conclusion),
+		CompletedAt: &github.Timestamp{Time: time.Now()},
+		Output: &github.CheckRunOutput{
+			Title:   github.String(title),
+			Summary: github.String(summary),
+		},
+	})
+	return errors.Wrapf(err, "failed to create check run for %s of %s", config.RepoFile, event.Repo.GetFullName())
+}
+
+func pushChangesRepoConfigFile(event *github.PushEvent) bool {
+	for _, commit := range event.Commits {
+		for _, files := range [][]string{commit.Added, commit.Modified} {
+			for _, file := range files {
+				if file == config.RepoFile {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
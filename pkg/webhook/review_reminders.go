@@ -0,0 +1,259 @@
+// Copyright © 2017 Syndesis Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	"github.com/syndesisio/pure-bot/pkg/config"
+)
+
+// Hidden markers of the reminder comment, which is updated instead of adding
+// a new comment on every reminder
+const (
+	reviewReminderMarker   = "<!-- pure-bot:review-reminder -->"
+	reviewEscalationMarker = "<!-- pure-bot:review-escalated -->"
+)
+
+// reviewRequestEvent is an issue event with the requested reviewer or team,
+// which the GitHub client doesn't know yet.
+type reviewRequestEvent struct {
+	Event             string       `json:"event"`
+	CreatedAt         time.Time    `json:"created_at"`
+	RequestedReviewer *github.User `json:"requested_reviewer"`
+	RequestedTeam     *github.Team `json:"requested_team"`
+}
+
+// RemindReviewers reminds the reviewers of all open pull requests whose
+// review requests are outstanding for longer than reviewReminders.after and
+// escalates the ones outstanding for longer than reviewReminders.escalateAfter.
+func RemindReviewers(cfg config.Config, logger *zap.Logger) error {
+	now := time.Now()
+	return walkRepos(cfg, nil, logger, func(repo *github.Repository, _ *github.Installation, gh *github.Client, repoConfig config.RepoConfig, logger *zap.Logger) error {
+		if repoConfig.ReviewReminders.GetAfter() <= 0 {
+			return nil
+		}
+		prs, err := listAllPullRequests(gh, repo.GetOwner().GetLogin(), repo.GetName(), github.PullRequestListOptions{State: "open"})
+		if err != nil {
+			return errors.Wrapf(err, "failed to list pull requests of %s", repo.GetFullName())
+		}
+		var remindErr error
+		for _, pr := range prs {
+			// The listed pull requests lack the requested teams, so only
+			// remindPullRequest knows whether reviews are outstanding
+			prLogger := logger.With(zap.Int("pr", pr.GetNumber()))
+			if err := remindPullRequest(pr, repo, gh, repoConfig.ReviewReminders, now, prLogger); err != nil {
+				remindErr = multierr.Append(remindErr, errors.Wrapf(err, "failed to remind reviewers of %s", pr.GetHTMLURL()))
+			}
+		}
+		return remindErr
+	})
+}
+
+// RunReviewReminders reminds reviewers every interval until stop is closed,
+// using the active configuration of store.
+func RunReviewReminders(store *config.Store, interval time.Duration, stop <-chan struct{}, logger *zap.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := RemindReviewers(store.Get(), logger); err != nil {
+				logger.Error("Review reminders failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// remindPullRequest mentions the requested reviewers and teams who are
+// overdue in the reminder comment of the pull request and requests reviews from the
+// fallback reviewers once a request is outstanding for longer than
+// EscalateAfter.
+func remindPullRequest(pr *github.PullRequest, repo *github.Repository, gh *github.Client, cfg config.ReviewRemindersConfig, now time.Time, logger *zap.Logger) error {
+	owner, repository, number := repo.GetOwner().GetLogin(), repo.GetName(), pr.GetNumber()
+
+	requested, err := listAllReviewers(gh, owner, repository, number)
+	if err != nil {
+		return errors.Wrapf(err, "failed to list reviewers for PR %s", pr.GetHTMLURL())
+	}
+	// Teams are mentioned and looked up as "org/team"
+	var reviewers []string
+	for _, user := range requested.Users {
+		reviewers = append(reviewers, user.GetLogin())
+	}
+	for _, team := range requested.Teams {
+		reviewers = append(reviewers, owner+"/"+team.GetSlug())
+	}
+	if len(reviewers) == 0 {
+		return nil
+	}
+	requestedAt, err := reviewRequestTimes(gh, owner, repository, number)
+	if err != nil {
+		return err
+	}
+
+	var overdue []string
+	escalate := false
+	for _, login := range reviewers {
+		since, ok := requestedAt[strings.ToLower(login)]
+		if !ok {
+			since = pr.GetCreatedAt()
+		}
+		waiting := businessDuration(since, now, cfg.Location(login))
+		if waiting < cfg.GetAfter() {
+			continue
+		}
+		overdue = append(overdue, "@"+login)
+		if cfg.GetEscalateAfter() > 0 && waiting >= cfg.GetEscalateAfter() {
+			escalate = true
+		}
+	}
+	if len(overdue) == 0 {
+		return nil
+	}
+
+	existing, err := findMarkedComment(gh, owner, repository, number, reviewReminderMarker)
+	if err != nil {
+		return err
+	}
+	escalated := existing != nil && strings.Contains(existing.GetBody(), reviewEscalationMarker)
+	if escalate && !escalated {
+		if err := escalateReview(pr, repo, gh, cfg.EscalateTo, logger); err != nil {
+			return err
+		}
+		escalated = true
+	}
+
+	body := fmt.Sprintf("%s\n:bell: %s, your review was requested more than %s ago.", reviewReminderMarker, strings.Join(overdue, ", "), businessTime(cfg.GetAfter()))
+	if escalated {
+		var fallbacks []string
+		for _, fallback := range cfg.EscalateTo {
+			fallbacks = append(fallbacks, "@"+fallback)
+		}
+		body += fmt.Sprintf("\n\n%s\n:rotating_light: No review after %s, escalated to %s.", reviewEscalationMarker, businessTime(cfg.GetEscalateAfter()), strings.Join(fallbacks, ", "))
+	}
+	logger.Info("Reminding reviewers", zap.Strings("reviewers", overdue), zap.Bool("escalated", escalated))
+	return upsertComment(gh, owner, repository, number, existing, body)
+}
+
+// reviewRequestTimes returns when the review of every currently requested
+// user and team was requested last, by lower cased login or "org/team".
+func reviewRequestTimes(gh *github.Client, owner, repository string, number int) (map[string]time.Time, error) {
+	events, err := listAllReviewRequestEvents(gh, owner, repository, number)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list events of %s/%s#%d", owner, repository, number)
+	}
+	requestedAt := make(map[string]time.Time)
+	for _, event := range events {
+		if event.Event != "review_requested" {
+			continue
+		}
+		if event.RequestedReviewer != nil {
+			requestedAt[strings.ToLower(event.RequestedReviewer.GetLogin())] = event.CreatedAt
+		}
+		if event.RequestedTeam != nil {
+			requestedAt[strings.ToLower(owner+"/"+event.RequestedTeam.GetSlug())] = event.CreatedAt
+		}
+	}
+	return requestedAt, nil
+}
+
+// escalateReview requests reviews from the fallback reviewers, except for the
+// author and users who reviewed or were requested already.
+func escalateReview(pr *github.PullRequest, repo *github.Repository, gh *github.Client, fallbacks []string, logger *zap.Logger) error {
+	owner, repository := repo.GetOwner().GetLogin(), repo.GetName()
+
+	skip := map[string]bool{strings.ToLower(pr.User.GetLogin()): true}
+	requested, err := listAllReviewers(gh, owner, repository, pr.GetNumber())
+	if err != nil {
+		return errors.Wrapf(err, "failed to list reviewers for PR %s", pr.GetHTMLURL())
+	}
+	for _, user := range requested.Users {
+		skip[strings.ToLower(user.GetLogin())] = true
+	}
+	for _, team := range requested.Teams {
+		skip[strings.ToLower(owner+"/"+team.GetSlug())] = true
+	}
+	reviews, err := listReviews(pr, repo, gh)
+	if err != nil {
+		return err
+	}
+	for _, review := range reviews {
+		skip[strings.ToLower(review.User.GetLogin())] = true
+	}
+
+	var request github.ReviewersRequest
+	for _, fallback := range fallbacks {
+		if skip[strings.ToLower(fallback)] {
+			continue
+		}
+		if slash := strings.Index(fallback, "/"); slash >= 0 {
+			request.TeamReviewers = append(request.TeamReviewers, fallback[slash+1:])
+		} else {
+			request.Reviewers = append(request.Reviewers, fallback)
+		}
+	}
+	if len(request.Reviewers) == 0 && len(request.TeamReviewers) == 0 {
+		return nil
+	}
+
+	logger.Info("Escalating review", zap.Strings("reviewers", request.Reviewers), zap.Strings("teams", request.TeamReviewers))
+	if _, _, err := gh.PullRequests.RequestReviewers(context.Background(), owner, repository, pr.GetNumber(), request); err != nil {
+		return errors.Wrapf(err, "failed to request reviews of %s from %s", pr.GetHTMLURL(), strings.Join(fallbacks, ", "))
+	}
+	return nil
+}
+
+// businessDuration returns the time between from and to which falls on
+// Monday to Friday in loc.
+func businessDuration(from, to time.Time, loc *time.Location) time.Duration {
+	var d time.Duration
+	from, to = from.In(loc), to.In(loc)
+	for from.Before(to) {
+		next := time.Date(from.Year(), from.Month(), from.Day()+1, 0, 0, 0, 0, loc)
+		if next.After(to) {
+			next = to
+		}
+		if day := from.Weekday(); day != time.Saturday && day != time.Sunday {
+			d += next.Sub(from)
+		}
+		from = next
+	}
+	return d
+}
+
+// businessTime formats a threshold, in business days if it is a multiple
+// of a day.
+func businessTime(d time.Duration) string {
+	day := 24 * time.Hour
+	switch {
+	case d == day:
+		return "1 business day"
+	case d%day == 0:
+		return fmt.Sprintf("%d business days", d/day)
+	default:
+		return d.String() + " of business days"
+	}
+}
@@ -0,0 +1,96 @@
+package webhook
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+	"go.uber.org/zap"
+
+	"github.com/syndesisio/pure-bot/pkg/config"
+)
+
+func TestBusinessDuration(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Friday 12:00 UTC to Monday 12:00 UTC
+	from := time.Date(2018, 11, 9, 12, 0, 0, 0, time.UTC)
+	to := time.Date(2018, 11, 12, 12, 0, 0, 0, time.UTC)
+
+	if d := businessDuration(from, to, time.UTC); d != 24*time.Hour {
+		t.Errorf("expected 24h in UTC, got %v", d)
+	}
+	// Friday 21:00 to Monday 21:00 in Tokyo
+	if d := businessDuration(from, to, tokyo); d != 24*time.Hour {
+		t.Errorf("expected 24h in Tokyo, got %v", d)
+	}
+	if d := businessDuration(to, from, time.UTC); d != 0 {
+		t.Errorf("expected 0 for reversed range, got %v", d)
+	}
+}
+
+func TestRemindPullRequest(t *testing.T) {
+	appBot.login = "pure-bot[bot]"
+	defer func() { appBot.login = "" }()
+	now := time.Date(2018, 11, 20, 4, 0, 0, 0, time.UTC) // Tuesday
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/repos/o/r/pulls/1/requested_reviewers":
+			if r.Method == http.MethodGet {
+				w.Write([]byte(`{"users": [{"login": "alice"}, {"login": "bob"}, {"login": "carol"}], "teams": [{"slug": "ui"}]}`))
+				return
+			}
+			requests = append(requests, r.Method+" "+r.URL.Path+" "+string(body))
+			w.Write([]byte(`{}`))
+		case "/repos/o/r/issues/1/events":
+			w.Write([]byte(`[
+				{"event": "review_requested", "created_at": "2018-11-14T04:00:00Z", "requested_reviewer": {"login": "alice"}},
+				{"event": "review_requested", "created_at": "2018-11-19T04:00:00Z", "requested_reviewer": {"login": "bob"}},
+				{"event": "review_requested", "created_at": "2018-11-19T12:00:00Z", "requested_reviewer": {"login": "carol"}},
+				{"event": "review_requested", "created_at": "2018-11-16T04:00:00Z", "requested_team": {"slug": "ui"}}]`))
+		case "/repos/o/r/issues/1/comments":
+			if r.Method == http.MethodGet {
+				w.Write([]byte(`[]`))
+				return
+			}
+			requests = append(requests, r.Method+" "+r.URL.Path+" "+string(body))
+			w.Write([]byte(`{}`))
+		default:
+			w.Write([]byte(`[]`))
+		}
+	}))
+	defer server.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	repo := &github.Repository{Name: github.String("r"), Owner: &github.User{Login: github.String("o")}}
+	pr := &github.PullRequest{Number: github.Int(1), User: &github.User{Login: github.String("author")}}
+	cfg := config.ReviewRemindersConfig{
+		After:         config.Duration(24 * time.Hour),
+		EscalateAfter: config.Duration(72 * time.Hour),
+		EscalateTo:    []string{"o/core", "author"},
+		// Still Sunday evening for bob at the time of the request
+		TimeZones: map[string]string{"bob": "America/Los_Angeles"},
+	}
+
+	if err := remindPullRequest(pr, repo, client, cfg, now, zap.NewNop()); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`POST /repos/o/r/pulls/1/requested_reviewers {"team_reviewers":["core"]}` + "\n",
+		`POST /repos/o/r/issues/1/comments {"body":"` + reviewReminderMarker + `\n:bell: @alice, @o/ui, your review was requested more than 1 business day ago.\n\n` +
+			reviewEscalationMarker + `\n:rotating_light: No review after 3 business days, escalated to @o/core, @author."}` + "\n",
+	}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("expected %q, got %q", expected, requests)
+	}
+}
@@ -0,0 +1,323 @@
+// Copyright © 2017 Syndesis Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/syndesisio/pure-bot/pkg/config"
+)
+
+// reviewerAssignments remembers when every user was last requested as
+// reviewer, by the lower cased full name of the repository and the lower
+// cased login. They are written to file on every change, if set.
+var reviewerAssignments = struct {
+	sync.Mutex
+	byRepo map[string]map[string]time.Time
+	file   string
+}{byRepo: make(map[string]map[string]time.Time)}
+
+// LoadReviewerAssignments restores the reviewer assignments persisted to file
+// and persists all later changes there, dropping the ones held before. Without
+// a file they are only kept in memory.
+func LoadReviewerAssignments(file string) error {
+	reviewerAssignments.Lock()
+	defer reviewerAssignments.Unlock()
+
+	reviewerAssignments.file = file
+	reviewerAssignments.byRepo = make(map[string]map[string]time.Time)
+	if file == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrap(err, "failed to read reviewer assignment file")
+	}
+	byRepo := make(map[string]map[string]time.Time)
+	if err := json.Unmarshal(data, &byRepo); err != nil {
+		return errors.Wrap(err, "invalid reviewer assignment file")
+	}
+	reviewerAssignments.byRepo = byRepo
+	return nil
+}
+
+// lastAssigned returns when the users were last requested as reviewer in the
+// repository, by lower cased login.
+func lastAssigned(repo string) map[string]time.Time {
+	reviewerAssignments.Lock()
+	defer reviewerAssignments.Unlock()
+
+	last := make(map[string]time.Time)
+	for login, at := range reviewerAssignments.byRepo[strings.ToLower(repo)] {
+		last[login] = at
+	}
+	return last
+}
+
+func recordAssignments(repo string, logins []string, at time.Time) error {
+	reviewerAssignments.Lock()
+	defer reviewerAssignments.Unlock()
+
+	key := strings.ToLower(repo)
+	if reviewerAssignments.byRepo[key] == nil {
+		reviewerAssignments.byRepo[key] = make(map[string]time.Time)
+	}
+	for _, login := range logins {
+		reviewerAssignments.byRepo[key][strings.ToLower(login)] = at
+	}
+	return saveReviewerAssignments()
+}
+
+// saveReviewerAssignments has to be called with reviewerAssignments locked.
+func saveReviewerAssignments() error {
+	if reviewerAssignments.file == "" {
+		return nil
+	}
+	data, err := json.Marshal(reviewerAssignments.byRepo)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode reviewer assignments")
+	}
+	tmp := reviewerAssignments.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return errors.Wrap(err, "failed to write reviewer assignment file")
+	}
+	return errors.Wrap(os.Rename(tmp, reviewerAssignments.file), "failed to write reviewer assignment file")
+}
+
+// reviewerAssignment requests reviewers for pull requests when they are
+// opened or leave draft, picking them from the code owners of the changed
+// files and from the configured pool.
+type reviewerAssignment struct{}
+
+func (h *reviewerAssignment) EventTypesHandled() []string {
+	return []string{"pull_request"}
+}
+
+func (h *reviewerAssignment) HandleEvent(eventObject interface{}, gh *github.Client, config config.RepoConfig, logger *zap.Logger) error {
+	event, ok := eventObject.(*github.PullRequestEvent)
+	if !ok {
+		return errors.New("wrong event eventObject type")
+	}
+
+	cfg := config.ReviewerAssignment
+	if cfg.GetCount() <= 0 || (len(cfg.Pool) == 0 && !cfg.GetCodeOwners()) {
+		return nil
+	}
+	switch event.GetAction() {
+	case "opened", "reopened", "ready_for_review":
+	default:
+		return nil
+	}
+
+	owner := event.Repo.Owner.GetLogin()
+	repository := event.Repo.GetName()
+	number := event.PullRequest.GetNumber()
+	logger = logger.With(zap.String("repo", event.Repo.GetFullName()), zap.Int("pr", number))
+
+	// The event might be outdated, e.g. when reviewers were requested since
+	pr, err := getDraftPullRequest(gh, owner, repository, number)
+	if err != nil {
+		return err
+	}
+	if pr.Draft || pr.GetState() != "open" {
+		return nil
+	}
+
+	reviewers, err := selectReviewers(pr, owner, repository, gh, cfg, logger)
+	if err != nil || len(reviewers) == 0 {
+		return err
+	}
+
+	logger.Info("Requesting reviewers", zap.Strings("reviewers", reviewers))
+	if _, _, err := gh.PullRequests.RequestReviewers(context.Background(), owner, repository, number, github.ReviewersRequest{Reviewers: reviewers}); err != nil {
+		return errors.Wrapf(err, "failed to request reviews of %s from %s", pr.GetHTMLURL(), strings.Join(reviewers, ", "))
+	}
+	if config.IsDryRun() {
+		return nil
+	}
+	return recordAssignments(event.Repo.GetFullName(), reviewers, time.Now())
+}
+
+// selectReviewers returns the users to request reviews from so that the pull
+// request gets the configured number of reviewers. Users who reviewed or
+// were requested already count as reviewers.
+func selectReviewers(pr *draftPullRequest, owner, repository string, gh *github.Client, cfg config.ReviewerAssignmentConfig, logger *zap.Logger) ([]string, error) {
+	excluded := map[string]bool{strings.ToLower(pr.User.GetLogin()): true}
+	for _, login := range cfg.OutOfOffice {
+		excluded[strings.ToLower(login)] = true
+	}
+	assigned := len(pr.RequestedTeams)
+	for _, user := range pr.RequestedReviewers {
+		excluded[strings.ToLower(user.GetLogin())] = true
+		assigned++
+	}
+	reviews, err := listAllReviews(gh, owner, repository, pr.GetNumber())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list reviews of %s", pr.GetHTMLURL())
+	}
+	for _, review := range reviews {
+		login := strings.ToLower(review.User.GetLogin())
+		if !excluded[login] {
+			excluded[login] = true
+			assigned++
+		}
+	}
+	if assigned >= cfg.GetCount() {
+		return nil, nil
+	}
+
+	// Code owners are preferred, the pool only fills up
+	var tiers [][]string
+	if cfg.GetCodeOwners() {
+		owners, err := changedFileOwners(pr, owner, repository, gh)
+		if err != nil {
+			return nil, err
+		}
+		tiers = append(tiers, owners)
+	}
+	tiers = append(tiers, cfg.Pool)
+
+	var load map[string]int
+	if cfg.Strategy == config.ReviewerStrategyLoad {
+		if load, err = reviewLoad(gh, owner, repository); err != nil {
+			return nil, err
+		}
+	}
+	last := lastAssigned(owner + "/" + repository)
+	teams := make(map[string][]string)
+
+	var selected []string
+	for _, tier := range tiers {
+		candidates, err := expandTeams(gh, tier, teams)
+		if err != nil {
+			return nil, err
+		}
+		var eligible []string
+		for _, login := range candidates {
+			if !excluded[strings.ToLower(login)] {
+				excluded[strings.ToLower(login)] = true
+				eligible = append(eligible, login)
+			}
+		}
+		rankCandidates(eligible, last, load)
+		for _, login := range eligible {
+			if assigned+len(selected) >= cfg.GetCount() {
+				return selected, nil
+			}
+			selected = append(selected, login)
+		}
+	}
+	if assigned+len(selected) < cfg.GetCount() {
+		logger.Debug("Not enough reviewer candidates", zap.Int("count", cfg.GetCount()), zap.Int("assigned", assigned+len(selected)))
+	}
+	return selected, nil
+}
+
+// changedFileOwners returns the users and teams owning the files the pull
+// request changes, in the notation of the pool. Owners given by email address
+// can't be requested.
+func changedFileOwners(pr *draftPullRequest, owner, repository string, gh *github.Client) ([]string, error) {
+	owners, err := loadCodeOwners(gh, owner, repository, pr.Base.GetRef())
+	if err != nil || owners == nil {
+		return nil, err
+	}
+	files, err := listAllFiles(gh, owner, repository, pr.GetNumber())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list files of pull request %s", pr.GetHTMLURL())
+	}
+
+	var candidates []string
+	for _, file := range files {
+		for _, pathOwner := range owners.Owners(file.GetFilename()) {
+			if strings.HasPrefix(pathOwner, "@") {
+				candidates = append(candidates, pathOwner[1:])
+			}
+		}
+	}
+	return candidates, nil
+}
+
+// expandTeams replaces the "org/team" candidates by the members of the team.
+// Team members are cached in teams.
+func expandTeams(gh *github.Client, candidates []string, teams map[string][]string) ([]string, error) {
+	var logins []string
+	for _, candidate := range candidates {
+		slash := strings.Index(candidate, "/")
+		if slash < 0 {
+			logins = append(logins, candidate)
+			continue
+		}
+		key := strings.ToLower(candidate)
+		members, cached := teams[key]
+		if !cached {
+			users, err := listAllTeamMembers(gh, candidate[:slash], candidate[slash+1:])
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to list members of @%s", candidate)
+			}
+			for _, user := range users {
+				members = append(members, user.GetLogin())
+			}
+			teams[key] = members
+		}
+		logins = append(logins, members...)
+	}
+	return logins, nil
+}
+
+// reviewLoad counts the open review requests of every user in the repository,
+// by lower cased login.
+func reviewLoad(gh *github.Client, owner, repository string) (map[string]int, error) {
+	prs, err := listAllPullRequests(gh, owner, repository, github.PullRequestListOptions{State: "open"})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list open pull requests of %s/%s", owner, repository)
+	}
+	load := make(map[string]int)
+	for _, pr := range prs {
+		for _, user := range pr.RequestedReviewers {
+			load[strings.ToLower(user.GetLogin())]++
+		}
+	}
+	return load, nil
+}
+
+// rankCandidates orders the candidates by their load, if given, then by the
+// time they were last requested, never requested ones first. Ties are broken
+// by login to make the order stable.
+func rankCandidates(candidates []string, last map[string]time.Time, load map[string]int) {
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := strings.ToLower(candidates[i]), strings.ToLower(candidates[j])
+		if load[a] != load[b] {
+			return load[a] < load[b]
+		}
+		if !last[a].Equal(last[b]) {
+			return last[a].Before(last[b])
+		}
+		return a < b
+	})
+}
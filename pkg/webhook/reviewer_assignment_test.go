@@ -0,0 +1,102 @@
+package webhook
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+	"go.uber.org/zap"
+
+	"github.com/syndesisio/pure-bot/pkg/config"
+)
+
+func TestRankCandidates(t *testing.T) {
+	now := time.Now()
+	last := map[string]time.Time{"alice": now, "bob": now.Add(-time.Hour)}
+
+	candidates := []string{"Alice", "bob", "dave", "carol"}
+	rankCandidates(candidates, last, nil)
+	expected := []string{"carol", "dave", "bob", "Alice"}
+	if !reflect.DeepEqual(candidates, expected) {
+		t.Errorf("expected round robin order %v, got %v", expected, candidates)
+	}
+
+	rankCandidates(candidates, last, map[string]int{"carol": 2, "dave": 1})
+	expected = []string{"bob", "Alice", "dave", "carol"}
+	if !reflect.DeepEqual(candidates, expected) {
+		t.Errorf("expected load order %v, got %v", expected, candidates)
+	}
+}
+
+func TestReviewerAssignment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assignment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "assignments.json")
+	if err := LoadReviewerAssignments(file); err != nil {
+		t.Fatal(err)
+	}
+	// Also drops the assignments recorded by the test
+	defer LoadReviewerAssignments("")
+	if err := recordAssignments("o/r", []string{"Erin"}, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	var requested string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/o/r/pulls/1":
+			w.Write([]byte(`{"number": 1, "state": "open", "draft": false, "user": {"login": "author"}, "base": {"ref": "master"},
+				"requested_reviewers": [{"login": "carol"}]}`))
+		case "/repos/o/r/pulls/1/reviews":
+			w.Write([]byte(`[{"user": {"login": "bob"}, "state": "COMMENTED"}]`))
+		case "/orgs/o/teams/core/members":
+			w.Write([]byte(`[{"login": "author"}, {"login": "dave"}, {"login": "Erin"}, {"login": "frank"}]`))
+		case "/repos/o/r/pulls/1/requested_reviewers":
+			body, _ := ioutil.ReadAll(r.Body)
+			requested = string(body)
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	event := &github.PullRequestEvent{
+		Action:      github.String("ready_for_review"),
+		PullRequest: &github.PullRequest{Number: github.Int(1)},
+		Repo:        &github.Repository{Name: github.String("r"), FullName: github.String("o/r"), Owner: &github.User{Login: github.String("o")}},
+	}
+	repoConfig := config.RepoConfig{ReviewerAssignment: config.ReviewerAssignmentConfig{
+		Count:       config.Int(4),
+		Pool:        []string{"bob", "o/core"},
+		OutOfOffice: []string{"Dave"},
+	}}
+
+	if err := (&reviewerAssignment{}).HandleEvent(event, client, repoConfig, zap.NewNop()); err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{"reviewers":["frank","Erin"]}` + "\n"; requested != expected {
+		t.Errorf("expected request %q, got %q", expected, requested)
+	}
+
+	if err := LoadReviewerAssignments(file); err != nil {
+		t.Fatal(err)
+	}
+	last := lastAssigned("O/R")
+	if _, ok := last["frank"]; !ok || len(last) != 2 {
+		t.Errorf("expected persisted assignments of frank and erin, got %v", last)
+	}
+}
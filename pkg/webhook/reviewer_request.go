@@ -153,13 +153,7 @@ func hasLabel(pr *github.PullRequest, label string) bool {
 }
 
 func listReviews(pr *github.PullRequest, repo *github.Repository, gh *github.Client) ([]*github.PullRequestReview, error) {
-	reviews, _, err := gh.PullRequests.ListReviews(
-		context.Background(),
-		repo.Owner.GetLogin(),
-		repo.GetName(),
-		pr.GetNumber(),
-		nil,
-	)
+	reviews, err := listAllReviews(gh, repo.Owner.GetLogin(), repo.GetName(), pr.GetNumber())
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to list reviews for PR %s", pr.GetHTMLURL())
 	}
@@ -168,13 +162,7 @@ func listReviews(pr *github.PullRequest, repo *github.Repository, gh *github.Cli
 }
 
 func listReviewers(pr *github.PullRequest, repo *github.Repository, gh *github.Client) ([]*github.User, error) {
-	reviewers, _, err := gh.PullRequests.ListReviewers(
-		context.Background(),
-		repo.Owner.GetLogin(),
-		repo.GetName(),
-		pr.GetNumber(),
-		nil,
-	)
+	reviewers, err := listAllReviewers(gh, repo.Owner.GetLogin(), repo.GetName(), pr.GetNumber())
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to list reviewers for PR %s", pr.GetHTMLURL())
 	}
@@ -35,6 +35,47 @@ func commentsContainMessage(comments []*github.IssueComment, commentBody string)
 	return false
 }
 
+// findMarkedComment returns the first comment of the bot on the issue
+// containing the marker, usually a hidden HTML comment, or nil if there is
+// none. Comments of others are ignored, even if they quote the marker.
+func findMarkedComment(gh *github.Client, owner, repository string, number int, marker string) (*github.IssueComment, error) {
+	bot := botLogin()
+	if bot == "" {
+		return nil, errors.New("the bot user wasn't identified")
+	}
+	comments, err := listAllComments(gh, owner, repository, number, github.IssueListCommentsOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list comments of %s/%s#%d", owner, repository, number)
+	}
+	for _, comment := range comments {
+		if comment.User.GetType() != "Bot" || !strings.EqualFold(comment.User.GetLogin(), bot) {
+			continue
+		}
+		if strings.Contains(comment.GetBody(), marker) {
+			return comment, nil
+		}
+	}
+	return nil, nil
+}
+
+// upsertComment replaces the body of the existing comment, unless it is
+// unchanged, or creates a new comment if existing is nil.
+func upsertComment(gh *github.Client, owner, repository string, number int, existing *github.IssueComment, body string) error {
+	if existing == nil {
+		if _, _, err := gh.Issues.CreateComment(context.Background(), owner, repository, number, &github.IssueComment{Body: &body}); err != nil {
+			return errors.Wrapf(err, "failed to comment on %s/%s#%d", owner, repository, number)
+		}
+		return nil
+	}
+	if stripSpaces(existing.GetBody()) == stripSpaces(body) {
+		return nil
+	}
+	if _, _, err := gh.Issues.EditComment(context.Background(), owner, repository, existing.GetID(), &github.IssueComment{Body: &body}); err != nil {
+		return errors.Wrapf(err, "failed to update comment %s", existing.GetHTMLURL())
+	}
+	return nil
+}
+
 func stripSpaces(str string) string {
 	return strings.Map(func(r rune) rune {
 		// If the character is a space ('\t', '\n', '\v', '\f', '\r', ' ', U+0085 (NEL), U+00A0 (NBSP)) as per unicode spec, drop it.
@@ -69,6 +110,7 @@ type commitStatus string
 var (
 	pendingStatus commitStatus = "pending"
 	successStatus commitStatus = "success"
+	failureStatus commitStatus = "failure"
 )
 
 func createContextWithSpecifiedStatus(contextName string, status commitStatus, description string, repo *github.Repository, pr *github.PullRequest, gh *github.Client) error {
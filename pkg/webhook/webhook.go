@@ -18,14 +18,16 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"time"
 
 	"github.com/google/go-github/github"
 	"github.com/pkg/errors"
 	"go.uber.org/multierr"
 
-	"github.com/imdario/mergo"
 	"github.com/syndesisio/pure-bot/pkg/config"
+	"github.com/syndesisio/pure-bot/pkg/dedup"
 	"github.com/syndesisio/pure-bot/pkg/github/apps"
+	"github.com/syndesisio/pure-bot/pkg/queue"
 	"go.uber.org/zap"
 	"reflect"
 	//"github.com/davecgh/go-spew/spew"
@@ -39,69 +41,113 @@ type Handler interface {
 	EventTypesHandled() []string
 }
 
+// registeredHandler is a handler with the name it is switched on or off by
+// in the `handlers` section of a repo config.
+type registeredHandler struct {
+	name    string
+	handler Handler
+	// enabled tells whether the handler runs unless configured otherwise
+	enabled bool
+}
+
 var (
 	// List of all handlers used
-	handlers = []Handler{
-		&addLabelOnReviewApproval{},
-		&reviewerRequest{},
-		&autoMerger{},
-		&wip{},
-		&newIssueLabel{},
-		&boardUpdate{},
-		&addReviewUiComment{},
-		//		&dismissReview{},
-		//		&failedStatusCheckAddComment{},
-	}
-	handlerMap map[string][]Handler
+	handlers = []registeredHandler{
+		{"addLabelOnReviewApproval", &addLabelOnReviewApproval{}, true},
+		{"reviewerRequest", &reviewerRequest{}, true},
+		{"reviewerAssignment", &reviewerAssignment{}, true},
+		{"autoMerge", &autoMerger{}, true},
+		{"wip", &wip{}, true},
+		{"newIssueLabel", &newIssueLabel{}, true},
+		{"boardUpdate", &boardUpdate{}, true},
+		{"addReviewUiComment", &addReviewUiComment{}, true},
+		{"repoConfigCheck", &repoConfigCheck{}, true},
+		{"commands", &commandHandler{}, true},
+		{"dismissReview", &dismissReview{}, false},
+		{"failedStatusCheckAddComment", &failedStatusCheckAddComment{}, false},
+	}
+	handlerMap map[string][]registeredHandler
 )
 
 func init() {
-	handlerMap = make(map[string][]Handler)
+	handlerMap = make(map[string][]registeredHandler)
 
 	// Register handlers per event type
-	for _, handler := range handlers {
-		for _, eventType := range handler.EventTypesHandled() {
-			if handlerMap[eventType] == nil {
-				handlerMap[eventType] = make([]Handler, 0)
-			}
-			handlerMap[eventType] = append(handlerMap[eventType], handler)
+	for _, h := range handlers {
+		for _, eventType := range h.handler.EventTypesHandled() {
+			handlerMap[eventType] = append(handlerMap[eventType], h)
+		}
+	}
+}
+
+// HandlerNames returns the names of all handlers, as used in the `handlers`
+// section of a repo config.
+func HandlerNames() []string {
+	names := make([]string, len(handlers))
+	for i, h := range handlers {
+		names[i] = h.name
+	}
+	return names
+}
+
+// EnabledHandlers returns the names of the handlers switched on by config.
+func EnabledHandlers(config config.RepoConfig) []string {
+	var names []string
+	for _, h := range handlers {
+		if config.HandlerEnabled(h.name, h.enabled) {
+			names = append(names, h.name)
 		}
 	}
+	return names
 }
 
-func newGitHubClient(appID int64, privateKeyFile string, installationID int64) (*github.Client, error) {
-	key, err := ioutil.ReadFile(privateKeyFile)
+func newGitHubClient(appCfg config.GitHubAppConfig, installationID int64, middleware ...apps.Middleware) (*github.Client, error) {
+	key, err := ioutil.ReadFile(appCfg.PrivateKeyFile)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to read private key file")
 	}
 
-	return apps.Client(appID, installationID, key)
+	return apps.Client(appCfg.AppID, installationID, key, appCfg.BaseURL, middleware...)
+}
+
+func createClient(appCfg config.GitHubAppConfig, event interface{}, middleware ...apps.Middleware) (*github.Client, error) {
+	installationID, err := extractInstallationID(event)
+	if err != nil {
+		return nil, err
+	}
+	middleware = append([]apps.Middleware{githubAPIMetrics(installationID)}, middleware...)
+	client, err := newGitHubClient(appCfg, installationID, middleware...)
+	if err != nil {
+		return nil, errors.New("cannot create github client")
+	}
+	return client, nil
 }
 
-func createClient(appCfg config.GitHubAppConfig, event interface{}) (*github.Client, error) {
+func extractInstallationID(event interface{}) (int64, error) {
 
 	val := reflect.Indirect(reflect.ValueOf(event))
 	// Find installation via inspection
 	if _, found := val.Type().FieldByName("Installation"); !found {
-		return nil, errors.New("event does not contain an installation ID, cannot create github client")
+		return 0, errors.New("event does not contain an installation ID, cannot create github client")
 	}
 	installation := val.FieldByName("Installation").Interface().(*github.Installation)
 	if installation == nil {
-		return nil, errors.Errorf("no installation in event found, so no GitHub client could be created")
+		return 0, errors.Errorf("no installation in event found, so no GitHub client could be created")
 	}
-	client, err := newGitHubClient(appCfg.AppID, appCfg.PrivateKeyFile, *installation.ID)
-	if err != nil {
-		return nil, errors.New("cannot create github client")
-	}
-	return client, nil
+	return installation.GetID(), nil
 }
 
-func NewGithubHTTPHandler(cfg config.WebhookConfig, config config.Config, logger *zap.Logger) (http.HandlerFunc, error) {
-	webhookSecret := ([]byte)(cfg.Secret)
+// NewGithubHTTPHandler validates incoming webhook deliveries and stores them
+// in the queue. Events are handled asynchronously by Dispatch so that slow
+// handlers don't run into GitHub's delivery timeout. Deliveries whose ID is
+// already known to deliveries are acknowledged but not queued again. The
+// webhook secret is taken from the active configuration of store.
+func NewGithubHTTPHandler(store *config.Store, q *queue.Queue, deliveries *dedup.Cache, logger *zap.Logger) (http.HandlerFunc, error) {
 	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := store.Get().Webhook
 		var payload []byte
 		if cfg.Secret != "" {
-			pl, err := github.ValidatePayload(r, webhookSecret)
+			pl, err := github.ValidatePayload(r, ([]byte)(cfg.Secret))
 			if err != nil {
 				logger.Error("webhook payload validation failed", zap.Error(err))
 				w.WriteHeader(http.StatusUnauthorized)
@@ -120,70 +166,153 @@ func NewGithubHTTPHandler(cfg config.WebhookConfig, config config.Config, logger
 		}
 
 		messageType := github.WebHookType(r)
-		event, err := github.ParseWebHook(messageType, payload)
-		if err != nil {
+		if _, err := github.ParseWebHook(messageType, payload); err != nil {
 			logger.Error("failed to parse webhook", zap.Error(err))
-			w.WriteHeader(http.StatusInternalServerError)
+			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
+		receivedEvents.Inc(messageType, eventAction(payload))
 
-		repo, err := extractRepository(event)
-		if err != nil {
-			logger.Error("Invalid payload", zap.Error(err))
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+		event := &queue.Event{
+			Type:       messageType,
+			DeliveryID: github.DeliveryID(r),
+			Payload:    payload,
 		}
 
-		repoConfig := extractRepoConfigWithDefaults(repo, config)
-		if repo != nil {
-			logger.Debug("Processing event ", zap.String("messageType", messageType), zap.String("repo", *repo.Name))
-		}
-		if repoConfig.Disabled {
-			logger.Info("Disabled by configuration", zap.String("repo", *repo.Name))
-			return
+		if event.DeliveryID != "" {
+			seen, err := deliveries.Seen(event.DeliveryID)
+			if err != nil {
+				logger.Warn("failed to record delivery ID", zap.String("delivery", event.DeliveryID), zap.Error(err))
+			}
+			if seen {
+				logger.Info("Skipping duplicate delivery", zap.String("messageType", messageType), zap.String("delivery", event.DeliveryID))
+				duplicateDeliveries.Inc(messageType)
+				w.WriteHeader(http.StatusOK)
+				return
+			}
 		}
 
-		client, err := createClient(config.GitHubApp, event)
-		if err != nil {
-			logger.Error("failed to create GitHub client", zap.Error(err))
+		if err := q.Push(event); err != nil {
+			logger.Error("failed to queue webhook", zap.String("delivery", event.DeliveryID), zap.Error(err))
+			if err := deliveries.Forget(event.DeliveryID); err != nil {
+				logger.Warn("failed to forget delivery ID", zap.String("delivery", event.DeliveryID), zap.Error(err))
+			}
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		// ========================================================================
-		// Call all handlers
-		for _, wh := range handlerMap[messageType] {
-			logger.Debug("call handler", zap.String("type", messageType), zap.String("handler", reflect.TypeOf(wh).String()))
-			err = multierr.Combine(err, wh.HandleEvent(event, client, *repoConfig, logger))
-		}
+		logger.Debug("Queued event", zap.String("messageType", messageType), zap.String("delivery", event.DeliveryID), zap.String("event", event.ID))
+		w.WriteHeader(http.StatusAccepted)
+	}, nil
+}
+
+// Dispatch parses the payload of a webhook event and calls all handlers
+// registered for its type, except the ones listed in its Handled field. The
+// handlers which succeed are added there, so that a retry only calls the
+// ones which failed. The returned error combines the errors of all failed
+// handlers.
+func Dispatch(queued *queue.Event, config config.Config, logger *zap.Logger) error {
+	messageType, payload := queued.Type, queued.Payload
+	if len(handlerMap[messageType]) == 0 {
+		logger.Debug("No handler registered", zap.String("messageType", messageType))
+		return nil
+	}
+
+	event, err := github.ParseWebHook(messageType, payload)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse webhook")
+	}
+
+	repo, err := extractRepository(event)
+	if err != nil {
+		return errors.Wrap(err, "invalid payload")
+	}
+
+	repoConfig := extractRepoConfigWithDefaults(repo, config, logger)
+	if repo != nil {
+		logger.Debug("Processing event ", zap.String("messageType", messageType), zap.String("repo", *repo.Name))
+	}
+	if repoConfig.IsDisabled() {
+		logger.Info("Disabled by configuration", zap.String("repo", *repo.Name))
+		return nil
+	}
 
-		// =========================================================================
+	var middleware []apps.Middleware
+	if repoConfig.IsDryRun() {
+		logger.Info("Dry run, no changes will be made", zap.String("messageType", messageType), zap.String("repo", repo.GetFullName()))
+		middleware = append(middleware, dryRun(logger))
+	}
+
+	client, err := createClient(config.GitHubApp, event, middleware...)
+	if err != nil {
+		return errors.Wrap(err, "failed to create GitHub client")
+	}
+	if err := identifyBot(config.GitHubApp); err != nil {
+		logger.Warn("Failed to identify the bot user, its comments won't be found", zap.Error(err))
+	}
 
+	if repo != nil {
+		if installationID, err := extractInstallationID(event); err == nil {
+			if err := rememberInstallation(repo.GetFullName(), installationID); err != nil {
+				logger.Warn("Failed to persist installation", zap.String("repo", repo.GetFullName()), zap.Error(err))
+			}
+		}
+		repoFile, err := loadRepoConfigFile(client, repo, logger)
 		if err != nil {
-			logger.Error("webhook handler failed", zap.String("error", fmt.Sprintf("%+v", err)))
-			w.WriteHeader(http.StatusInternalServerError)
+			logger.Warn("Ignoring repo config file", zap.String("repo", repo.GetFullName()), zap.Error(err))
+		} else if repoFile != nil {
+			logger.Debug("Merged repo config file", zap.String("repo", repo.GetFullName()))
+			repoConfig.MergeRepoFile(*repoFile)
 		}
-	}, nil
-}
-
-func extractRepoConfigWithDefaults(repo *github.Repository, fullConfig config.Config) *config.RepoConfig {
+	}
 
-	ret := &config.RepoConfig{
-		Disabled: false,
+	if err := runHandlers(handlerMap[messageType], queued, event, client, *repoConfig, logger); err != nil {
+		return errors.Wrap(err, "webhook handler failed")
 	}
+	return nil
+}
 
-	if repo == nil {
-		return ret
+// runHandlers calls the handlers enabled for the repository which aren't
+// listed as handled by the queued event yet and adds the ones which succeed.
+func runHandlers(hs []registeredHandler, queued *queue.Event, event interface{}, client *github.Client, repoConfig config.RepoConfig, logger *zap.Logger) error {
+	messageType := queued.Type
+	var err error
+	for _, h := range hs {
+		wh := h.handler
+		handlerName := reflect.TypeOf(wh).String()
+		if !repoConfig.HandlerEnabled(h.name, h.enabled) {
+			logger.Debug("handler disabled by configuration", zap.String("type", messageType), zap.String("handler", h.name))
+			continue
+		}
+		if containsFold(queued.Handled, h.name) {
+			logger.Debug("handler already succeeded", zap.String("type", messageType), zap.String("handler", h.name))
+			continue
+		}
+		logger.Debug("call handler", zap.String("type", messageType), zap.String("handler", handlerName))
+
+		start := time.Now()
+		handlerErr := wh.HandleEvent(event, client, repoConfig, logger)
+		handlerDuration.Observe(time.Since(start).Seconds(), handlerName, messageType)
+		handlerInvocations.Inc(handlerName, messageType)
+		if handlerErr != nil {
+			handlerFailures.Inc(handlerName, messageType)
+			err = multierr.Append(err, handlerErr)
+			continue
+		}
+		queued.Handled = append(queued.Handled, h.name)
 	}
+	return err
+}
 
-	var repoSpecificConfig config.RepoConfig
-	if len(fullConfig.Repos) > 0 {
-		repoSpecificConfig = fullConfig.Repos[*repo.Name]
+func extractRepoConfigWithDefaults(repo *github.Repository, fullConfig config.Config, logger *zap.Logger) *config.RepoConfig {
+
+	if repo == nil {
+		return &config.RepoConfig{}
 	}
 
-	mergo.Merge(ret, fullConfig.DefaultRepo, mergo.WithOverride)
-	mergo.Merge(ret, repoSpecificConfig, mergo.WithOverride)
-	return ret
+	ret, layers := fullConfig.RepoConfigFor(repo.GetOwner().GetLogin(), repo.GetName())
+	logger.Debug("Merged repo config", zap.String("repo", repo.GetFullName()), zap.Strings("layers", layers))
+	return &ret
 }
 
 func extractRepository(event interface{}) (*github.Repository, error) {
@@ -195,5 +324,23 @@ func extractRepository(event interface{}) (*github.Repository, error) {
 		return nil, fmt.Errorf("repository not found")
 	}
 
-	return val.FieldByName("Repo").Interface().(*github.Repository), nil
+	switch repo := val.FieldByName("Repo").Interface().(type) {
+	case *github.Repository:
+		return repo, nil
+	case *github.PushEventRepository:
+		// Push events describe the repository differently
+		if repo == nil {
+			return nil, nil
+		}
+		return &github.Repository{
+			ID:            repo.ID,
+			Name:          repo.Name,
+			FullName:      repo.FullName,
+			Owner:         &github.User{Login: repo.GetOwner().Name},
+			DefaultBranch: repo.DefaultBranch,
+			HTMLURL:       repo.HTMLURL,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported repository type %T", repo)
+	}
 }
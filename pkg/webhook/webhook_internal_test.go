@@ -1,9 +1,17 @@
 package webhook
 
 import (
+	"errors"
+	"reflect"
 	"strconv"
 	"strings"
 	"testing"
+
+	"github.com/google/go-github/github"
+	"go.uber.org/zap"
+
+	"github.com/syndesisio/pure-bot/pkg/config"
+	"github.com/syndesisio/pure-bot/pkg/queue"
 )
 
 func TestIssueRegex(t *testing.T) {
@@ -81,3 +89,40 @@ func TestIssueRegex(t *testing.T) {
 	}
 
 }
+
+type countingHandler struct {
+	calls    int
+	failures int
+}
+
+func (h *countingHandler) HandleEvent(eventObject interface{}, client *github.Client, config config.RepoConfig, logger *zap.Logger) error {
+	h.calls++
+	if h.calls <= h.failures {
+		return errors.New("failed")
+	}
+	return nil
+}
+
+func (h *countingHandler) EventTypesHandled() []string {
+	return []string{"status"}
+}
+
+func TestRunHandlersRetriesOnlyFailed(t *testing.T) {
+	succeeding, failing := &countingHandler{}, &countingHandler{failures: 1}
+	hs := []registeredHandler{{"succeeding", succeeding, true}, {"failing", failing, true}}
+	queued := &queue.Event{Type: "status"}
+
+	if err := runHandlers(hs, queued, nil, nil, config.RepoConfig{}, zap.NewNop()); err == nil {
+		t.Fatal("expected the failing handler's error")
+	}
+	if !reflect.DeepEqual(queued.Handled, []string{"succeeding"}) {
+		t.Errorf("expected only the succeeding handler to be recorded, got %v", queued.Handled)
+	}
+
+	if err := runHandlers(hs, queued, nil, nil, config.RepoConfig{}, zap.NewNop()); err != nil {
+		t.Fatal(err)
+	}
+	if succeeding.calls != 1 || failing.calls != 2 {
+		t.Errorf("expected the succeeding handler to be called once and the failing one twice, got %d and %d", succeeding.calls, failing.calls)
+	}
+}
@@ -18,7 +18,6 @@ import (
 	"regexp"
 	"strings"
 
-	"context"
 	"github.com/google/go-github/github"
 	"github.com/pkg/errors"
 	"github.com/syndesisio/pure-bot/pkg/config"
@@ -76,7 +75,11 @@ func titleMatchesWipExpression(config config.RepoConfig, title string) string {
 		return ""
 	}
 	for _, pattern := range config.WipPatterns {
-		var wipRE = regexp.MustCompile(`(?i)\b(?:` + pattern + `)\b`)
+		wipRE, err := regexp.Compile(`(?i)\b(?:` + pattern + `)\b`)
+		if err != nil {
+			// Rejected when the config is loaded, never panic on it here
+			continue
+		}
 		if found := wipRE.FindString(title); found != "" {
 			return found
 		}
@@ -85,13 +88,7 @@ func titleMatchesWipExpression(config config.RepoConfig, title string) string {
 }
 
 func prIsLabelledWithOneOfSpecifiedLabels(pr *github.PullRequest, specifiedLabels []string, repo *github.Repository, gh *github.Client) (string, error) {
-	labels, _, err := gh.Issues.ListLabelsByIssue(
-		context.Background(),
-		repo.Owner.GetLogin(),
-		repo.GetName(),
-		pr.GetNumber(),
-		nil,
-	)
+	labels, err := listAllLabels(gh, repo.Owner.GetLogin(), repo.GetName(), pr.GetNumber())
 	if err != nil {
 		return "", errors.Wrapf(err, "failed to list labels for PR %s", pr.GetHTMLURL())
 	}
@@ -2,10 +2,14 @@ package webhook
 
 import (
 	"fmt"
+	"github.com/go-resty/resty"
+	"github.com/pkg/errors"
 	"github.com/syndesisio/pure-bot/pkg/config"
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
 	"log"
 	"net/http"
+	"sort"
 )
 
 func NewZenhubHTTPHandler(cfg config.WebhookConfig, config config.Config, logger *zap.Logger) (http.HandlerFunc, error) {
@@ -46,3 +50,49 @@ func debug(data []byte, err error) {
 		log.Fatalf("%s\n\n", err)
 	}
 }
+
+// CheckZenhubTokens verifies that the ZenHub token of the defaults and of
+// every repo with a board configured grants access to that board.
+func CheckZenhubTokens(cfg config.Config) error {
+	names := make([]string, 0, len(cfg.Repos))
+	for name := range cfg.Repos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var err error
+	checked := make(map[[2]string]bool)
+	check := func(board config.Board, format string, args ...interface{}) {
+		if board.GithubRepo == "" || board.GithubRepo == "<repo>" {
+			return
+		}
+		key := [2]string{board.ZenhubToken, board.GithubRepo}
+		if checked[key] {
+			return
+		}
+		checked[key] = true
+		err = multierr.Append(err, errors.Wrapf(checkZenhubToken(board), format, args...))
+	}
+
+	check(cfg.DefaultRepo.Board, "defaults")
+	for _, name := range names {
+		check(cfg.RepoEntry(name).Board, "repo %s", name)
+	}
+	return err
+}
+
+func checkZenhubToken(board config.Board) error {
+	url := zenHubApi + "/p1/repositories/" + board.GithubRepo + "/board"
+	response, err := resty.R().
+		SetHeader("X-Authentication-Token", board.ZenhubToken).
+		Get(url)
+	if err != nil {
+		zenhubRequests.Inc("get_board", zenhubOutcome(0, err))
+		return errors.Wrap(err, "ZenHub not reachable")
+	}
+	zenhubRequests.Inc("get_board", zenhubOutcome(response.StatusCode(), nil))
+	if response.StatusCode() >= 400 {
+		return errors.Errorf("ZenHub returned HTTP %d for board of repository %s", response.StatusCode(), board.GithubRepo)
+	}
+	return nil
+}